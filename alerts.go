@@ -0,0 +1,376 @@
+package gogadgets
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cswank/gogadgets/rcl"
+)
+
+//The states of an Alert.
+const (
+	AlertFiring  = "firing"
+	AlertRepeat  = "repeat"
+	AlertCleared = "cleared"
+)
+
+var (
+	alertFor       = regexp.MustCompile(`^(.+?)\s+for\s+(?:more\s+than\s+)?(\S+)\s+(\S+)$`)
+	stateCondition = regexp.MustCompile(`^\s*(.+?)\s+(on|off)\s*$`)
+)
+
+//AlertRule is one of the rules of an alerts gadget.  When is a
+//condition on a sensor (or an output being on or off) and how long
+//it has to last:
+//
+//	freezer temperature > -10 C for 5 minutes
+//	hlt heater on for more than 2 hours
+//
+//Once an alert is firing it only clears when the value is back past
+//the threshold by Hysteresis (in the units of the rule), so a value
+//that hovers around it doesn't keep sending notifications.  Repeat
+//(a duration like "30m") sends the alert again for as long as it is
+//firing.  Notify names the notifiers to send it to (all of them if
+//it's empty).
+type AlertRule struct {
+	Name       string   `json:"name,omitempty"`
+	When       string   `json:"when"`
+	Hysteresis float64  `json:"hysteresis,omitempty"`
+	Repeat     string   `json:"repeat,omitempty"`
+	Notify     []string `json:"notify,omitempty"`
+}
+
+//Alert is what gets sent to the notifiers when a rule starts
+//firing, repeats and clears.
+type Alert struct {
+	Rule   string    `json:"rule"`
+	When   string    `json:"when"`
+	State  string    `json:"state"`
+	Sensor string    `json:"sensor"`
+	Value  Value     `json:"value"`
+	Since  time.Time `json:"since"`
+	Time   time.Time `json:"time"`
+}
+
+//Subject is a one line summary of the alert.
+func (a Alert) Subject() string {
+	return fmt.Sprintf("%s: %s", strings.ToUpper(a.State), a.Rule)
+}
+
+func (a Alert) String() string {
+	v := fmt.Sprintf("%v", a.Value.Value)
+	if b, ok := a.Value.Value.(bool); ok {
+		v = "off"
+		if b {
+			v = "on"
+		}
+	} else if a.Value.Units != "" {
+		v = fmt.Sprintf("%s %s", v, a.Value.Units)
+	}
+	s := a.Subject()
+	if a.When != a.Rule {
+		s = fmt.Sprintf("%s (%s)", s, a.When)
+	}
+	return fmt.Sprintf("%s, %s is %s since %s", s, a.Sensor, v, a.Since.Format(time.RFC3339))
+}
+
+//Alerts watches the updates of the sensors in its rules and sends
+//an Alert to its notifiers (see NotifierConfig) when one of them
+//fires.  It is configured like cron:
+//
+//	{
+//	    "type": "alerts",
+//	    "args": {
+//	        "rules": [
+//	            "hlt heater on for more than 2 hours",
+//	            {"name": "freezer", "when": "freezer temperature > -10 C for 5 minutes", "hysteresis": 2, "repeat": "1h"}
+//	        ],
+//	        "notifiers": {
+//	            "email": {"type": "smtp", "addr": "smtp.example.com:587", "from": "gadgets@example.com", "to": ["me@example.com"]},
+//	            "phone": {"type": "webhook", "url": "https://ntfy.sh/my-gadgets"}
+//	        }
+//	    }
+//	}
+type Alerts struct {
+	watcher
+	rules     []*alertRule
+	notifiers map[string]Notifier
+	wg        sync.WaitGroup
+}
+
+type alertRule struct {
+	AlertRule
+	cond     *rcl.Condition
+	duration time.Duration
+	repeat   time.Duration
+	match    comparitor
+	clear    comparitor
+	value    Value
+	since    *time.Time
+	firing   bool
+	notified time.Time
+}
+
+func NewAlerts(config *GadgetConfig, options ...func(*Alerts) error) (*Alerts, error) {
+	a := &Alerts{
+		watcher:   newWatcher("alerts"),
+		notifiers: map[string]Notifier{},
+	}
+	var args map[string]interface{}
+	if config != nil {
+		args = config.Args
+	}
+	cfgs := map[string]NotifierConfig{}
+	if n, ok := args["notifiers"]; ok {
+		d, _ := json.Marshal(n)
+		if err := json.Unmarshal(d, &cfgs); err != nil {
+			return nil, fmt.Errorf("could not parse the alert notifiers: %s", err)
+		}
+	}
+	for name, cfg := range cfgs {
+		n, err := NewNotifier(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not make notifier %s: %s", name, err)
+		}
+		a.notifiers[name] = n
+	}
+
+	for _, opt := range options {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	rules, err := getAlertRules(args["rules"])
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		ar, err := newAlertRule(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range ar.Notify {
+			if _, ok := a.notifiers[n]; !ok {
+				return nil, fmt.Errorf("unknown notifier for alert %s: %s", ar.Name, n)
+			}
+		}
+		a.rules = append(a.rules, ar)
+		a.watch(ar.cond.Sensor)
+	}
+	return a, nil
+}
+
+//AlertsTick sets how often the rules are checked (to see if they
+//have lasted long enough, or have to be repeated) between updates.
+func AlertsTick(d time.Duration) func(*Alerts) error {
+	return func(a *Alerts) error {
+		return a.setTick(d)
+	}
+}
+
+//AlertsNotifier adds a notifier (one that isn't in the config).
+func AlertsNotifier(name string, n Notifier) func(*Alerts) error {
+	return func(a *Alerts) error {
+		a.notifiers[name] = n
+		return nil
+	}
+}
+
+//Rules can be a rule (a string) or an AlertRule.
+func getAlertRules(r interface{}) ([]AlertRule, error) {
+	if r == nil {
+		return nil, nil
+	}
+	d, _ := json.Marshal(r)
+	var raw []json.RawMessage
+	if err := json.Unmarshal(d, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse the alert rules: %s", err)
+	}
+	rules := make([]AlertRule, len(raw))
+	for i, item := range raw {
+		if err := json.Unmarshal(item, &rules[i].When); err == nil {
+			continue
+		}
+		if err := json.Unmarshal(item, &rules[i]); err != nil {
+			return nil, fmt.Errorf("could not parse alert rule: %s: %s", item, err)
+		}
+	}
+	return rules, nil
+}
+
+func newAlertRule(r AlertRule) (*alertRule, error) {
+	cond, d, err := parseAlert(r.When)
+	if err != nil {
+		return nil, err
+	}
+	ar := &alertRule{AlertRule: r, cond: cond, duration: d}
+	if ar.Name == "" {
+		ar.Name = r.When
+	}
+	if r.Repeat != "" {
+		if ar.repeat, err = time.ParseDuration(r.Repeat); err != nil {
+			return nil, fmt.Errorf("could not parse the repeat of alert %s: %s", ar.Name, err)
+		}
+	}
+	if ar.match, err = getCompare(cond.Operator, cond.Value, cond.Units); err != nil {
+		return nil, err
+	}
+	ar.clear = ar.getClear()
+	return ar, nil
+}
+
+//parseAlert parses the condition of a rule and how long it has to
+//last.
+func parseAlert(s string) (*rcl.Condition, time.Duration, error) {
+	cond := strings.TrimSpace(s)
+	var d time.Duration
+	if m := alertFor.FindStringSubmatch(cond); m != nil {
+		v, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not parse alert: %s: expected a number after 'for', got '%s'", s, m[2])
+		}
+		if d, err = (Quantity{Value: v, Units: m[3]}).Duration(); err != nil {
+			return nil, 0, fmt.Errorf("could not parse alert: %s: %s", s, err)
+		}
+		cond = m[1]
+	}
+	c, err := parseCondition(cond)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not parse alert: %s: %s", s, err)
+	}
+	return c, d, nil
+}
+
+//parseCondition parses a condition, 'hlt heater on' is the same
+//as 'hlt heater == true'.
+func parseCondition(s string) (*rcl.Condition, error) {
+	if m := stateCondition.FindStringSubmatch(s); m != nil && !strings.ContainsAny(s, "<>=!") {
+		s = fmt.Sprintf("%s == %t", m[1], m[2] == "on")
+	}
+	return rcl.ParseCondition(s)
+}
+
+//getClear returns the comparison that clears the alert.  Without
+//hysteresis that's just the rule not matching any more.
+func (r *alertRule) getClear() comparitor {
+	not := func(x *Value) bool { return !r.match(x) }
+	v, ok := r.cond.Value.(float64)
+	if !ok || r.Hysteresis == 0 {
+		return not
+	}
+	var cmp comparitor
+	switch r.cond.Operator {
+	case ">", ">=":
+		cmp, _ = getCompare("<", v-r.Hysteresis, r.cond.Units)
+	case "<", "<=":
+		cmp, _ = getCompare(">", v+r.Hysteresis, r.cond.Units)
+	default:
+		return not
+	}
+	return cmp
+}
+
+//update takes a new value of the sensor, it returns an alert if
+//the rule cleared or fired.
+func (r *alertRule) update(val Value, now time.Time) (Alert, bool) {
+	r.value = val
+	if r.match(&val) {
+		if r.since == nil {
+			r.since = &now
+		}
+	} else if !r.firing || r.clear(&val) {
+		since := r.since
+		r.since = nil
+		if r.firing {
+			r.firing = false
+			return r.alert(AlertCleared, *since, now), true
+		}
+	}
+	return r.check(now)
+}
+
+//check fires the alert once the rule has matched long enough, and
+//repeats it.
+func (r *alertRule) check(now time.Time) (Alert, bool) {
+	if r.since == nil {
+		return Alert{}, false
+	}
+	if !r.firing && now.Sub(*r.since) >= r.duration {
+		r.firing = true
+		r.notified = now
+		return r.alert(AlertFiring, *r.since, now), true
+	}
+	if r.firing && r.repeat > 0 && now.Sub(r.notified) >= r.repeat {
+		r.notified = now
+		return r.alert(AlertRepeat, *r.since, now), true
+	}
+	return Alert{}, false
+}
+
+func (r *alertRule) alert(state string, since, now time.Time) Alert {
+	return Alert{
+		Rule:   r.Name,
+		When:   r.When,
+		State:  state,
+		Sensor: r.cond.Sensor,
+		Value:  r.value,
+		Since:  since,
+		Time:   now,
+	}
+}
+
+//Start watches the sensors until shutdown, then waits for the
+//notifiers that are still sending.
+func (a *Alerts) Start(in <-chan Message, out chan<- Message) {
+	a.run(in, a.update, a.check)
+	a.wg.Wait()
+}
+
+func (a *Alerts) update(msg Message, now time.Time) {
+	for _, r := range a.rules {
+		if r.cond.Sensor != msg.Sender {
+			continue
+		}
+		if al, ok := r.update(msg.Value, now); ok {
+			a.notify(r, al)
+		}
+	}
+}
+
+//check fires the rules that have lasted long enough and repeats
+//the ones that are due.
+func (a *Alerts) check(now time.Time) {
+	for _, r := range a.rules {
+		if al, ok := r.check(now); ok {
+			a.notify(r, al)
+		}
+	}
+}
+
+//notify sends the alert to the notifiers of the rule in the
+//background (so a slow mail server doesn't hold up the updates).
+func (a *Alerts) notify(r *alertRule, al Alert) {
+	log.Println(al)
+	names := r.Notify
+	if len(names) == 0 {
+		for name := range a.notifiers {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		a.wg.Add(1)
+		go func(name string, n Notifier) {
+			defer a.wg.Done()
+			if err := n.Notify(al); err != nil {
+				log.Printf("couldn't send alert %s to %s: %s", al.Rule, name, err)
+			}
+		}(name, a.notifiers[name])
+	}
+}
@@ -0,0 +1,317 @@
+package gogadgets_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeNotifier struct {
+	lock   sync.Mutex
+	alerts []gogadgets.Alert
+}
+
+func (f *fakeNotifier) Notify(a gogadgets.Alert) error {
+	f.lock.Lock()
+	f.alerts = append(f.alerts, a)
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *fakeNotifier) states() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var s []string
+	for _, a := range f.alerts {
+		s = append(s, a.State)
+	}
+	return s
+}
+
+//fakeSMTP is just enough of an smtp server for net/smtp to send
+//it mail.
+func fakeSMTP(l net.Listener, mails chan<- string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+			var data bool
+			var mail []string
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if data {
+					if line == ".\r\n" {
+						data = false
+						mails <- strings.Join(mail, "")
+						fmt.Fprint(conn, "250 OK\r\n")
+					} else {
+						mail = append(mail, line)
+					}
+					continue
+				}
+				switch strings.ToUpper(strings.Fields(line)[0]) {
+				case "EHLO", "HELO":
+					fmt.Fprint(conn, "250 localhost\r\n")
+				case "DATA":
+					data = true
+					fmt.Fprint(conn, "354 go ahead\r\n")
+				case "QUIT":
+					fmt.Fprint(conn, "221 bye\r\n")
+					return
+				default:
+					fmt.Fprint(conn, "250 OK\r\n")
+				}
+			}
+		}(conn)
+	}
+}
+
+var _ = Describe("Alerts", func() {
+	var (
+		in  chan gogadgets.Message
+		out chan gogadgets.Message
+		n   *fakeNotifier
+	)
+
+	BeforeEach(func() {
+		in = make(chan gogadgets.Message)
+		out = make(chan gogadgets.Message)
+		n = &fakeNotifier{}
+	})
+
+	start := func(rules string) {
+		cfg := gadgetConfig("alerts", fmt.Sprintf(`{"rules": %s}`, rules))
+		a, err := gogadgets.NewAlerts(cfg, gogadgets.AlertsTick(10*time.Millisecond), gogadgets.AlertsNotifier("fake", n))
+		Expect(err).To(BeNil())
+		go a.Start(in, out)
+	}
+
+	It("fires once the condition has lasted and clears past the hysteresis", func() {
+		start(`[{"name": "freezer", "when": "freezer temperature > -10 C for 0.1 seconds", "hysteresis": 1}]`)
+		defer stop(in)
+
+		in <- sensorUpdate("freezer", "temperature", 17.6, "F")
+		Consistently(n.states, 50*time.Millisecond).Should(BeEmpty())
+		Eventually(n.states).Should(Equal([]string{gogadgets.AlertFiring}))
+		n.lock.Lock()
+		a := n.alerts[0]
+		n.lock.Unlock()
+		Expect(a.Rule).To(Equal("freezer"))
+		Expect(a.Sensor).To(Equal("freezer temperature"))
+		Expect(a.Value).To(Equal(gogadgets.Value{Value: 17.6, Units: "F"}))
+		Expect(a.Time.Sub(a.Since)).To(BeNumerically(">=", 100*time.Millisecond))
+
+		in <- sensorUpdate("freezer", "temperature", -10.5, "C")
+		Consistently(n.states, 50*time.Millisecond).Should(HaveLen(1))
+		in <- sensorUpdate("freezer", "temperature", -11.5, "C")
+		Eventually(n.states).Should(Equal([]string{gogadgets.AlertFiring, gogadgets.AlertCleared}))
+	})
+
+	It("doesn't fire when the condition doesn't last", func() {
+		start(`["freezer temperature > -10 C for 0.1 seconds"]`)
+		defer stop(in)
+
+		in <- sensorUpdate("freezer", "temperature", -8.0, "C")
+		in <- sensorUpdate("freezer", "temperature", -12.0, "C")
+		Consistently(n.states, 200*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("repeats an alert on an output until it is off", func() {
+		start(`[{"when": "hlt heater on for more than 0.05 seconds", "repeat": "50ms"}]`)
+		defer stop(in)
+
+		in <- sensorUpdate("hlt", "heater", false, "")
+		in <- sensorUpdate("hlt", "heater", true, "")
+		Eventually(func() int { return len(n.states()) }).Should(BeNumerically(">=", 3))
+		in <- sensorUpdate("hlt", "heater", false, "")
+		Eventually(func() string {
+			s := n.states()
+			return s[len(s)-1]
+		}).Should(Equal(gogadgets.AlertCleared))
+		s := n.states()
+		Expect(s[0]).To(Equal(gogadgets.AlertFiring))
+		Expect(s[1]).To(Equal(gogadgets.AlertRepeat))
+	})
+
+	It("won't start with rules it can't parse", func() {
+		for _, rules := range []string{
+			`["freezer temperature is cold"]`,
+			`["freezer temperature > -10 C for 5 liters"]`,
+			`[{"when": "freezer temperature > -10 C", "notify": ["pager"]}]`,
+			`[{"when": "freezer temperature > -10 C", "repeat": "often"}]`,
+		} {
+			_, err := gogadgets.NewAlerts(gadgetConfig("alerts", fmt.Sprintf(`{"rules": %s}`, rules)))
+			Expect(err).ToNot(BeNil(), rules)
+		}
+	})
+
+	Describe("notifiers", func() {
+		var alert gogadgets.Alert
+
+		BeforeEach(func() {
+			alert = gogadgets.Alert{
+				Rule:   "freezer",
+				When:   "freezer temperature > -10 C for 5 minutes",
+				State:  gogadgets.AlertFiring,
+				Sensor: "freezer temperature",
+				Value:  gogadgets.Value{Value: -8.5, Units: "C"},
+				Since:  time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC),
+				Time:   time.Date(2026, 10, 18, 6, 5, 0, 0, time.UTC),
+			}
+		})
+
+		It("posts the alert to a webhook", func() {
+			var body []byte
+			var auth string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				auth = r.Header.Get("Authorization")
+			}))
+			defer ts.Close()
+
+			x, err := gogadgets.NewNotifier(gogadgets.NotifierConfig{Type: "webhook", URL: ts.URL, Token: "Bearer abc"})
+			Expect(err).To(BeNil())
+			Expect(x.Notify(alert)).To(BeNil())
+			var a gogadgets.Alert
+			Expect(json.Unmarshal(body, &a)).To(BeNil())
+			Expect(a.Rule).To(Equal("freezer"))
+			Expect(a.State).To(Equal(gogadgets.AlertFiring))
+			Expect(a.Value.Value).To(Equal(-8.5))
+			Expect(auth).To(Equal("Bearer abc"))
+		})
+
+		It("runs a command", func() {
+			tmp, err := ioutil.TempDir("", "")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(tmp)
+			p := filepath.Join(tmp, "alert")
+
+			x, err := gogadgets.NewNotifier(gogadgets.NotifierConfig{
+				Type:    "command",
+				Command: []string{"sh", "-c", `echo "$GADGETS_ALERT_STATE $GADGETS_ALERT_VALUE $GADGETS_ALERT_UNITS" > $0; cat >> $0`, p},
+			})
+			Expect(err).To(BeNil())
+			Expect(x.Notify(alert)).To(BeNil())
+			d, err := ioutil.ReadFile(p)
+			Expect(err).To(BeNil())
+			Expect(string(d)).To(Equal(`firing -8.5 C
+FIRING: freezer (freezer temperature > -10 C for 5 minutes), freezer temperature is -8.5 C since 2026-10-18T06:00:00Z
+`))
+
+			x, _ = gogadgets.NewNotifier(gogadgets.NotifierConfig{Type: "command", Command: []string{"sh", "-c", "exit 3"}})
+			Expect(x.Notify(alert)).ToNot(BeNil())
+		})
+
+		It("sends an email", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			defer l.Close()
+			mails := make(chan string, 1)
+			go fakeSMTP(l, mails)
+
+			x, err := gogadgets.NewNotifier(gogadgets.NotifierConfig{
+				Type: "smtp",
+				Addr: l.Addr().String(),
+				From: "gadgets@example.com",
+				To:   []string{"me@example.com"},
+			})
+			Expect(err).To(BeNil())
+			Expect(x.Notify(alert)).To(BeNil())
+			var mail string
+			Eventually(mails).Should(Receive(&mail))
+			Expect(mail).To(ContainSubstring("Subject: [gogadgets] FIRING: freezer\r\n"))
+			Expect(mail).To(ContainSubstring("To: me@example.com\r\n"))
+			Expect(mail).To(ContainSubstring("freezer temperature is -8.5 C"))
+		})
+
+		It("gives up on a mail server that doesn't answer", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			defer l.Close()
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+				}
+			}()
+
+			x, err := gogadgets.NewNotifier(gogadgets.NotifierConfig{
+				Type:    "smtp",
+				Addr:    l.Addr().String(),
+				From:    "gadgets@example.com",
+				To:      []string{"me@example.com"},
+				Timeout: "50ms",
+			})
+			Expect(err).To(BeNil())
+			began := time.Now()
+			Expect(x.Notify(alert)).ToNot(BeNil())
+			Expect(time.Since(began)).To(BeNumerically("<", time.Second))
+		})
+
+		It("need an smtp timeout longer than 0", func() {
+			for _, t := range []string{"0s", "-1m", "soon"} {
+				_, err := gogadgets.NewNotifier(gogadgets.NotifierConfig{
+					Type:    "smtp",
+					Addr:    "smtp.example.com:587",
+					From:    "gadgets@example.com",
+					To:      []string{"me@example.com"},
+					Timeout: t,
+				})
+				Expect(err).ToNot(BeNil())
+			}
+		})
+
+		It("are made from the config of the gadget", func() {
+			var lock sync.Mutex
+			var posts int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				posts++
+				lock.Unlock()
+			}))
+			defer ts.Close()
+
+			g, err := gogadgets.NewGadget(&gogadgets.GadgetConfig{
+				Type: "alerts",
+				Args: map[string]interface{}{
+					"rules":     []interface{}{"freezer temperature > -10 C"},
+					"notifiers": map[string]interface{}{"hook": map[string]interface{}{"type": "webhook", "url": ts.URL}},
+				},
+			})
+			Expect(err).To(BeNil())
+			Expect(g.GetUID()).To(Equal("alerts"))
+			go g.Start(in, out)
+			defer stop(in)
+			in <- sensorUpdate("freezer", "temperature", -8.0, "C")
+			Eventually(func() int {
+				lock.Lock()
+				defer lock.Unlock()
+				return posts
+			}).Should(Equal(1))
+		})
+	})
+})
@@ -1,10 +1,12 @@
 package gogadgets
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
+	"sync"
 )
 
 var (
@@ -15,10 +17,18 @@ var (
 //to them, and receiving Messages from them.  It is the
 //central part of Gadgets system.
 type App struct {
-	gadgets []Gadgeter
-	master  string
-	host    string
-	port    int
+	gadgets  []Gadgeter
+	master   string
+	host     string
+	port     int
+	stop     chan bool
+	stopOnce sync.Once
+	done     chan bool
+	srv      *Server
+	broker   []func(*Broker)
+	journal  *Journal
+	history  *History
+	server   []func(*Server)
 }
 
 //NewApp creates a new Gadgets system.  The cfg argument can be a
@@ -33,14 +43,49 @@ func NewApp(cfg interface{}, gadgets ...Gadgeter) *App {
 	if config.Port == 0 {
 		config.Port = 6111
 	}
+	if config.Platform != "" {
+		for i := range config.Gadgets {
+			config.Gadgets[i].Pin.Platform = config.Platform
+		}
+	}
 
 	a := &App{
 		master: config.Master,
 		host:   config.Host,
 		port:   config.Port,
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+	opts, err := config.Broker.options()
+	if err != nil {
+		lg.Fatal(err)
+	}
+	a.broker = opts
+	if err := config.Auth.check(); err != nil {
+		lg.Fatal(err)
+	}
+	a.server = []func(*Server){ServerAuth(config.Auth), ServerTLS(config.TLS)}
+	if config.Journal.Path != "" {
+		j, err := NewJournal(config.Journal.Path, config.Journal.MaxSize, config.Journal.Keep)
+		if err != nil {
+			lg.Fatal(err)
+		}
+		a.journal = j
+		a.broker = append(a.broker, BrokerJournal(j))
+	}
+	if config.History.Path != "" {
+		h, err := NewHistory(config.History)
+		if err != nil {
+			lg.Fatal(err)
+		}
+		a.history = h
+		a.server = append(a.server, ServerHistory(h))
 	}
 	a.GetGadgets(config.Gadgets)
 	a.gadgets = append(a.gadgets, gadgets...)
+	for _, cmd := range checkRules(a.gadgets) {
+		lg.Printf("warning: no gadget on this node handles '%s' (it has to be on another node)\n", cmd)
+	}
 	return a
 }
 
@@ -56,8 +101,8 @@ func (a *App) GetGadgets(configs []GadgetConfig) {
 		a.gadgets[i] = gadget
 	}
 	a.gadgets = append(a.gadgets, &MethodRunner{})
-	srv := NewServer(a.host, a.master, a.port, lg)
-	a.gadgets = append(a.gadgets, srv)
+	a.srv = NewServer(a.host, a.master, a.port, lg, a.server...)
+	a.gadgets = append(a.gadgets, a.srv)
 
 }
 
@@ -65,27 +110,77 @@ func (a *App) GetGadgets(configs []GadgetConfig) {
 //a chan in case the system is started as a goroutine,
 //but it can just be called directly.
 func (a *App) Start() {
-	x := make(chan Message)
-	a.GoStart(x)
+	a.Run(context.Background())
+}
+
+//Run starts the system and blocks until ctx is canceled or
+//Stop is called.  Every gadget is then sent the shutdown command
+//(which turns all the outputs off) and Run returns once they
+//have all stopped.
+func (a *App) Run(ctx context.Context) {
+	input := make(chan Message)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-a.stop:
+		}
+		input <- Message{
+			UUID: GetUUID(),
+			Type: COMMAND,
+			Body: "shutdown",
+		}
+	}()
+	a.GoStart(input)
+	close(a.done)
+}
+
+//Stop shuts down a system that was started with Run (or Start)
+//and waits for it to finish.
+func (a *App) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+	<-a.done
 }
 
 // GoStart enables a gadgets system to be started
 // by either a test suite that needs it to run
 // as a goroutine or a client app that starts
 // gogadget systems upon a command from a central
-// web app.
+// web app.  It returns after a shutdown command is
+// sent to input and all the gadgets have stopped.
 func (a *App) GoStart(input <-chan Message) {
 
+	var wg sync.WaitGroup
 	collect := make(chan Message)
 	channels := make(map[string]chan Message)
+	b := NewBroker(channels, input, collect, a.broker...)
+	if a.srv != nil {
+		a.srv.broker = b
+	}
 	for _, gadget := range a.gadgets {
 		out := make(chan Message)
 		channels[gadget.GetUID()] = out
-		go gadget.Start(out, collect)
+		if s, ok := gadget.(Subscriber); ok {
+			b.Subscribe(gadget.GetUID(), s.Subscriptions())
+		}
+		wg.Add(1)
+		go func(g Gadgeter) {
+			g.Start(out, collect)
+			wg.Done()
+		}(gadget)
 	}
 	lg.Println("started gagdgets")
-	b := NewBroker(channels, input, collect)
 	b.Start()
+	wg.Wait()
+	b.Stop()
+	if a.journal != nil {
+		a.journal.Close()
+	}
+	if a.history != nil {
+		a.history.Close()
+	}
+	lg.Println("stopped gadgets")
 }
 
 func GetConfig(config interface{}) *Config {
@@ -1,12 +1,15 @@
 package gogadgets_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/cswank/gogadgets"
@@ -15,12 +18,25 @@ import (
 )
 
 type fakeLogger struct {
-	f bool
+	f     bool
+	lock  sync.Mutex
+	lines []string
 }
 
-func (f *fakeLogger) Println(v ...interface{})          {}
-func (f *fakeLogger) Printf(s string, v ...interface{}) {}
-func (f *fakeLogger) Fatal(v ...interface{})            { f.f = true }
+func (f *fakeLogger) Println(v ...interface{}) {}
+func (f *fakeLogger) Fatal(v ...interface{})   { f.f = true }
+
+func (f *fakeLogger) Printf(s string, v ...interface{}) {
+	f.lock.Lock()
+	f.lines = append(f.lines, fmt.Sprintf(s, v...))
+	f.lock.Unlock()
+}
+
+func (f *fakeLogger) printed() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.lines...)
+}
 
 func init() {
 	rand.Seed(time.Now().Unix())
@@ -37,6 +53,35 @@ var _ = Describe("gogadgets", func() {
 	})
 
 	Describe("app", func() {
+		It("warns about a rule that sends a command no gadget handles", func() {
+			pump := &gogadgets.Gadget{
+				Location:    "tank",
+				Name:        "pump",
+				OnCommands:  []string{"turn on tank pump"},
+				OffCommands: []string{"turn off tank pump"},
+				Output:      &FakeOutput{},
+				UID:         "tank pump",
+			}
+			for cmd, ok := range map[string]bool{
+				"turn on tank pump":               true,
+				"turn off tank pump":              true,
+				"turn on tank pump for 5 minutes": true,
+				"turn on tank pmup":               false,
+				"turn on tank pump fro 5 minutes": false,
+			} {
+				rules, err := gogadgets.NewRules(gadgetConfig("rules", fmt.Sprintf(`{"rules": ["when tank volume < 5 liters then %s"]}`, cmd)))
+				Expect(err).To(BeNil())
+				lg = &fakeLogger{}
+				gogadgets.NewApp(&gogadgets.Config{Host: "localhost", Port: port, Logger: lg}, pump, rules)
+				Expect(lg.f).To(BeFalse())
+				if ok {
+					Expect(lg.printed()).To(BeEmpty(), cmd)
+				} else {
+					Expect(lg.printed()).To(ConsistOf(ContainSubstring(cmd)))
+				}
+			}
+		})
+
 		It("starts up a gogadgets app", func() {
 			fo := &FakeOutput{}
 			p := &gogadgets.Gadget{
@@ -85,6 +130,133 @@ var _ = Describe("gogadgets", func() {
 				return fo.on
 			}).Should(BeTrue())
 		})
+		It("waits for the answer to a method", func() {
+			a := gogadgets.NewApp(&gogadgets.Config{Host: "localhost", Port: port, Logger: lg})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go a.Run(ctx)
+			addr := fmt.Sprintf("http://localhost:%d/gadgets", port)
+
+			post := func(steps ...string) (int, gogadgets.Message) {
+				buf := &bytes.Buffer{}
+				json.NewEncoder(buf).Encode(&gogadgets.Message{
+					Type:   gogadgets.METHOD,
+					Method: gogadgets.Method{Steps: steps},
+				})
+				r, err := http.Post(addr+"?wait=1s", "application/json", buf)
+				if err != nil {
+					return 0, gogadgets.Message{}
+				}
+				defer r.Body.Close()
+				var reply gogadgets.Message
+				json.NewDecoder(r.Body).Decode(&reply)
+				return r.StatusCode, reply
+			}
+
+			Eventually(func() int {
+				status, _ := post("wait for user to start")
+				return status
+			}).Should(Equal(http.StatusOK))
+			status, reply := post("wait for the kettle to be hot")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(reply.Type).To(Equal(gogadgets.ERROR))
+		})
+
+		Context("shutting down", func() {
+			var (
+				fo     *FakeOutput
+				a      *gogadgets.App
+				addr   string
+				turnOn func()
+			)
+
+			BeforeEach(func() {
+				fo = &FakeOutput{}
+				p := &gogadgets.Gadget{
+					Location:    "tank",
+					Name:        "pump",
+					OnCommands:  []string{"turn on tank pump"},
+					OffCommands: []string{"turn off tank pump"},
+					Output:      fo,
+					UID:         "tank pump",
+				}
+				s := &gogadgets.Gadget{
+					Location: "tank",
+					Name:     "switch",
+					Input: &gogadgets.Switch{
+						GPIO:      &FakePoller{},
+						Value:     5.0,
+						TrueValue: 5.0,
+						Units:     "liters",
+					},
+					UID: "tank switch",
+				}
+				a = gogadgets.NewApp(&gogadgets.Config{
+					Host:   "localhost",
+					Port:   port,
+					Logger: lg,
+				}, p, s)
+				addr = fmt.Sprintf("http://localhost:%d/gadgets", port)
+
+				turnOn = func() {
+					Eventually(func() bool {
+						buf := &bytes.Buffer{}
+						json.NewEncoder(buf).Encode(&gogadgets.Message{
+							Type: gogadgets.COMMAND,
+							Body: "turn on tank pump",
+						})
+						r, err := http.Post(addr+"?wait=1s", "application/json", buf)
+						if err != nil {
+							return false
+						}
+						r.Body.Close()
+						return r.StatusCode == http.StatusOK
+					}).Should(BeTrue())
+					Expect(fo.on).To(BeTrue())
+				}
+			})
+
+			It("turns everything off when the context is canceled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan bool)
+				go func() {
+					a.Run(ctx)
+					close(done)
+				}()
+				turnOn()
+
+				cancel()
+				Eventually(done, 2*time.Second).Should(BeClosed())
+				Expect(fo.on).To(BeFalse())
+				_, err := http.Get(addr)
+				Expect(err).ToNot(BeNil())
+			})
+
+			It("stops", func() {
+				go a.Start()
+				turnOn()
+				a.Stop()
+				Expect(fo.on).To(BeFalse())
+				_, err := http.Get(addr)
+				Expect(err).ToNot(BeNil())
+			})
+
+			It("reports the broker stats", func() {
+				go a.Start()
+				defer a.Stop()
+				turnOn()
+				r, err := http.Get(addr + "/broker")
+				Expect(err).To(BeNil())
+				defer r.Body.Close()
+				Expect(r.StatusCode).To(Equal(http.StatusOK))
+				var st gogadgets.BrokerStats
+				Expect(json.NewDecoder(r.Body).Decode(&st)).To(BeNil())
+				Expect(st.Gadgets).To(HaveKey("tank pump"))
+				Expect(st.Gadgets["tank pump"].Delivered).To(BeNumerically(">", 0))
+				Expect(st.Gadgets["tank pump"].Dropped).To(Equal(0))
+			})
+		})
+
 		It("starts up swarm of gogadgets apps", func() {
 			fo1 := &FakeOutput{}
 			fo2 := &FakeOutput{}
@@ -170,6 +342,59 @@ var _ = Describe("gogadgets", func() {
 				return fo2.on
 			}).Should(BeTrue())
 		})
+		It("starts up a swarm that signs its messages", func() {
+			fo := &FakeOutput{}
+			light := &gogadgets.Gadget{
+				Location:    "kitchen",
+				Name:        "light",
+				OnCommands:  []string{"turn on kitchen light"},
+				OffCommands: []string{"turn off kitchen light"},
+				Output:      fo,
+				UID:         "kitchen light",
+			}
+			auth := gogadgets.AuthConfig{
+				Tokens: map[string]string{"t": gogadgets.ReadScope},
+				Secret: "shh",
+			}
+			a := gogadgets.NewApp(&gogadgets.Config{
+				Port:   port,
+				Auth:   auth,
+				Logger: lg,
+			})
+			a2 := gogadgets.NewApp(&gogadgets.Config{
+				Master: fmt.Sprintf("http://localhost:%d", port),
+				Host:   fmt.Sprintf("http://localhost:%d", port+1),
+				Port:   port + 1,
+				Auth:   auth,
+				Logger: lg,
+			}, light)
+
+			input := make(chan gogadgets.Message)
+			go a.GoStart(input)
+			go a2.Start()
+			defer a2.Stop()
+
+			Eventually(func() int {
+				r, err := http.Get(fmt.Sprintf("http://localhost:%d/clients?token=t", port))
+				if err != nil || r.StatusCode != http.StatusOK {
+					return 0
+				}
+				var c map[string]string
+				json.NewDecoder(r.Body).Decode(&c)
+				r.Body.Close()
+				return len(c)
+			}).Should(Equal(1))
+
+			input <- gogadgets.Message{
+				Sender: "the test",
+				Type:   gogadgets.COMMAND,
+				Body:   "turn on kitchen light",
+			}
+			Eventually(func() bool {
+				return fo.on
+			}).Should(BeTrue())
+			input <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+		})
 		It("loads a json config file", func() {
 			s := `{
     "gadgets": [
@@ -0,0 +1,193 @@
+package gogadgets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//ReadScope tokens can only look (GET).
+	ReadScope = "read"
+	//CommandScope tokens can also send commands.
+	CommandScope = "command"
+
+	signatureHeader = "X-Gadgets-Signature"
+	timestampHeader = "X-Gadgets-Timestamp"
+	nonceHeader     = "X-Gadgets-Nonce"
+	maxSignedBody   = 1 << 20
+)
+
+//maxSkew is how old (or new) a signed request can be.
+var maxSkew = 5 * time.Minute
+
+//AuthConfig protects the http api.  Tokens maps each api token to
+//its scope (read or command) and is sent as 'Authorization: Bearer
+//<token>' (or ?token=<token> for browsers that can't set headers
+//on a stream).  Secret is shared by a master and its clients and
+//is used to sign the messages they send each other.  If neither
+//is set the api is open to anyone, like it always was.
+type AuthConfig struct {
+	Tokens map[string]string `json:"tokens,omitempty"`
+	Secret string            `json:"secret,omitempty"`
+}
+
+func (a AuthConfig) enabled() bool {
+	return len(a.Tokens) > 0 || a.Secret != ""
+}
+
+func (a AuthConfig) check() error {
+	for _, scope := range a.Tokens {
+		if scope != ReadScope && scope != CommandScope {
+			return fmt.Errorf("invalid token scope: %s", scope)
+		}
+	}
+	return nil
+}
+
+//commandToken is a token that this node accepts commands with,
+//it is handed to the master so it can post to us.
+func (a AuthConfig) commandToken() string {
+	for token, scope := range a.Tokens {
+		if scope == CommandScope {
+			return "Bearer " + token
+		}
+	}
+	return "n/a"
+}
+
+//ServerAuth sets the tokens and secret of the api.
+func ServerAuth(cfg AuthConfig) func(*Server) {
+	return func(s *Server) {
+		s.auth = cfg
+	}
+}
+
+//Sign adds the headers that prove req (with body) was sent by a
+//gadgets node that knows secret.  The signature covers the method,
+//path, time, nonce and body of the request.  The nonce is only
+//accepted once so a captured request can't be sent again.
+func Sign(req *http.Request, body []byte, secret string) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := GetUUID()
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, signature(secret, req.Method, req.URL.Path, ts, nonce, body))
+}
+
+func signature(secret, method, path, ts, nonce string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", method, path, ts, nonce)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//authorize only lets requests with (at least) scope through to h.
+//It responds with 401 if there are no (or bad) credentials and 403
+//if they don't have the scope.
+func (s *Server) authorize(scope string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.auth.enabled() {
+			h(w, r)
+			return
+		}
+		granted := s.scope(r)
+		if granted == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gogadgets"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if scope == CommandScope && granted != CommandScope {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	})
+}
+
+//scope returns what the request is allowed to do, or "" if it
+//isn't allowed to do anything.  A request signed by another node
+//can do everything, one with a signature this node can't check
+//(it has no secret, or another one) still has its token.
+func (s *Server) scope(r *http.Request) string {
+	if r.Header.Get(signatureHeader) != "" && s.verify(r) {
+		return CommandScope
+	}
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	if token == "" {
+		return ""
+	}
+	return s.auth.Tokens[token]
+}
+
+//verify checks the signature of a request from another node and
+//that its nonce hasn't been used before.  The body is read to check
+//it, so it is replaced with a copy.
+func (s *Server) verify(r *http.Request) bool {
+	if s.auth.Secret == "" {
+		return false
+	}
+	ts := r.Header.Get(timestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > maxSkew || d < -maxSkew {
+		return false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody))
+	if err != nil {
+		return false
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	nonce := r.Header.Get(nonceHeader)
+	want := signature(s.auth.Secret, r.Method, r.URL.Path, ts, nonce, body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(signatureHeader))) {
+		return false
+	}
+	return nonce != "" && s.useNonce(nonce)
+}
+
+//useNonce is false if nonce has already been used.  Nonces are
+//kept until their requests are too old to be accepted anyway (see
+//cleanup).
+func (s *Server) useNonce(nonce string) bool {
+	s.noncesLock.Lock()
+	defer s.noncesLock.Unlock()
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	s.nonces[nonce] = time.Now()
+	return true
+}
+
+//forgetNonces forgets the nonces of requests that are too old to
+//be accepted.
+func (s *Server) forgetNonces(now time.Time) {
+	s.noncesLock.Lock()
+	for k, v := range s.nonces {
+		if now.Sub(v) > 2*maxSkew {
+			delete(s.nonces, k)
+		}
+	}
+	s.noncesLock.Unlock()
+}
+
+//sign signs a request to another node (if there is a secret).
+func (s *Server) sign(req *http.Request, body []byte) {
+	if s.auth.Secret != "" {
+		Sign(req, body, s.auth.Secret)
+	}
+}
@@ -0,0 +1,220 @@
+package gogadgets_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cswank/gogadgets"
+	"github.com/cswank/gogadgets/ws"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("auth", func() {
+	var (
+		port int
+		addr string
+		in   chan gogadgets.Message
+		out  chan gogadgets.Message
+		s    *gogadgets.Server
+	)
+
+	BeforeEach(func() {
+		port = 1024 + rand.Intn(65535-1024)
+		addr = fmt.Sprintf("http://localhost:%d/gadgets", port)
+		s = gogadgets.NewServer("", "", port, &fakeLogger{}, gogadgets.ServerAuth(gogadgets.AuthConfig{
+			Tokens: map[string]string{
+				"reader":    gogadgets.ReadScope,
+				"commander": gogadgets.CommandScope,
+			},
+			Secret: "shh",
+		}))
+		in = make(chan gogadgets.Message)
+		out = make(chan gogadgets.Message)
+		go s.Start(out, in)
+		Eventually(func() error {
+			r, err := http.Get(addr)
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		out <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+	})
+
+	do := func(method, token string, body []byte) int {
+		req, err := http.NewRequest(method, addr, bytes.NewReader(body))
+		Expect(err).To(BeNil())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		r.Body.Close()
+		return r.StatusCode
+	}
+
+	command := func() []byte {
+		d, err := json.Marshal(gogadgets.Message{
+			Type: gogadgets.COMMAND,
+			Body: "turn on lab led",
+		})
+		Expect(err).To(BeNil())
+		return d
+	}
+
+	It("turns away requests without a token", func() {
+		Expect(do("GET", "", nil)).To(Equal(http.StatusUnauthorized))
+		Expect(do("GET", "nope", nil)).To(Equal(http.StatusUnauthorized))
+		Expect(do("POST", "", command())).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("lets read only tokens look but not send commands", func() {
+		Expect(do("GET", "reader", nil)).To(Equal(http.StatusOK))
+		Expect(do("POST", "reader", command())).To(Equal(http.StatusForbidden))
+
+		r, err := http.Get(addr + "/values?token=reader")
+		Expect(err).To(BeNil())
+		r.Body.Close()
+		Expect(r.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("takes commands from command tokens", func() {
+		go func() {
+			defer GinkgoRecover()
+			Expect(do("POST", "commander", command())).To(Equal(http.StatusOK))
+		}()
+		m := <-in
+		Expect(m.Body).To(Equal("turn on lab led"))
+	})
+
+	It("takes commands that are signed by another node", func() {
+		body := command()
+		req, err := http.NewRequest("POST", addr, bytes.NewReader(body))
+		Expect(err).To(BeNil())
+		gogadgets.Sign(req, body, "shh")
+		go func() {
+			defer GinkgoRecover()
+			r, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			r.Body.Close()
+			Expect(r.StatusCode).To(Equal(http.StatusOK))
+		}()
+		m := <-in
+		Expect(m.Body).To(Equal("turn on lab led"))
+	})
+
+	It("lets a client with a token register with a master that has tokens but no secret", func() {
+		mport := 1024 + rand.Intn(65535-1024)
+		master := gogadgets.NewServer("", "", mport, &fakeLogger{}, gogadgets.ServerAuth(gogadgets.AuthConfig{
+			Tokens: map[string]string{"commander": gogadgets.CommandScope},
+		}))
+		mout := make(chan gogadgets.Message)
+		go master.Start(mout, make(chan gogadgets.Message))
+		defer stop(mout)
+		Eventually(func() error {
+			r, err := http.Get(fmt.Sprintf("http://localhost:%d/gadgets", mport))
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}).Should(BeNil())
+
+		cport := 1024 + rand.Intn(65535-1024)
+		host := fmt.Sprintf("http://localhost:%d", cport)
+		client := gogadgets.NewServer(host, fmt.Sprintf("http://localhost:%d", mport), cport, &fakeLogger{}, gogadgets.ServerAuth(gogadgets.AuthConfig{
+			Tokens: map[string]string{"commander": gogadgets.CommandScope},
+			Secret: "shh",
+		}))
+		cout := make(chan gogadgets.Message)
+		go client.Start(cout, make(chan gogadgets.Message))
+		defer stop(cout)
+
+		Eventually(func() map[string]string {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/clients", mport), nil)
+			Expect(err).To(BeNil())
+			req.Header.Set("Authorization", "Bearer commander")
+			r, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return nil
+			}
+			defer r.Body.Close()
+			var c map[string]string
+			json.NewDecoder(r.Body).Decode(&c)
+			return c
+		}, 2*time.Second).Should(HaveKeyWithValue(host+"/gadgets", "Bearer commander"))
+	})
+
+	It("turns away bad signatures", func() {
+		body := command()
+		for _, secret := range []string{"wrong", "shh"} {
+			req, err := http.NewRequest("POST", addr, bytes.NewReader(body))
+			Expect(err).To(BeNil())
+			gogadgets.Sign(req, body, secret)
+			if secret == "shh" {
+				//an old request that is sent again
+				ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+				req.Header.Set("X-Gadgets-Timestamp", ts)
+			}
+			r, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			r.Body.Close()
+			Expect(r.StatusCode).To(Equal(http.StatusUnauthorized))
+		}
+	})
+
+	It("turns away a signed request that is sent again", func() {
+		body := command()
+		req, err := http.NewRequest("POST", addr, bytes.NewReader(body))
+		Expect(err).To(BeNil())
+		gogadgets.Sign(req, body, "shh")
+		go func() {
+			defer GinkgoRecover()
+			r, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			r.Body.Close()
+			Expect(r.StatusCode).To(Equal(http.StatusOK))
+		}()
+		<-in
+
+		again, err := http.NewRequest("POST", addr, bytes.NewReader(body))
+		Expect(err).To(BeNil())
+		again.Header = req.Header
+		r, err := http.DefaultClient.Do(again)
+		Expect(err).To(BeNil())
+		r.Body.Close()
+		Expect(r.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		//or with another nonce
+		again, err = http.NewRequest("POST", addr, bytes.NewReader(body))
+		Expect(err).To(BeNil())
+		again.Header = req.Header.Clone()
+		again.Header.Set("X-Gadgets-Nonce", "another")
+		r, err = http.DefaultClient.Do(again)
+		Expect(err).To(BeNil())
+		r.Body.Close()
+		Expect(r.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("doesn't take commands from a read only websocket", func() {
+		c, err := ws.Dial(fmt.Sprintf("ws://localhost:%d/gadgets/ws?token=reader", port), nil)
+		Expect(err).To(BeNil())
+		defer c.Close()
+		Expect(c.WriteMessage([]byte("turn on lab led"))).To(BeNil())
+		d, err := c.ReadMessage()
+		Expect(err).To(BeNil())
+		var msg gogadgets.Message
+		Expect(json.Unmarshal(d, &msg)).To(BeNil())
+		Expect(msg.Type).To(Equal(gogadgets.ERROR))
+		Expect(strings.Contains(msg.Body, "can't send commands")).To(BeTrue())
+	})
+})
@@ -0,0 +1,133 @@
+package gogadgets
+
+import (
+	"math"
+	"time"
+)
+
+//relayTuner finds pid gains with the relay feedback test of
+//Åström and Hägglund.  The output is switched between its limits
+//whenever the sensor crosses the setpoint (plus or minus a little
+//hysteresis so noise doesn't chatter the relay).  That makes the
+//sensor oscillate, and the amplitude and period of the oscillation
+//give the ultimate gain (ku) and period (tu) of the system.  The
+//gains come from the Ziegler-Nichols rules for a pid.
+type relayTuner struct {
+	setpoint   float64
+	hysteresis float64
+	high       float64
+	low        float64
+	cycles     int
+	started    bool
+	relayOn    bool
+	peak       float64
+	maxes      []float64
+	mins       []float64
+	switches   []time.Time
+	done       bool
+	ku         float64
+	tu         float64
+	kp         float64
+	ki         float64
+	kd         float64
+}
+
+func newRelayTuner(setpoint, hysteresis, low, high float64, cycles int) *relayTuner {
+	if cycles < 2 {
+		cycles = 2
+	}
+	return &relayTuner{
+		setpoint:   setpoint,
+		hysteresis: hysteresis,
+		low:        low,
+		high:       high,
+		cycles:     cycles,
+	}
+}
+
+//update takes a sensor reading and returns what the output
+//should be.
+func (t *relayTuner) update(input float64, ts time.Time) float64 {
+	if t.done {
+		return t.low
+	}
+	if !t.started {
+		t.started = true
+		t.relayOn = input < t.setpoint
+		t.peak = input
+	}
+
+	//The lowest point of each cycle comes while the relay is on
+	//(the sensor is still falling) and the highest while it is
+	//off.  Nothing before the first switch counts since that is
+	//just the sensor getting to the setpoint.
+	if t.relayOn {
+		t.peak = math.Min(t.peak, input)
+		if input > t.setpoint+t.hysteresis {
+			t.relayOn = false
+			if len(t.switches) > 0 {
+				t.mins = append(t.mins, t.peak)
+			}
+			t.switches = append(t.switches, ts)
+			t.peak = input
+		}
+	} else {
+		t.peak = math.Max(t.peak, input)
+		if input < t.setpoint-t.hysteresis {
+			t.relayOn = true
+			if len(t.switches) > 0 {
+				t.maxes = append(t.maxes, t.peak)
+			}
+			t.peak = input
+		}
+	}
+
+	if len(t.switches) > t.cycles {
+		t.finish()
+		return t.low
+	}
+	if t.relayOn {
+		return t.high
+	}
+	return t.low
+}
+
+func (t *relayTuner) finish() {
+	//the first cycle is thrown away, it usually overshoots
+	a := (mean(t.maxes[1:]) - mean(t.mins[1:])) / 2.0
+	if a > t.hysteresis {
+		a = math.Sqrt(a*a - t.hysteresis*t.hysteresis)
+	}
+	d := math.Abs(t.high-t.low) / 2.0
+	t.ku = 4.0 * d / (math.Pi * a)
+	t.tu = t.switches[len(t.switches)-1].Sub(t.switches[1]).Seconds() / float64(len(t.switches)-2)
+
+	t.kp = 0.6 * t.ku
+	t.ki = 1.2 * t.ku / t.tu
+	t.kd = 0.075 * t.ku * t.tu
+	t.done = true
+}
+
+func (t *relayTuner) report() map[string]interface{} {
+	r := map[string]interface{}{
+		"setpoint": t.setpoint,
+		"done":     t.done,
+		"cycles":   len(t.mins),
+	}
+	if t.done {
+		r["ku"] = t.ku
+		r["tu"] = t.tu
+		r["kp"] = t.kp
+		r["ki"] = t.ki
+		r["kd"] = t.kd
+	}
+	return r
+}
+
+func mean(vals []float64) float64 {
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	return sum / float64(len(vals))
+}
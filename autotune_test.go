@@ -0,0 +1,138 @@
+package gogadgets_test
+
+import (
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("autotune", func() {
+	var (
+		pid   *gogadgets.PID
+		plant *gogadgets.SimThermalPlant
+		now   time.Time
+	)
+
+	//run steps the plant one second at a time and feeds the
+	//temperature to the pid.
+	run := func(d time.Duration) {
+		for i := 0; i < int(d.Seconds()); i++ {
+			now = now.Add(time.Second)
+			t := plant.Step(pid.Output(), time.Second)
+			pid.Update(&gogadgets.Message{
+				Sender:    "hlt temperature",
+				Timestamp: now,
+				Value:     gogadgets.Value{Value: t, Units: "C"},
+			})
+		}
+	}
+
+	autotune := func() map[string]interface{} {
+		r, _ := pid.Report()["autotune"].(map[string]interface{})
+		return r
+	}
+
+	BeforeEach(func() {
+		gogadgets.Sim.Reset()
+		now = time.Now()
+		plant = &gogadgets.SimThermalPlant{
+			Ambient:      20.0,
+			Gain:         100.0,
+			TimeConstant: 10 * time.Minute,
+			DeadTime:     30 * time.Second,
+			Temperature:  20.0,
+		}
+		p, err := gogadgets.NewPID(&gogadgets.Pin{
+			Platform: "sim",
+			Port:     "8",
+			Pin:      "13",
+			Args: map[string]interface{}{
+				"sensor": "hlt temperature",
+				"output": "pwm",
+				"kp":     1.0,
+			},
+		})
+		Expect(err).To(BeNil())
+		pid = p.(*gogadgets.PID)
+	})
+
+	It("finds the ultimate gain and period of the plant", func() {
+		Expect(pid.On(&gogadgets.Value{
+			Value: 65.0,
+			Units: "C",
+			Cmd:   "autotune hlt heater at 65 C",
+		})).To(BeNil())
+		run(5 * time.Minute)
+		Expect(autotune()["done"]).To(BeFalse())
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(100.0))
+
+		run(30 * time.Minute)
+		r := autotune()
+		Expect(r["done"]).To(BeTrue())
+		//a first order plus dead time plant with these numbers
+		//has an ultimate period of about 2 minutes and gain of
+		//about 31%/C (the relay test reads low when the lag is
+		//much longer than the dead time)
+		Expect(r["tu"]).To(BeNumerically("~", 120.0, 20.0))
+		Expect(r["ku"]).To(BeNumerically("~", 25.0, 6.0))
+		Expect(pid.Report()["kp"]).To(Equal(r["kp"]))
+		Expect(r["kp"]).To(BeNumerically("~", 0.6*r["ku"].(float64), 0.0001))
+
+		run(20 * time.Minute)
+		Expect(plant.Temperature).To(BeNumerically("~", 65.0, 0.5))
+	})
+
+	It("stops tuning when turned off", func() {
+		Expect(pid.On(&gogadgets.Value{
+			Value: 65.0,
+			Units: "C",
+			Cmd:   "autotune hlt heater at 65 C",
+		})).To(BeNil())
+		run(time.Minute)
+		Expect(pid.Off()).To(BeNil())
+		Expect(autotune()).To(BeNil())
+		Expect(pid.Report()["kp"]).To(Equal(1.0))
+	})
+
+	It("is started with a command and reports in the updates", func() {
+		g, err := gogadgets.NewGadget(&gogadgets.GadgetConfig{
+			Location: "hlt",
+			Name:     "heater",
+			Pin: gogadgets.Pin{
+				Type:     "pid",
+				Platform: "sim",
+				Port:     "8",
+				Pin:      "13",
+				Args: map[string]interface{}{
+					"sensor": "hlt temperature",
+					"output": "pwm",
+				},
+			},
+		})
+		Expect(err).To(BeNil())
+		input := make(chan gogadgets.Message)
+		output := make(chan gogadgets.Message)
+		go g.Start(input, output)
+		<-output
+		input <- gogadgets.Message{
+			Type: gogadgets.COMMAND,
+			Body: "autotune hlt heater at 150 F",
+		}
+		msg := <-output
+		Expect(msg.Value.Value).To(BeTrue())
+		Expect(msg.Value.Data["setpoint"]).To(Equal(150.0))
+		input <- gogadgets.Message{
+			Type:     gogadgets.UPDATE,
+			Sender:   "hlt temperature",
+			Location: "hlt",
+			Name:     "temperature",
+			Value:    gogadgets.Value{Value: 140.0, Units: "F"},
+		}
+		msg = <-output
+		r := msg.Value.Data["autotune"].(map[string]interface{})
+		Expect(r["done"]).To(BeFalse())
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(100.0))
+	})
+})
@@ -38,7 +38,8 @@ Configure a boiler like:
 With this config the boiler will react to temperatures from
 'the lab temperature' (which is the location + name of the thermometer)
 and turn on the gpio if the temperature is > 120.0, turn and turn it
-off when the temperature > 150.0.
+off when the temperature > 150.0.  Set "units" on the pin if high and
+low aren't in the same units as the thermometer.
 
 If you set args.type = "cooler" then it will start cooling when the
 temperature gets above 150, and stop cooling when the temperature gets
@@ -47,6 +48,7 @@ below 120.
 type Boiler struct {
 	highTarget float64
 	lowTarget  float64
+	units      string
 	timeout    time.Duration
 	status     bool
 	gpio       OutputDevice
@@ -81,6 +83,7 @@ func NewBoiler(pin *Pin) (OutputDevice, error) {
 		highTarget: h,
 		lowTarget:  l,
 		cmp:        c,
+		units:      pin.Units,
 		sensor:     pin.Args["sensor"].(string),
 	}
 	return b, err
@@ -107,7 +110,7 @@ func (b *Boiler) Update(msg *Message) bool {
 	// 	return
 	// }
 	var ch bool
-	temperature, ok := msg.Value.Value.(float64)
+	temperature, ok := msg.Value.In(b.units)
 	if b.status && ok {
 		ch = true
 		if b.cmp(temperature, b.highTarget) {
@@ -1,35 +1,207 @@
 package gogadgets
 
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMailboxSize   = 100
+	defaultMailboxPolicy = Coalesce
+)
+
 //All the gadgets of the system push their messages here.
+//Every gadget has its own bounded queue (a mailbox) and its own
+//goroutine that delivers from it, so a gadget that is slow to
+//read its messages only holds up itself.
 type Broker struct {
-	queue    *Queue
-	channels map[string]chan Message
-	collect  <-chan Message
-	input    <-chan Message
+	queue     *Queue
+	channels  map[string]chan Message
+	subs      map[string]*Subscriptions
+	mailboxes map[string]*mailbox
+	lock      sync.Mutex
+	size      int
+	sizes     map[string]int
+	policy    string
+	policies  map[string]string
+	collect   <-chan Message
+	input     <-chan Message
+	journal   *Journal
+	done      chan bool
+}
+
+//BrokerStats are the counters of a running broker.  Queue is the
+//number of messages that have been collected from the gadgets
+//but not sent on yet.
+type BrokerStats struct {
+	Queue   int                     `json:"queue"`
+	Gadgets map[string]MailboxStats `json:"gadgets"`
+}
+
+//MailboxStats are the counters of the messages for one gadget.
+//Latency is how long (in seconds) messages wait before the gadget
+//reads them.
+type MailboxStats struct {
+	Depth      int     `json:"depth"`
+	Delivered  int     `json:"delivered"`
+	Dropped    int     `json:"dropped"`
+	Coalesced  int     `json:"coalesced"`
+	Latency    float64 `json:"latency"`
+	MaxLatency float64 `json:"max_latency"`
+}
+
+type mailbox struct {
+	queue   *Queue
+	out     chan<- Message
+	lock    sync.Mutex
+	sent    int
+	latency time.Duration
+	maxWait time.Duration
 }
 
-func NewBroker(channels map[string]chan Message, input <-chan Message, collect <-chan Message) *Broker {
-	return &Broker{
-		input:    input,
-		queue:    NewQueue(),
-		channels: channels,
-		collect:  collect,
+func NewBroker(channels map[string]chan Message, input <-chan Message, collect <-chan Message, options ...func(*Broker)) *Broker {
+	b := &Broker{
+		input:     input,
+		queue:     NewQueue(),
+		channels:  channels,
+		subs:      map[string]*Subscriptions{},
+		mailboxes: map[string]*mailbox{},
+		size:      defaultMailboxSize,
+		sizes:     map[string]int{},
+		policy:    defaultMailboxPolicy,
+		policies:  map[string]string{},
+		collect:   collect,
+		done:      make(chan bool),
 	}
+	for _, opt := range options {
+		opt(b)
+	}
+	return b
+}
+
+//BrokerSize sets how many messages can be waiting for each
+//gadget.  With a uid it only sets the size for that gadget.
+func BrokerSize(size int, uid ...string) func(*Broker) {
+	return func(b *Broker) {
+		if size <= 0 {
+			return
+		}
+		if len(uid) == 0 {
+			b.size = size
+		}
+		for _, u := range uid {
+			b.sizes[u] = size
+		}
+	}
+}
+
+//BrokerPolicy sets what happens to the messages for a gadget
+//when its mailbox is full (DropOldest, DropNewest or Coalesce).
+//With a uid it only sets the policy for that gadget.
+func BrokerPolicy(policy string, uid ...string) func(*Broker) {
+	return func(b *Broker) {
+		if policy == "" {
+			return
+		}
+		if len(uid) == 0 {
+			b.policy = policy
+		}
+		for _, u := range uid {
+			b.policies[u] = policy
+		}
+	}
+}
+
+//Subscribe makes the broker only send the gadget with the uid
+//the messages that match subs (plus the ones that are targeted
+//at it and the shutdown command).  It has to be called before
+//Start.
+func (b *Broker) Subscribe(uid string, subs *Subscriptions) {
+	b.subs[uid] = subs
+}
+
+func (b *Broker) wants(uid string, msg *Message) bool {
+	subs, ok := b.subs[uid]
+	return !ok || msg.isShutdown() || subs.Match(msg)
 }
 
 func (b *Broker) Start() {
+	b.lock.Lock()
+	for uid, ch := range b.channels {
+		policy, ok := b.policies[uid]
+		if !ok {
+			policy = b.policy
+		}
+		size, ok := b.sizes[uid]
+		if !ok {
+			size = b.size
+		}
+		m := &mailbox{
+			queue: NewBoundedQueue(size, policy),
+			out:   ch,
+		}
+		b.mailboxes[uid] = m
+		go b.deliver(m)
+	}
+	b.lock.Unlock()
+
 	in := make(chan Message)
 	go b.collectMessages(b.collect)
 	go b.dispenseMessages(in)
 	stop := false
 	for !stop {
+		var msg Message
 		select {
-		case msg := <-in:
-			b.sendMessage(msg)
-		case msg := <-b.input:
+		case msg = <-in:
+		case msg = <-b.input:
 			stop = msg.Type == "command" && msg.Body == "shutdown"
-			b.sendMessage(msg)
 		}
+		b.write(msg)
+		b.sendMessage(msg)
+	}
+}
+
+//Stop stops collecting and dispensing messages.  Start returns
+//when the shutdown command comes in, but the gadgets keep sending
+//messages while they turn themselves off, so Stop should only be
+//called once they are all done.
+func (b *Broker) Stop() {
+	close(b.done)
+	queues := []*Queue{b.queue}
+	b.lock.Lock()
+	for _, m := range b.mailboxes {
+		queues = append(queues, m.queue)
+	}
+	b.lock.Unlock()
+	for _, q := range queues {
+		q.Lock()
+		q.Broadcast()
+		q.Unlock()
+	}
+}
+
+//Stats returns the counters of the broker.  It is safe to call
+//while the broker is running.
+func (b *Broker) Stats() BrokerStats {
+	s := BrokerStats{
+		Queue:   b.queue.Len(),
+		Gadgets: map[string]MailboxStats{},
+	}
+	b.lock.Lock()
+	for uid, m := range b.mailboxes {
+		s.Gadgets[uid] = m.stats()
+	}
+	b.lock.Unlock()
+	return s
+}
+
+func (b *Broker) stopped() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -37,8 +209,12 @@ func (b *Broker) Start() {
 //system and pushes it in the queue.
 func (b *Broker) collectMessages(in <-chan Message) {
 	for {
-		msg := <-in
-		b.queue.Push(&msg)
+		select {
+		case msg := <-in:
+			b.queue.Push(&msg)
+		case <-b.done:
+			return
+		}
 	}
 }
 
@@ -46,28 +222,108 @@ func (b *Broker) collectMessages(in <-chan Message) {
 //then sent back to the rest of the system.
 func (b *Broker) dispenseMessages(out chan<- Message) {
 	for {
-		b.queue.Lock()
-		if b.queue.Len() == 0 {
-			b.queue.Wait()
+		msg, _, ok := b.next(b.queue)
+		if !ok {
+			return
+		}
+		select {
+		case out <- *msg:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+//deliver sends the messages in a mailbox to its gadget.
+func (b *Broker) deliver(m *mailbox) {
+	for {
+		msg, pushed, ok := b.next(m.queue)
+		if !ok {
+			return
+		}
+		select {
+		case m.out <- *msg:
+			m.delivered(pushed)
+		case <-b.done:
+			return
 		}
-		msg := b.queue.Get()
-		out <- *msg
-		b.queue.Unlock()
+	}
+}
+
+//next waits for a message in q, ok is false once the broker
+//has been stopped.
+func (b *Broker) next(q *Queue) (*Message, time.Time, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for q.Len() == 0 && !b.stopped() {
+		q.Wait()
+	}
+	if b.stopped() {
+		return nil, time.Time{}, false
+	}
+	msg, pushed := q.getWithTime()
+	return msg, pushed, true
+}
+
+//write adds msg to the journal (if there is one).
+func (b *Broker) write(msg Message) {
+	if b.journal == nil {
+		return
+	}
+	if err := b.journal.Write(msg); err != nil {
+		log.Println("couldn't write to the journal:", err)
 	}
 }
 
 func (b *Broker) sendMessage(msg Message) {
 
 	if msg.Target == "" {
-		for uid, channel := range b.channels {
-			if uid != msg.Sender {
-				channel <- msg
+		for uid, m := range b.mailboxes {
+			if uid != msg.Sender && b.wants(uid, &msg) {
+				m.push(msg)
 			}
 		}
 	} else {
-		channel, ok := b.channels[msg.Target]
+		m, ok := b.mailboxes[msg.Target]
 		if ok {
-			channel <- msg
+			m.push(msg)
+		} else {
+			//the target isn't one of our gadgets (a command from
+			//the http api for example) so let everyone see it.
+			msg.Target = ""
+			b.sendMessage(msg)
 		}
 	}
 }
+
+func (m *mailbox) push(msg Message) {
+	m.queue.Push(&msg)
+}
+
+func (m *mailbox) delivered(pushed time.Time) {
+	d := time.Since(pushed)
+	m.lock.Lock()
+	m.sent++
+	m.latency += d
+	if d > m.maxWait {
+		m.maxWait = d
+	}
+	m.lock.Unlock()
+}
+
+func (m *mailbox) stats() MailboxStats {
+	dropped, coalesced := m.queue.Counts()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := MailboxStats{
+		Depth:      m.queue.Len(),
+		Delivered:  m.sent,
+		Dropped:    dropped,
+		Coalesced:  coalesced,
+		MaxLatency: m.maxWait.Seconds(),
+	}
+	if m.sent > 0 {
+		s.Latency = m.latency.Seconds() / float64(m.sent)
+	}
+	return s
+}
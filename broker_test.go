@@ -58,12 +58,11 @@ var _ = Describe("broker", func() {
 			gadgets = append(gadgets, g)
 		}
 		b = gogadgets.NewBroker(clients, in, out)
-		go b.Start()
-
 	})
 
 	Context("brokering", func() {
 		It("brokers", func() {
+			go b.Start()
 			for i := 0; i < 10; i++ {
 				out <- gogadgets.Message{
 					Sender: "the test",
@@ -75,5 +74,210 @@ var _ = Describe("broker", func() {
 				return gadgets[0].len() == 10 && gadgets[1].len() == 10
 			}).Should(BeTrue())
 		})
+
+		It("only sends subscribers what they subscribed to", func() {
+			b.Subscribe("y", gogadgets.NewSubscriptions(
+				gogadgets.Subscription{Type: gogadgets.UPDATE, Location: "hlt"},
+			))
+			go b.Start()
+			for i, l := range []string{"hlt", "mash tun", "hlt"} {
+				out <- gogadgets.Message{
+					Sender:   fmt.Sprintf("sensor %d", i),
+					Type:     gogadgets.UPDATE,
+					Location: l,
+				}
+			}
+			out <- gogadgets.Message{
+				Sender: "the test",
+				Target: "y",
+				Type:   gogadgets.COMMAND,
+				Body:   "turn on hlt valve",
+			}
+			out <- gogadgets.Message{
+				Sender: "the test",
+				Type:   gogadgets.COMMAND,
+				Body:   "shutdown",
+			}
+			Eventually(func() int {
+				return gadgets[0].len()
+			}).Should(Equal(4))
+			Eventually(func() int {
+				return gadgets[1].len()
+			}).Should(Equal(4))
+			Consistently(func() int {
+				return gadgets[1].len()
+			}, "50ms").Should(Equal(4))
+		})
+
+		It("keeps going when a gadget is stuck", func() {
+			clients["stuck"] = make(chan gogadgets.Message)
+			b = gogadgets.NewBroker(clients, in, out,
+				gogadgets.BrokerSize(10, "stuck"),
+				gogadgets.BrokerPolicy(gogadgets.DropOldest, "stuck"),
+			)
+			go b.Start()
+			send := func(i int) {
+				out <- gogadgets.Message{
+					Sender: fmt.Sprintf("sensor %d", i),
+					Type:   gogadgets.UPDATE,
+					Body:   fmt.Sprintf("%d", i),
+				}
+			}
+
+			//the first message gets stuck on its way to the gadget
+			send(0)
+			Eventually(func() bool {
+				return gadgets[0].len() == 1 && b.Stats().Gadgets["stuck"].Depth == 0
+			}).Should(BeTrue())
+
+			//and the next 14 overflow its mailbox
+			for i := 1; i < 15; i++ {
+				send(i)
+			}
+			Eventually(func() bool {
+				return gadgets[0].len() == 15 && gadgets[1].len() == 15
+			}).Should(BeTrue())
+
+			Eventually(func() gogadgets.MailboxStats {
+				return b.Stats().Gadgets["stuck"]
+			}).Should(Equal(gogadgets.MailboxStats{Depth: 10, Dropped: 4}))
+			st := b.Stats().Gadgets["x"]
+			Expect(st.Delivered).To(Equal(15))
+			Expect(st.MaxLatency).To(BeNumerically(">=", st.Latency))
+
+			msg := <-clients["stuck"]
+			Expect(msg.Body).To(Equal("0"))
+			msg = <-clients["stuck"]
+			Expect(msg.Body).To(Equal("5"))
+		})
+
+		It("gives a slow gadget every update in order while its mailbox has room", func() {
+			clients["slow"] = make(chan gogadgets.Message)
+			b = gogadgets.NewBroker(clients, in, out)
+			go b.Start()
+			for _, v := range []bool{true, false, true, false} {
+				out <- gogadgets.Message{
+					Sender: "lab heater",
+					Type:   gogadgets.UPDATE,
+					Value:  gogadgets.Value{Value: v},
+				}
+			}
+			var values []interface{}
+			for i := 0; i < 4; i++ {
+				msg := <-clients["slow"]
+				values = append(values, msg.Value.Value)
+			}
+			Expect(values).To(Equal([]interface{}{true, false, true, false}))
+			Expect(b.Stats().Gadgets["slow"].Coalesced).To(Equal(0))
+		})
+
+		It("still delivers commands to a gadget with a full mailbox", func() {
+			clients["stuck"] = make(chan gogadgets.Message)
+			b = gogadgets.NewBroker(clients, in, out, gogadgets.BrokerSize(2, "stuck"))
+			go b.Start()
+			for i := 0; i < 5; i++ {
+				out <- gogadgets.Message{
+					Sender: fmt.Sprintf("sensor %d", i),
+					Type:   gogadgets.UPDATE,
+				}
+			}
+			//the gadget may already have been handed the first
+			//update, the mailbox is full once updates are dropped
+			Eventually(func() int {
+				return b.Stats().Gadgets["stuck"].Dropped
+			}).ShouldNot(BeZero())
+			out <- gogadgets.Message{
+				Sender: "the test",
+				Type:   gogadgets.COMMAND,
+				Body:   "turn off heater",
+			}
+
+			var bodies []string
+			for len(bodies) == 0 || bodies[len(bodies)-1] == "" {
+				msg := <-clients["stuck"]
+				bodies = append(bodies, msg.Body)
+			}
+			Expect(len(bodies)).To(BeNumerically("<=", 4))
+			Expect(bodies[len(bodies)-1]).To(Equal("turn off heater"))
+		})
+	})
+
+	Context("bounded queues", func() {
+		msg := func(sender, body string) *gogadgets.Message {
+			return &gogadgets.Message{
+				Type:   gogadgets.UPDATE,
+				Sender: sender,
+				Body:   body,
+			}
+		}
+
+		bodies := func(q *gogadgets.Queue) []string {
+			var b []string
+			for q.Len() > 0 {
+				b = append(b, q.Get().Body)
+			}
+			return b
+		}
+
+		It("drops the oldest", func() {
+			q := gogadgets.NewBoundedQueue(2, gogadgets.DropOldest)
+			for _, m := range []string{"a", "b", "c"} {
+				q.Push(msg("x", m))
+			}
+			Expect(bodies(q)).To(Equal([]string{"b", "c"}))
+			dropped, _ := q.Counts()
+			Expect(dropped).To(Equal(1))
+		})
+
+		It("drops the newest", func() {
+			q := gogadgets.NewBoundedQueue(2, gogadgets.DropNewest)
+			for _, m := range []string{"a", "b", "c"} {
+				q.Push(msg("x", m))
+			}
+			Expect(bodies(q)).To(Equal([]string{"a", "b"}))
+		})
+
+		It("coalesces updates from the same sender when it is full", func() {
+			q := gogadgets.NewBoundedQueue(2, gogadgets.Coalesce)
+			q.Push(msg("x", "a"))
+			q.Push(msg("y", "b"))
+			q.Push(msg("x", "c"))
+			Expect(bodies(q)).To(Equal([]string{"b", "c"}))
+			_, coalesced := q.Counts()
+			Expect(coalesced).To(Equal(1))
+		})
+
+		It("keeps every update while there is room", func() {
+			q := gogadgets.NewBoundedQueue(10, gogadgets.Coalesce)
+			q.Push(msg("x", "on"))
+			q.Push(&gogadgets.Message{Type: gogadgets.COMMAND, Body: "turn off heater"})
+			q.Push(msg("x", "off"))
+			Expect(bodies(q)).To(Equal([]string{"on", "turn off heater", "off"}))
+			dropped, coalesced := q.Counts()
+			Expect(dropped + coalesced).To(Equal(0))
+		})
+
+		It("never drops commands and replies", func() {
+			for _, policy := range []string{gogadgets.DropOldest, gogadgets.DropNewest, gogadgets.Coalesce} {
+				q := gogadgets.NewBoundedQueue(2, policy)
+				q.Push(&gogadgets.Message{Type: gogadgets.COMMAND, Body: "turn on heater"})
+				q.Push(msg("x", "a"))
+				q.Push(&gogadgets.Message{Type: gogadgets.COMMAND, Body: "turn off heater"})
+				q.Push(&gogadgets.Message{Type: gogadgets.DONE, Body: "done"})
+				q.Push(msg("y", "b"))
+				expected := []string{"turn on heater", "turn off heater", "done", "b"}
+				if policy == gogadgets.DropNewest {
+					expected = []string{"turn on heater", "a", "turn off heater", "done"}
+				}
+				Expect(bodies(q)).To(Equal(expected), policy)
+			}
+		})
+
+		It("never drops the shutdown command", func() {
+			q := gogadgets.NewBoundedQueue(1, gogadgets.DropNewest)
+			q.Push(msg("x", "a"))
+			q.Push(&gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"})
+			Expect(bodies(q)).To(Equal([]string{"a", "shutdown"}))
+		})
 	})
 })
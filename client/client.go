@@ -0,0 +1,407 @@
+/*
+Package client talks to the http api of a gogadgets system.
+
+	c := client.New("http://brewery:6111", client.Token("93ab..."))
+	reply, err := c.SendCommand(ctx, "heat hlt to 150 F", 5*time.Second)
+
+Every method takes a context.  Requests that only read are retried
+(see Retries) when the system can't be reached or answers with a
+5xx, commands are only retried if they never got to the system.
+*/
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cswank/gogadgets"
+)
+
+var (
+	//ErrTimeout is returned when a command that is waited for
+	//isn't answered in time.
+	ErrTimeout = errors.New("timed out waiting for the answer to the command")
+)
+
+//Error is returned when the api answers with an unexpected
+//status.
+type Error struct {
+	StatusCode int
+	Msg        string
+}
+
+func (e *Error) Error() string {
+	if e.Msg == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Msg)
+}
+
+//CommandError is returned when a gadget answers a command with
+//an error.
+type CommandError struct {
+	Reply gogadgets.Message
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reply.Sender, e.Reply.Body)
+}
+
+//Client is a client of one gogadgets system.
+type Client struct {
+	addr    string
+	token   string
+	secret  string
+	sender  string
+	retries int
+	backoff time.Duration
+	http    *http.Client
+}
+
+//New returns a client of the system at addr (for example
+//http://localhost:6111).
+func New(addr string, options ...func(*Client)) *Client {
+	c := &Client{
+		addr:    strings.TrimRight(addr, "/"),
+		sender:  "client",
+		retries: 3,
+		backoff: 100 * time.Millisecond,
+		http:    http.DefaultClient,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+//Token sets the api token that is sent with every request.
+func Token(token string) func(*Client) {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+//Secret signs every request with the secret that the nodes of
+//a system share (see gogadgets.AuthConfig).
+func Secret(secret string) func(*Client) {
+	return func(c *Client) {
+		c.secret = secret
+	}
+}
+
+//Sender sets the sender of the commands (client by default).
+func Sender(sender string) func(*Client) {
+	return func(c *Client) {
+		c.sender = sender
+	}
+}
+
+//Retries sets how many times a request is retried and how long
+//to wait before the first retry (the wait doubles each time).
+func Retries(n int, backoff time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+//HTTPClient sets the http client to use.
+func HTTPClient(h *http.Client) func(*Client) {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+//Insecure trusts any certificate (for systems with self signed
+//ones).
+func Insecure() func(*Client) {
+	return HTTPClient(&http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	})
+}
+
+//Status returns the latest update of every gadget, by uid.
+func (c *Client) Status(ctx context.Context) (map[string]gogadgets.Message, error) {
+	var s map[string]gogadgets.Message
+	return s, c.get(ctx, "/gadgets", &s)
+}
+
+//Values returns the latest value of every gadget, by location
+//and name.
+func (c *Client) Values(ctx context.Context) (map[string]map[string]gogadgets.Value, error) {
+	var v map[string]map[string]gogadgets.Value
+	return v, c.get(ctx, "/gadgets/values", &v)
+}
+
+//DeviceValue returns the latest value of one device.
+func (c *Client) DeviceValue(ctx context.Context, location, device string) (gogadgets.Value, error) {
+	var v gogadgets.Value
+	return v, c.get(ctx, devicePath(location, device, "status"), &v)
+}
+
+//History returns the history of a device from (inclusive) to
+//(exclusive), aggregated by step when it isn't 0.
+func (c *Client) History(ctx context.Context, location, device string, from, to time.Time, step time.Duration) ([]gogadgets.Point, error) {
+	q := url.Values{}
+	q.Set("from", from.Format(time.RFC3339))
+	q.Set("to", to.Format(time.RFC3339))
+	if step > 0 {
+		q.Set("step", step.String())
+	}
+	var p []gogadgets.Point
+	return p, c.get(ctx, devicePath(location, device, "history")+"?"+q.Encode(), &p)
+}
+
+//Locations returns every location and its devices.
+func (c *Client) Locations(ctx context.Context) (map[string]map[string]gogadgets.DeviceInfo, error) {
+	var l map[string]map[string]gogadgets.DeviceInfo
+	return l, c.get(ctx, "/gadgets/locations", &l)
+}
+
+//SendCommand sends an RCL command.  With a wait it returns the
+//done message that answers the command (a *CommandError if a
+//gadget answers with an error, ErrTimeout if nothing answers),
+//otherwise it returns the command that was sent.
+func (c *Client) SendCommand(ctx context.Context, cmd string, wait time.Duration) (gogadgets.Message, error) {
+	return c.Send(ctx, gogadgets.Message{
+		Type: gogadgets.COMMAND,
+		Body: cmd,
+	}, wait)
+}
+
+//Send sends any message, see SendCommand.
+func (c *Client) Send(ctx context.Context, msg gogadgets.Message, wait time.Duration) (gogadgets.Message, error) {
+	if msg.UUID == "" {
+		msg.UUID = gogadgets.GetUUID()
+	}
+	if msg.Sender == "" {
+		msg.Sender = c.sender
+	}
+	return c.command(ctx, "/gadgets", msg, wait)
+}
+
+//TurnOn turns on a device, args can be nil.
+func (c *Client) TurnOn(ctx context.Context, location, device string, args *gogadgets.DeviceCommand, wait time.Duration) (gogadgets.Message, error) {
+	return c.command(ctx, devicePath(location, device, "on"), args, wait)
+}
+
+//TurnOff turns off a device.
+func (c *Client) TurnOff(ctx context.Context, location, device string, wait time.Duration) (gogadgets.Message, error) {
+	return c.command(ctx, devicePath(location, device, "off"), nil, wait)
+}
+
+//RunMethod starts a method (a list of RCL commands that are run
+//one after the other).  It returns the message that started it.
+func (c *Client) RunMethod(ctx context.Context, steps []string) (gogadgets.Message, error) {
+	return c.Send(ctx, gogadgets.Message{
+		Type:   gogadgets.METHOD,
+		Method: gogadgets.Method{Steps: steps},
+	}, 0)
+}
+
+//Clients returns the clients of a master and their tokens.
+func (c *Client) Clients(ctx context.Context) (map[string]string, error) {
+	var cl map[string]string
+	return cl, c.get(ctx, "/clients", &cl)
+}
+
+//RegisterClient registers the gadgets system at address with a
+//master, which then sends it every message with the token.
+func (c *Client) RegisterClient(ctx context.Context, address, token string) error {
+	body, err := json.Marshal(map[string]string{"address": address, "token": token})
+	if err != nil {
+		return err
+	}
+	r, err := c.do(ctx, "POST", "/clients", body, false)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	return nil
+}
+
+//Filter narrows down a stream (see gogadgets.Subscription).  The
+//empty filter gets updates, method updates and errors.
+type Filter struct {
+	Locations []string
+	Names     []string
+	Types     []string
+}
+
+func (f Filter) query() string {
+	q := url.Values{}
+	q["location"] = f.Locations
+	q["name"] = f.Names
+	q["type"] = f.Types
+	return q.Encode()
+}
+
+//Stream returns the messages that match f as they happen.  The
+//latest update of every gadget comes first.  The chan is closed
+//when ctx is done or the connection is lost.
+func (c *Client) Stream(ctx context.Context, f Filter) (<-chan gogadgets.Message, error) {
+	p := "/gadgets/stream"
+	if q := f.query(); q != "" {
+		p += "?" + q
+	}
+	r, err := c.do(ctx, "GET", p, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan gogadgets.Message)
+	go func() {
+		defer close(ch)
+		defer r.Body.Close()
+		s := bufio.NewScanner(r.Body)
+		s.Buffer(make([]byte, 64*1024), 1<<20)
+		for s.Scan() {
+			line := s.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var msg gogadgets.Message
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err != nil {
+				continue
+			}
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func devicePath(location, device, action string) string {
+	return fmt.Sprintf("/gadgets/locations/%s/devices/%s/%s", url.PathEscape(location), url.PathEscape(device), action)
+}
+
+func (c *Client) get(ctx context.Context, p string, v interface{}) error {
+	r, err := c.do(ctx, "GET", p, nil, true)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+//command posts body to p and reads the answer.
+func (c *Client) command(ctx context.Context, p string, body interface{}, wait time.Duration) (gogadgets.Message, error) {
+	var msg gogadgets.Message
+	var d []byte
+	if body != nil {
+		var err error
+		if d, err = json.Marshal(body); err != nil {
+			return msg, err
+		}
+	}
+	if wait > 0 {
+		p = fmt.Sprintf("%s?wait=%s", p, wait)
+	}
+	r, err := c.do(ctx, "POST", p, d, false)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusGatewayTimeout {
+		return msg, ErrTimeout
+	}
+	if err != nil && r == nil {
+		return msg, err
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		return msg, err
+	}
+	if r.StatusCode == http.StatusUnprocessableEntity {
+		return msg, &CommandError{Reply: msg}
+	}
+	return msg, nil
+}
+
+//do sends a request.  Read only requests are retried if the
+//system can't be reached or has a problem, others only if they
+//couldn't be sent at all.  Any status other than 200 is an
+//*Error, except a 422 which is the answer to a failed command
+//(the response is returned with it).
+func (c *Client) do(ctx context.Context, method, p string, body []byte, idempotent bool) (*http.Response, error) {
+	var err error
+	wait := c.backoff
+	for i := 0; ; i++ {
+		var r *http.Response
+		r, err = c.try(ctx, method, p, body)
+		if err == nil {
+			switch {
+			case r.StatusCode == http.StatusOK:
+				return r, nil
+			case r.StatusCode == http.StatusUnprocessableEntity:
+				return r, nil
+			}
+			err = responseError(r)
+			if !idempotent || r.StatusCode < 500 {
+				return nil, err
+			}
+		} else if !idempotent && !isDialError(err) {
+			return nil, err
+		}
+		if i >= c.retries {
+			return nil, err
+		}
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) try(ctx context.Context, method, p string, body []byte) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.addr+p, rd)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.secret != "" {
+		gogadgets.Sign(req, body, c.secret)
+	}
+	return c.http.Do(req)
+}
+
+func responseError(r *http.Response) error {
+	defer r.Body.Close()
+	e := &Error{StatusCode: r.StatusCode}
+	d, _ := ioutil.ReadAll(io.LimitReader(r.Body, 4096))
+	var m map[string]string
+	if json.Unmarshal(d, &m) == nil {
+		e.Msg = m["error"]
+	}
+	return e
+}
+
+//isDialError is true if the request never got to the server.
+func isDialError(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
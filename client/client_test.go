@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cswank/gogadgets"
+)
+
+//system runs a gogadgets api.  Commands that are sent to it
+//come out of cmds and messages put into out go to the api.
+type system struct {
+	c    *Client
+	out  chan gogadgets.Message
+	cmds chan gogadgets.Message
+}
+
+func newSystem(t *testing.T, options ...func(*gogadgets.Server)) *system {
+	port := 1024 + rand.Intn(65535-1024)
+	s := gogadgets.NewServer("", "", port, log.New(ioutil.Discard, "", 0), options...)
+	sys := &system{
+		c:    New(fmt.Sprintf("http://localhost:%d", port), Token("abc")),
+		out:  make(chan gogadgets.Message),
+		cmds: make(chan gogadgets.Message, 10),
+	}
+	go s.Start(sys.out, sys.cmds)
+	sys.out <- gogadgets.Message{
+		Type:     gogadgets.UPDATE,
+		Sender:   "lab led",
+		Location: "lab",
+		Name:     "led",
+		Value:    gogadgets.Value{Value: false},
+		Info:     gogadgets.Info{Direction: "output", On: []string{"turn on lab led"}, Off: []string{"turn off lab led"}},
+	}
+	for i := 0; ; i++ {
+		r, err := http.Get(fmt.Sprintf("http://localhost:%d/gadgets/openapi.json", port))
+		if err == nil {
+			r.Body.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return sys
+}
+
+func (s *system) stop() {
+	s.out <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+}
+
+func TestValues(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+	ctx := context.Background()
+
+	v, err := s.c.Values(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["lab"]["led"].Value != false {
+		t.Errorf("lab led is %v", v["lab"]["led"])
+	}
+
+	st, err := s.c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st["lab led"].Info.Direction != "output" {
+		t.Errorf("lab led is %v", st["lab led"])
+	}
+
+	l, err := s.c.Locations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l["lab"]["led"].On) != 1 {
+		t.Errorf("lab led is %v", l["lab"]["led"])
+	}
+
+	_, err = s.c.DeviceValue(ctx, "lab", "fan")
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404, got %v", err)
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+	ctx := context.Background()
+
+	msg, err := s.c.SendCommand(ctx, "turn on lab led", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := <-s.cmds
+	if cmd.UUID != msg.UUID || cmd.Body != "turn on lab led" || cmd.Sender != "client" {
+		t.Errorf("sent %v, got %v", msg, cmd)
+	}
+
+	go func() {
+		cmd := <-s.cmds
+		s.out <- gogadgets.Message{Type: gogadgets.DONE, Sender: "lab led", ReplyTo: cmd.UUID, Body: "done"}
+		cmd = <-s.cmds
+		s.out <- gogadgets.Message{Type: gogadgets.ERROR, Sender: "lab led", ReplyTo: cmd.UUID, Body: "it's broken"}
+		<-s.cmds
+	}()
+
+	reply, err := s.c.TurnOff(ctx, "lab", "led", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != gogadgets.DONE || reply.Body != "done" {
+		t.Errorf("got %v", reply)
+	}
+
+	_, err = s.c.SendCommand(ctx, "turn on lab led", time.Second)
+	if e, ok := err.(*CommandError); !ok || e.Reply.Body != "it's broken" {
+		t.Errorf("expected a command error, got %v", err)
+	}
+
+	_, err = s.c.SendCommand(ctx, "turn on lab led", 50*time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestRunMethod(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+
+	steps := []string{"turn on lab led", "wait for 1 second", "turn off lab led"}
+	if _, err := s.c.RunMethod(context.Background(), steps); err != nil {
+		t.Fatal(err)
+	}
+	cmd := <-s.cmds
+	if cmd.Type != gogadgets.METHOD || len(cmd.Method.Steps) != 3 {
+		t.Errorf("got %v", cmd)
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := s.c.Stream(ctx, Filter{Locations: []string{"lab"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-ch
+	if msg.Sender != "lab led" {
+		t.Errorf("got %v", msg)
+	}
+	s.out <- gogadgets.Message{Type: gogadgets.UPDATE, Sender: "lab led", Location: "lab", Name: "led", Value: gogadgets.Value{Value: true}}
+	msg = <-ch
+	if msg.Value.Value != true {
+		t.Errorf("got %v", msg)
+	}
+}
+
+func TestToken(t *testing.T) {
+	s := newSystem(t, gogadgets.ServerAuth(gogadgets.AuthConfig{Tokens: map[string]string{"abc": gogadgets.ReadScope}}))
+	defer s.stop()
+	ctx := context.Background()
+
+	if _, err := s.c.Values(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.c.SendCommand(ctx, "turn on lab led", 0)
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a 403, got %v", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var gets, posts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			posts++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gets++
+		if gets < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"lab": {"led": {"value": true}}}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, Retries(3, time.Millisecond))
+	v, err := c.Values(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gets != 3 || v["lab"]["led"].Value != true {
+		t.Errorf("%d gets, got %v", gets, v)
+	}
+
+	//a command that got to the system isn't sent again
+	if _, err := c.SendCommand(context.Background(), "turn on lab led", 0); err == nil {
+		t.Error("expected an error")
+	}
+	if posts != 1 {
+		t.Errorf("posted %d times", posts)
+	}
+}
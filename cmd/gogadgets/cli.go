@@ -1,15 +1,18 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/cswank/gogadgets"
+	"github.com/cswank/gogadgets/client"
 	"github.com/cswank/gogadgets/utils"
 )
 
@@ -19,18 +22,23 @@ const (
 )
 
 var (
-	host    = kingpin.Flag("host", "Name of Host").Short('h').Default("localhost").String()
-	config  = kingpin.Flag("config", "Path to a Gadgets config file").Short('c').Default("/etc/gogadgets/config.json").String()
-	cmd     = kingpin.Flag("cmd", "a Robot Command Language string").String()
-	status  = kingpin.Flag("status", "get the status of a gadgets system").Short('s').Bool()
-	verbose = kingpin.Flag("verbose", "get the verbose status of a gadgets system").Short('v').Bool()
-	addr    string
+	host     = kingpin.Flag("host", "Name of Host").Short('h').Default("localhost").String()
+	config   = kingpin.Flag("config", "Path to a Gadgets config file").Short('c').Default("/etc/gogadgets/config.json").String()
+	cmd      = kingpin.Flag("cmd", "a Robot Command Language string").String()
+	wait     = kingpin.Flag("wait", "how long to wait for --cmd to be done (0 doesn't wait)").Default("0s").Duration()
+	status   = kingpin.Flag("status", "get the status of a gadgets system").Short('s').Bool()
+	verbose  = kingpin.Flag("verbose", "get the verbose status of a gadgets system").Short('v').Bool()
+	platform = kingpin.Flag("platform", "override the platform of every pin (sim runs without hardware)").String()
+	replay   = kingpin.Flag("replay", "Path to a journal to replay into the gadgets").String()
+	speed    = kingpin.Flag("speed", "how many times faster than it was recorded to --replay a journal (0 is as fast as possible)").Default("1").Float64()
+	token    = kingpin.Flag("token", "api token of the gadgets system").Short('t').Envar("GOGADGETS_TOKEN").String()
+	useTLS   = kingpin.Flag("tls", "talk to the gadgets system over https").Bool()
+	insecure = kingpin.Flag("insecure", "trust any certificate (for self signed ones)").Bool()
 )
 
 func main() {
 	kingpin.Version(gogadgets.Version)
 	kingpin.Parse()
-	addr = fmt.Sprintf("http://%s:%d/gadgets", *host, 6111)
 	if len(*cmd) > 0 {
 		sendCommand()
 	} else if *status {
@@ -47,11 +55,49 @@ func runGadgets() {
 	if cfg == "" {
 		listen()
 	} else {
-		a := gogadgets.NewApp(cfg)
-		a.Start()
+		c := gogadgets.GetConfig(cfg)
+		if *platform != "" {
+			c.Platform = *platform
+		}
+		a := gogadgets.NewApp(c)
+		if *replay != "" {
+			replayJournal(a)
+		}
+		a.Run(interrupted())
 	}
 }
 
+//replayJournal feeds the --replay journal into a.  The gadgets
+//keep running after the journal is done so they can be looked at.
+func replayJournal(a *gogadgets.App) {
+	r, err := gogadgets.OpenJournal(*replay)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rp := a.Replay(r, *speed)
+	go func() {
+		if err := rp.Err(); err != nil {
+			log.Println("replay:", err)
+		} else {
+			log.Println("replay is done")
+		}
+		r.Close()
+	}()
+}
+
+//interrupted returns a context that is canceled on SIGTERM or
+//ctrl-c so the gadgets get turned off before we exit.
+func interrupted() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+	return ctx
+}
+
 func getConfig() string {
 	cfg := *config
 	if cfg != "" {
@@ -64,52 +110,52 @@ func getConfig() string {
 }
 
 func getStatus() {
-	r, err := http.Get(fmt.Sprintf("%s/values", addr))
+	v, err := newClient().Values(context.Background())
 	if err != nil {
 		log.Fatal("err", err)
 	}
-
-	var v map[string]map[string]gogadgets.Value
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&v); err != nil {
-		log.Fatal("err", err)
-	}
-
 	d, _ := json.MarshalIndent(v, "", "  ")
 	fmt.Println(string(d))
 }
 
 func getVerbose() {
-	r, err := http.Get(addr)
+	s, err := newClient().Status(context.Background())
 	if err != nil {
 		log.Fatal("err", err)
 	}
-
-	var s map[string]gogadgets.Message
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&s); err != nil {
-		log.Fatal("err", err)
-	}
-
 	d, _ := json.MarshalIndent(s, "", "  ")
 	fmt.Println(string(d))
 }
 
 func sendCommand() {
-	msg := gogadgets.Message{
-		UUID:   gogadgets.GetUUID(),
-		Type:   gogadgets.COMMAND,
-		Sender: "client",
-		Body:   *cmd,
+	reply, err := newClient().SendCommand(context.Background(), *cmd, *wait)
+	if err == client.ErrTimeout {
+		fmt.Println(err)
+		return
+	}
+	if e, ok := err.(*client.CommandError); ok {
+		fmt.Println(e)
+		return
 	}
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.Encode(&msg)
-	r, err := http.Post(addr, "application/json", &buf)
 	if err != nil {
 		log.Fatal("err", err)
 	}
-	fmt.Println(r.Status)
+	if *wait > 0 {
+		fmt.Printf("%s: %s\n", reply.Sender, reply.Body)
+	}
+}
+
+//newClient returns a client of the gadgets system at --host.
+func newClient() *client.Client {
+	scheme := "http"
+	if *useTLS {
+		scheme = "https"
+	}
+	opts := []func(*client.Client){client.Token(*token)}
+	if *insecure {
+		opts = append(opts, client.Insecure())
+	}
+	return client.New(fmt.Sprintf("%s://%s:%d", scheme, *host, 6111), opts...)
 }
 
 //Waits for a zmq message that contains a gogadgets
@@ -4,6 +4,7 @@ import "time"
 
 type Cooler struct {
 	target     float64
+	units      string
 	status     bool
 	gpio       OutputDevice
 	lastChange *time.Time
@@ -41,7 +42,7 @@ func (c *Cooler) Update(msg *Message) bool {
 	}
 
 	var changed bool
-	temperature, ok := msg.Value.Value.(float64)
+	temperature, ok := msg.Value.In(c.units)
 	if ok && c.status {
 		changed = true
 		if temperature <= c.target {
@@ -60,6 +61,7 @@ func (c *Cooler) On(val *Value) error {
 		target, ok := val.Value.(float64)
 		if ok {
 			c.target = target
+			c.units = val.Units
 		}
 	}
 	c.status = true
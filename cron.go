@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/cswank/gogadgets/rcl"
 )
 
 var (
@@ -36,12 +38,7 @@ func NewCron(config *GadgetConfig, options ...func(*Cron) error) (*Cron, error)
 
 	var err error
 	if c.jobs == nil {
-		v := config.Args["jobs"].([]interface{})
-		jobs := make([]string, len(v))
-		for i, r := range v {
-			jobs[i] = r.(string)
-		}
-		c.jobs, err = c.parseJobs(jobs)
+		c.jobs, err = c.parseJobs(getJobs(config.Args["jobs"]))
 		if err != nil {
 			return c, err
 		}
@@ -50,6 +47,29 @@ func NewCron(config *GadgetConfig, options ...func(*Cron) error) (*Cron, error)
 	return c, nil
 }
 
+//Jobs can be configured as a list of rows or as a single
+//crontab style string with one job per line.
+func getJobs(j interface{}) []string {
+	jobs := []string{}
+	switch v := j.(type) {
+	case []interface{}:
+		for _, r := range v {
+			if s, ok := r.(string); ok {
+				jobs = append(jobs, s)
+			}
+		}
+	case []string:
+		jobs = v
+	case string:
+		for _, r := range strings.Split(v, "\n") {
+			if strings.TrimSpace(r) != "" {
+				jobs = append(jobs, r)
+			}
+		}
+	}
+	return jobs
+}
+
 func CronAfter(a Afterer) func(*Cron) error {
 	return func(c *Cron) error {
 		c.after = a
@@ -85,6 +105,12 @@ func (c *Cron) GetUID() string {
 	return "cron"
 }
 
+//Subscriptions are just commands, cron only listens for
+//shutdown.
+func (c *Cron) Subscriptions() *Subscriptions {
+	return NewSubscriptions(Subscription{Type: COMMAND})
+}
+
 func (c *Cron) GetDirection() string {
 	return "na"
 }
@@ -99,7 +125,10 @@ func (c *Cron) Start(in <-chan Message, out chan<- Message) {
 			if t.Second() == 0 {
 				c.checkJobs(t)
 			}
-		case <-in:
+		case msg := <-in:
+			if msg.isShutdown() {
+				return
+			}
 		}
 	}
 }
@@ -140,6 +169,9 @@ func (c *Cron) parseJob(row string, m map[string][]string) error {
 
 	keys := c.getKeys(parts[0:5])
 	cmd := strings.Join(parts[5:], " ")
+	if _, err := rcl.Parse(cmd); err != nil {
+		return fmt.Errorf("could not parse job: %s: %s", row, err)
+	}
 	for _, key := range keys {
 		a, ok := c.jobs[key]
 		if !ok {
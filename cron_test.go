@@ -93,6 +93,12 @@ var _ = Describe("Cron", func() {
 			cronErr = errors.New("could not parse job: 25 * b * * start")
 			start()
 		})
+
+		It("returns an error when the command isn't valid", func() {
+			jobs = []string{"25 13 * * * turn on"}
+			cronErr = errors.New("could not parse job: 25 13 * * * turn on: expected something to turn on at position 7 of 'turn on'")
+			start()
+		})
 	})
 
 	Describe("when all's good", func() {
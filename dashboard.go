@@ -0,0 +1,285 @@
+package gogadgets
+
+import "net/http"
+
+//dashboard serves a web page that shows every device as it
+//changes, turns them on and off, runs methods and sends RCL
+//commands.  The page itself doesn't need a token, the api calls
+//it makes send the one given as ?token= (it is remembered by the
+//browser).
+func (s *Server) dashboard(w http.ResponseWriter, r *http.Request) {
+	serveAsset(w, "text/html; charset=utf-8", dashboardHTML)
+}
+
+func (s *Server) dashboardJS(w http.ResponseWriter, r *http.Request) {
+	serveAsset(w, "application/javascript", dashboardScript)
+}
+
+func (s *Server) dashboardCSS(w http.ResponseWriter, r *http.Request) {
+	serveAsset(w, "text/css", dashboardStyle)
+}
+
+func serveAsset(w http.ResponseWriter, contentType, asset string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(asset))
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>gogadgets</title>
+<link rel="stylesheet" href="/dashboard/style.css">
+</head>
+<body>
+<header>
+  <h1>gogadgets</h1>
+  <span id="status">connecting</span>
+</header>
+<main>
+  <section id="locations"></section>
+  <aside>
+    <div class="panel">
+      <h2>Method</h2>
+      <ol id="steps"></ol>
+      <div id="countdown"></div>
+      <textarea id="method" rows="6" placeholder="one RCL command per line"></textarea>
+      <button id="run">run</button>
+      <button id="clear">clear method</button>
+    </div>
+    <div class="panel">
+      <h2>Console</h2>
+      <form id="console">
+        <input id="cmd" autocomplete="off" placeholder="turn on lab led for 5 minutes">
+      </form>
+      <ul id="log"></ul>
+    </div>
+  </aside>
+</main>
+<script src="/dashboard/app.js"></script>
+</body>
+</html>
+`
+
+const dashboardScript = `(function() {
+  "use strict";
+
+  var params = new URLSearchParams(location.search);
+  if (params.get("token")) {
+    localStorage.setItem("gogadgets-token", params.get("token"));
+  }
+  var token = localStorage.getItem("gogadgets-token") || "";
+  var devices = {};
+
+  function $(id) { return document.getElementById(id); }
+
+  function el(tag, cls, text) {
+    var e = document.createElement(tag);
+    if (cls) { e.className = cls; }
+    if (text !== undefined) { e.textContent = text; }
+    return e;
+  }
+
+  function api(method, path, body) {
+    var headers = {"Content-Type": "application/json"};
+    if (token) { headers["Authorization"] = "Bearer " + token; }
+    return fetch(path, {method: method, headers: headers, body: body && JSON.stringify(body)});
+  }
+
+  function log(text, cls) {
+    var li = el("li", cls, new Date().toLocaleTimeString() + " " + text);
+    $("log").insertBefore(li, $("log").firstChild);
+    while ($("log").children.length > 50) {
+      $("log").removeChild($("log").lastChild);
+    }
+  }
+
+  function send(body) {
+    return api("POST", "/gadgets?wait=5s", {type: "command", sender: "client", body: body}).then(function(r) {
+      if (r.status === 504) {
+        log(body + ": sent");
+        return;
+      }
+      if (r.status !== 200 && r.status !== 422) {
+        log(body + ": " + r.status + " " + r.statusText, "error");
+        return;
+      }
+      return r.json().then(function(reply) {
+        log(reply.sender + ": " + reply.body, reply.type === "error" ? "error" : "");
+      });
+    });
+  }
+
+  function format(v) {
+    if (v === undefined || v.value === undefined || v.value === null) { return "-"; }
+    if (typeof v.value === "boolean") { return v.value ? "on" : "off"; }
+    if (typeof v.value === "number") {
+      return (Math.round(v.value * 100) / 100) + (v.units ? " " + v.units : "");
+    }
+    return JSON.stringify(v.value);
+  }
+
+  function locationSection(name) {
+    var id = "location-" + name;
+    var sec = document.getElementById(id);
+    if (sec) { return sec.querySelector("tbody"); }
+    sec = el("div", "panel location");
+    sec.id = id;
+    sec.appendChild(el("h2", "", name));
+    var table = el("table");
+    table.appendChild(el("tbody"));
+    sec.appendChild(table);
+    $("locations").appendChild(sec);
+    return table.querySelector("tbody");
+  }
+
+  function device(location, name, info) {
+    var key = location + " " + name;
+    var d = devices[key];
+    if (d) { return d; }
+    var tr = el("tr");
+    tr.appendChild(el("td", "name", name));
+    var value = el("td", "value", "-");
+    tr.appendChild(value);
+    var buttons = el("td", "buttons");
+    (info.on || []).forEach(function(cmd) {
+      var b = el("button", "on", cmd.indexOf(key) > 0 ? "on" : cmd);
+      b.title = cmd;
+      b.onclick = function() { send(cmd); };
+      buttons.appendChild(b);
+    });
+    (info.off || []).forEach(function(cmd) {
+      var b = el("button", "off", cmd.indexOf(key) > 0 ? "off" : cmd);
+      b.title = cmd;
+      b.onclick = function() { send(cmd); };
+      buttons.appendChild(b);
+    });
+    tr.appendChild(buttons);
+    locationSection(location).appendChild(tr);
+    d = devices[key] = {row: tr, value: value};
+    return d;
+  }
+
+  function update(msg) {
+    if (!msg.location || !msg.name) { return; }
+    var d = device(msg.location, msg.name, msg.info || {});
+    d.value.textContent = format(msg.value);
+    d.row.className = msg.value && msg.value.value === true ? "active" : "";
+  }
+
+  function method(msg) {
+    var m = msg.method || {};
+    var steps = m.steps || [];
+    $("steps").innerHTML = "";
+    steps.forEach(function(step, i) {
+      $("steps").appendChild(el("li", i === (m.step || 0) ? "current" : (i < (m.step || 0) ? "done" : ""), step));
+    });
+    $("countdown").textContent = m.time ? m.time + " seconds left" : "";
+  }
+
+  function connect() {
+    var u = "/gadgets/stream" + (token ? "?token=" + encodeURIComponent(token) : "");
+    var es = new EventSource(u);
+    es.onopen = function() { $("status").textContent = "live"; $("status").className = "live"; };
+    es.onerror = function() { $("status").textContent = "disconnected"; $("status").className = "error"; };
+    es.addEventListener("update", function(e) { update(JSON.parse(e.data)); });
+    es.addEventListener("method update", function(e) { method(JSON.parse(e.data)); });
+    es.addEventListener("error", function(e) {
+      if (!e.data) { return; }
+      var msg = JSON.parse(e.data);
+      log(msg.sender + ": " + msg.body, "error");
+    });
+  }
+
+  $("run").onclick = function() {
+    var steps = $("method").value.split("\n").map(function(s) { return s.trim(); }).filter(Boolean);
+    if (steps.length === 0) { return; }
+    api("POST", "/gadgets", {type: "method", sender: "client", method: {steps: steps}}).then(function(r) {
+      if (r.status !== 200) { log("method: " + r.status + " " + r.statusText, "error"); }
+    });
+  };
+
+  $("clear").onclick = function() { send("clear method"); };
+
+  $("console").onsubmit = function(e) {
+    e.preventDefault();
+    var cmd = $("cmd").value.trim();
+    if (cmd) { send(cmd); }
+    $("cmd").value = "";
+  };
+
+  api("GET", "/gadgets/locations").then(function(r) {
+    if (r.status !== 200) {
+      log("locations: " + r.status + " " + r.statusText, "error");
+      return {};
+    }
+    return r.json();
+  }).then(function(locations) {
+    Object.keys(locations).sort().forEach(function(loc) {
+      Object.keys(locations[loc]).sort().forEach(function(name) {
+        var d = locations[loc][name];
+        update({location: loc, name: name, info: d, value: d.value});
+      });
+    });
+    connect();
+  });
+})();
+`
+
+const dashboardStyle = `body {
+  margin: 0;
+  font-family: sans-serif;
+  background: #f4f4f4;
+  color: #222;
+}
+header {
+  display: flex;
+  align-items: center;
+  justify-content: space-between;
+  padding: 0 1em;
+  background: #2d3e50;
+  color: #fff;
+}
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin: 0 0 .5em 0; }
+main {
+  display: flex;
+  flex-wrap: wrap;
+  gap: 1em;
+  padding: 1em;
+}
+#locations {
+  flex: 2 1 30em;
+  display: flex;
+  flex-wrap: wrap;
+  gap: 1em;
+  align-content: flex-start;
+}
+aside {
+  flex: 1 1 20em;
+  display: flex;
+  flex-direction: column;
+  gap: 1em;
+}
+.panel {
+  background: #fff;
+  border-radius: 4px;
+  padding: 1em;
+  box-shadow: 0 1px 3px rgba(0, 0, 0, .2);
+}
+.location { flex: 1 1 18em; }
+table { width: 100%; border-collapse: collapse; }
+td { padding: .3em; border-top: 1px solid #eee; }
+td.value { text-align: right; font-family: monospace; }
+td.buttons { text-align: right; white-space: nowrap; }
+tr.active td.name { font-weight: bold; color: #1d7a36; }
+button { margin-left: .3em; cursor: pointer; }
+textarea, input { width: 100%; box-sizing: border-box; font-family: monospace; }
+#steps li.current { font-weight: bold; }
+#steps li.done { color: #999; }
+#log { list-style: none; padding: 0; font-family: monospace; font-size: .9em; max-height: 20em; overflow: auto; }
+.error { color: #b00020; }
+#status.live { color: #8fe39f; }
+`
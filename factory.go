@@ -21,6 +21,7 @@ var (
 		"cooler":     NewCooler,
 		"thermostat": NewThermostat,
 		"boiler":     NewBoiler,
+		"pid":        NewPID,
 		"gpio":       GPIOFactory,
 		"recorder":   NewRecorder,
 		"pwm":        NewPWM,
@@ -42,7 +43,7 @@ func NewAppFactory() *AppFactory {
 //NewGadget reads a GadgetConfig and creates the correct
 //type of Gadget.
 func NewGadget(config *GadgetConfig) (Gadgeter, error) {
-	if config.Type == "cron" {
+	if config.Type == "cron" || config.Type == "alerts" || config.Type == "rules" {
 		return newSystemGadget(config)
 	}
 	switch deviceType(config.Pin.Type) {
@@ -59,8 +60,13 @@ func NewGadget(config *GadgetConfig) (Gadgeter, error) {
 }
 
 func newSystemGadget(config *GadgetConfig) (Gadgeter, error) {
-	if config.Type == "cron" {
+	switch config.Type {
+	case "cron":
 		return NewCron(config)
+	case "alerts":
+		return NewAlerts(config)
+	case "rules":
+		return NewRules(config)
 	}
 	return nil, fmt.Errorf("don't know how to build %s", config.Name)
 }
@@ -125,6 +131,7 @@ func GetTypes() map[string]ConfigHelper {
 	f := FlowMeter{}
 	th := Thermostat{}
 	r := Recorder{}
+	p := PID{}
 	return map[string]ConfigHelper{
 		"thermometer": t.Config(),
 		"switch":      s.Config(),
@@ -132,6 +139,7 @@ func GetTypes() map[string]ConfigHelper {
 		"heater":      h.Config(),
 		"cooler":      c.Config(),
 		"thermostat":  th.Config(),
+		"pid":         p.Config(),
 		"recorder":    r.Config(),
 		"flow_meter":  f.Config(),
 	}
@@ -201,6 +209,13 @@ type OutputDevice interface {
 	Commands(string, string) *Commands
 }
 
+//Reporter is implemented by output devices that have more to
+//say than on or off (a pid's gains for example).  Whatever
+//Report returns is sent as the Data of the gadget's updates.
+type Reporter interface {
+	Report() map[string]interface{}
+}
+
 func NewOutputDevice(pin *Pin) (dev OutputDevice, err error) {
 	f, ok := outputFactories[pin.Type]
 	if !ok {
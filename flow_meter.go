@@ -46,14 +46,24 @@ func (f *FlowMeter) Config() ConfigHelper {
 	return ConfigHelper{}
 }
 
-func (f *FlowMeter) wait(err chan<- error) {
+//wait returns after the next pulse once stop is closed.
+func (f *FlowMeter) wait(err chan<- error, stop <-chan bool) {
 	for {
 		v, e := f.GPIO.Wait()
+		select {
+		case <-stop:
+			return
+		default:
+		}
 		if !v {
 			continue
 		}
 		if e != nil {
-			err <- e
+			select {
+			case err <- e:
+			case <-stop:
+				return
+			}
 			continue
 		}
 		t1 := f.ts
@@ -66,9 +76,10 @@ func (f *FlowMeter) wait(err chan<- error) {
 			continue
 		}
 		f.value = f.Value / float64(span)
-		f.out <- Value{
-			Value: f.value,
-			Units: f.Units,
+		select {
+		case f.out <- Value{Value: f.value, Units: f.Units}:
+		case <-stop:
+			return
 		}
 	}
 }
@@ -87,13 +98,16 @@ func (f *FlowMeter) GetValue() *Value {
 func (f *FlowMeter) Start(in <-chan Message, out chan<- Value) {
 	f.out = out
 	err := make(chan error)
+	stop := make(chan bool)
+	defer close(stop)
 	f.SendValue()
-	keepGoing := true
-	go f.wait(err)
-	for keepGoing {
+	go f.wait(err, stop)
+	for {
 		select {
-		case <-in:
-			//do nothing
+		case msg := <-in:
+			if msg.isShutdown() {
+				return
+			}
 		case e := <-err:
 			log.Println(e)
 		}
@@ -5,31 +5,10 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"strconv"
 	"strings"
 	"time"
-)
 
-var (
-	units = map[string]string{
-		"liters":     "volume",
-		"gallons":    "volume",
-		"liter":      "volume",
-		"gallon":     "volume",
-		"c":          "temperature",
-		"f":          "temperature",
-		"C":          "temperature",
-		"F":          "temperature",
-		"celcius":    "temperature",
-		"fahrenheit": "temperature",
-		"seconds":    "time",
-		"minutes":    "time",
-		"hours":      "time",
-		"second":     "time",
-		"minute":     "time",
-		"hour":       "time",
-		"%":          "power",
-	}
+	"github.com/cswank/gogadgets/rcl"
 )
 
 type Comparitor func(msg *Message) bool
@@ -58,14 +37,14 @@ type Gadget struct {
 	lastCmd        string
 	status         bool
 	compare        Comparitor
+	subs           *Subscriptions
 	shutdown       bool
 	filterMessages bool
 	units          string
 	Operator       string
 	out            chan<- Message
 	devIn          chan Message
-	timerIn        chan bool
-	timerOut       chan bool
+	timer          *time.Timer
 }
 
 //All gadgets respond to Robot Command Language (RCL) messages.  isMyCommand
@@ -81,19 +60,54 @@ func (g *Gadget) isMyCommand(msg *Message) (bool, string, string) {
 	}
 
 	for _, cmd := range g.OnCommands {
-		if strings.Index(msg.Body, cmd) == 0 {
+		if hasCommand(msg.Body, cmd) {
 			return true, "on", cmd
 		}
 	}
 
 	for _, cmd := range g.OffCommands {
-		if strings.Index(msg.Body, cmd) == 0 {
+		if hasCommand(msg.Body, cmd) {
 			return true, "off", cmd
 		}
 	}
 	return false, "", ""
 }
 
+//hasCommand is true if body starts with cmd followed by the end of
+//body or a space (turn on lab led isn't the start of turn on lab
+//led2).
+func hasCommand(body, cmd string) bool {
+	return strings.HasPrefix(body, cmd) && (len(body) == len(cmd) || body[len(cmd)] == ' ')
+}
+
+//Subscriptions are the messages the broker needs to send this
+//gadget: commands, and for an output the updates from its
+//location (a recorder gets them all).
+func (g *Gadget) Subscriptions() *Subscriptions {
+	if g.subs == nil {
+		g.subs = NewSubscriptions(g.baseSubscriptions()...)
+	}
+	return g.subs
+}
+
+func (g *Gadget) baseSubscriptions() []Subscription {
+	subs := []Subscription{{Type: COMMAND}}
+	if g.Output == nil {
+		return subs
+	}
+	if g.filterMessages {
+		return append(subs, Subscription{Type: UPDATE, Location: g.Location})
+	}
+	return append(subs, Subscription{Type: UPDATE})
+}
+
+//clearCompare drops the comparitor of the last command and the
+//subscription that went with it.
+func (g *Gadget) clearCompare() {
+	g.compare = nil
+	g.Subscriptions().Set(g.baseSubscriptions()...)
+}
+
 func (g *Gadget) GetDirection() string {
 	if g.Output != nil {
 		return "output"
@@ -105,8 +119,6 @@ func (g *Gadget) GetDirection() string {
 //in in and out chan and is meant to be called as a goroutine.
 func (g *Gadget) Start(in <-chan Message, out chan<- Message) {
 	g.out = out
-	g.timerIn = make(chan bool)
-	g.timerOut = make(chan bool)
 	if g.Output != nil {
 		if len(g.InitialValue) > 0 {
 			g.readInitialValue()
@@ -125,9 +137,14 @@ func (g *Gadget) Start(in <-chan Message, out chan<- Message) {
 //this Gadget is an input Gadget
 func (g *Gadget) doInputLoop(in <-chan Message) {
 	devOut := make(chan Value, 10)
+	devDone := make(chan bool)
 	g.devIn = make(chan Message, 10)
-	go g.Input.Start(g.devIn, devOut)
+	go func() {
+		g.Input.Start(g.devIn, devOut)
+		close(devDone)
+	}()
 	g.sendUpdate()
+	defer g.waitForInput(devOut, devDone)
 	for !g.shutdown {
 		select {
 		case msg := <-in:
@@ -149,42 +166,74 @@ func (g *Gadget) doInputLoop(in <-chan Message) {
 	}
 }
 
+//waitForInput waits for the input device to stop after it has
+//been sent the shutdown command.
+func (g *Gadget) waitForInput(devOut <-chan Value, devDone <-chan bool) {
+	for {
+		select {
+		case <-devOut:
+		case <-devDone:
+			return
+		}
+	}
+}
+
 func (g *Gadget) readInitialValue() {
 	msg := &Message{
 		UUID: GetUUID(),
 		Body: g.InitialValue,
 	}
-	g.readCommand(msg, "on", g.InitialValue)
+	if err := g.readOnCommand(msg, g.InitialValue); err != nil {
+		log.Println(err)
+	}
 }
 
 func (g *Gadget) doOutputLoop(in <-chan Message) {
 	for !g.shutdown {
+		var timeout <-chan time.Time
+		if g.timer != nil {
+			timeout = g.timer.C
+		}
 		select {
 		case msg := <-in:
 			g.readMessage(&msg)
-		case <-g.timerOut:
+		case <-timeout:
+			g.timer = nil
 			g.off()
 		}
 	}
 }
 
-func (g *Gadget) on(val *Value) {
-	err := g.Output.On(val)
-	if err != nil {
-		log.Println("on err", err)
-	} else if !g.status {
+func (g *Gadget) on(val *Value) error {
+	if err := g.Output.On(val); err != nil {
+		return err
+	}
+	if !g.status {
 		g.targetValue = val
 		g.status = true
 		g.sendUpdate()
 	}
+	return nil
 }
 
-func (g *Gadget) off() {
+func (g *Gadget) off() error {
 	g.status = false
 	g.targetValue = nil
-	g.Output.Off()
-	g.compare = nil
+	err := g.Output.Off()
+	g.clearCompare()
+	g.stopTimer()
 	g.sendUpdate()
+	return err
+}
+
+//close lets the output device clean up (stop its goroutines,
+//release its pins) when the system shuts down.
+func (g *Gadget) close() {
+	if c, ok := g.Output.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
 func (g *Gadget) readMessage(msg *Message) {
@@ -213,59 +262,110 @@ func (g *Gadget) readUpdate(msg *Message) {
 func (g *Gadget) readCommand(msg *Message, onoff, matched string) {
 	if msg.Body == "shutdown" {
 		g.shutdown = true
-		g.off()
+		if g.Output != nil {
+			g.off()
+			g.close()
+		}
 	} else if msg.Body == "update" {
 		g.sendUpdate()
 	} else if onoff == "on" {
-		g.readOnCommand(msg, matched)
+		g.reply(msg, g.readOnCommand(msg, matched))
 	} else if onoff == "off" {
-		g.readOffCommand(msg)
+		g.reply(msg, g.readOffCommand(msg))
 	}
 }
 
-func (g *Gadget) readOnCommand(msg *Message, matched string) {
+func (g *Gadget) readOnCommand(msg *Message, matched string) error {
 	var val *Value
+	g.clearCompare()
+	g.stopTimer()
 	if len(strings.Trim(msg.Body, " ")) > len(matched) {
-		val, err := g.readOnArguments(msg.Body)
-		if err == nil {
-			g.on(val)
+		cmd, err := rcl.Parse(msg.Body)
+		if err != nil {
+			return err
+		}
+		if !isTarget(cmd, matched) {
+			return fmt.Errorf("unknown command: %s", msg.Body)
+		}
+		val, err = g.readOnArguments(msg.Body, cmd)
+		if err != nil {
+			return err
 		}
-	} else {
-		g.compare = nil
-		g.on(val)
 	}
+	return g.on(val)
 }
 
-func (g *Gadget) readOnArguments(cmd string) (*Value, error) {
-	var val *Value
-	value, unit, err := ParseCommand(cmd)
-	if err != nil {
-		return val, fmt.Errorf("could not parse %s", cmd)
+//isTarget is false for a command that only starts with the on
+//command ('turn on hlt valve fro 5 minutes' starts with 'turn on hlt
+//valve' too, but it isn't a command this gadget knows).
+func isTarget(cmd *rcl.Command, matched string) bool {
+	on, err := rcl.Parse(matched)
+	return err != nil || on.Target == cmd.Target
+}
+
+//handles is true if cmd is a command the gadget knows, so that the
+//rules can be checked when the system starts.
+func (g *Gadget) handles(cmd string) bool {
+	mine, onoff, matched := g.isMyCommand(&Message{Type: COMMAND, Body: cmd})
+	if !mine || onoff != "on" || len(strings.TrimSpace(cmd)) == len(matched) {
+		return mine
 	}
-	gadget, ok := units[unit]
+	c, err := rcl.Parse(cmd)
+	return err == nil && isTarget(c, matched)
+}
 
-	if !ok {
-		return nil, nil
+//readOnArguments sets up the timer and comparitor for a command
+//and returns the value (if any) that gets passed to the output.
+func (g *Gadget) readOnArguments(body string, cmd *rcl.Command) (*Value, error) {
+	var val *Value
+	if cmd.Condition != nil {
+		if err := g.setUntil(cmd.Condition); err != nil {
+			return val, err
+		}
 	}
 
-	val = &Value{
-		Value: value,
-		Units: unit,
-		Cmd:   cmd,
+	//a duration and a timeout both turn the gadget off so only
+	//the sooner of the two matters
+	var stop *rcl.Quantity
+	for _, t := range []*rcl.Quantity{cmd.Duration, cmd.Timeout} {
+		if t != nil && (stop == nil || g.getDuration(t.Value, t.Units) < g.getDuration(stop.Value, stop.Units)) {
+			stop = t
+		}
+	}
+	if stop != nil {
+		g.startTimer(stop.Value, stop.Units)
+	}
+	if cmd.Duration != nil {
+		val = &Value{
+			Value: cmd.Duration.Value,
+			Units: cmd.Duration.Units,
+			Cmd:   body,
+		}
 	}
 
-	if gadget == "time" {
-		go g.startTimer(value, unit, g.timerIn, g.timerOut)
-	} else if gadget == "volume" {
-		g.setCompare(value, unit, gadget)
+	if len(cmd.Arguments) > 0 {
+		a := cmd.Arguments[0]
+		val = &Value{
+			Value: a.Value,
+			Units: a.Units,
+			Cmd:   body,
+		}
+		if u, ok := GetUnit(a.Units); ok && u.Quantity == "volume" {
+			g.setCompare(a.Value, a.Units, u.Quantity)
+		}
 	}
 	return val, nil
 }
 
+//setCompare makes the comparitor that turns the gadget off once
+//the value in the command has been reached.  The value in the
+//update is converted to the units of the command first, so
+//'fill tank to 5 gallons' works with a flow meter that counts
+//liters.
 func (g *Gadget) setCompare(value float64, unit string, gadget string) {
 	if g.Operator == "<=" {
 		g.compare = func(msg *Message) bool {
-			val, ok := msg.Value.Value.(float64)
+			val, ok := msg.Value.In(unit)
 			return msg.Location == g.Location &&
 				ok &&
 				msg.Name == gadget &&
@@ -273,7 +373,7 @@ func (g *Gadget) setCompare(value float64, unit string, gadget string) {
 		}
 	} else if g.Operator == ">=" {
 		g.compare = func(msg *Message) bool {
-			val, ok := msg.Value.Value.(float64)
+			val, ok := msg.Value.In(unit)
 			return msg.Location == g.Location &&
 				ok &&
 				msg.Name == gadget &&
@@ -282,33 +382,80 @@ func (g *Gadget) setCompare(value float64, unit string, gadget string) {
 	}
 }
 
-func (g *Gadget) getDuration(value float64, unit string) time.Duration {
-	if unit == "minute" || unit == "minutes" {
-		value *= 60.0
-	} else if unit == "hour" || unit == "hours" {
-		value *= 3600.0
+//setUntil sets up the stop condition of a command like
+//
+//	turn on hlt valve until mash tun volume >= 20 liters or 10 minutes
+//
+//The gadget is turned off as soon as an update from the sensor
+//(mash tun volume) matches the condition, or once the optional
+//timeout has passed, whichever comes first.
+func (g *Gadget) setUntil(cond *rcl.Condition) error {
+	cmp, err := getCompare(cond.Operator, cond.Value, cond.Units)
+	if err != nil {
+		return err
+	}
+	g.compare = func(msg *Message) bool {
+		return msg.Sender == cond.Sensor && cmp(&msg.Value)
+	}
+	g.Subscriptions().Add(Subscription{Type: UPDATE, Sender: cond.Sensor})
+	return nil
+}
+
+//reply tells whoever sent a command how it went.  An error
+//is always sent back (as an ERROR message), success is only
+//acknowledged (with a DONE message) if the command has a UUID
+//the sender can match the reply to.
+func (g *Gadget) reply(msg *Message, err error) {
+	reply := Message{
+		UUID:     GetUUID(),
+		ReplyTo:  msg.UUID,
+		Sender:   g.GetUID(),
+		Target:   msg.Sender,
+		Type:     DONE,
+		Location: g.Location,
+		Name:     g.Name,
+		Body:     msg.Body,
+		Value:    Value{Cmd: msg.Body},
 	}
-	return time.Duration(value * float64(time.Second))
+	if err != nil {
+		log.Println(err)
+		reply.Type = ERROR
+		reply.Body = err.Error()
+	} else if msg.UUID == "" {
+		return
+	}
+	g.out <- reply
+}
+
+func (g *Gadget) getDuration(value float64, unit string) time.Duration {
+	d, _ := Quantity{Value: value, Units: unit}.Duration()
+	return d
+}
+
+//startTimer turns the gadget off (in doOutputLoop) once the
+//duration of a 'for' command or a timeout is up.
+func (g *Gadget) startTimer(value float64, unit string) {
+	g.timer = time.NewTimer(g.getDuration(value, unit))
 }
 
-func (g *Gadget) startTimer(value float64, unit string, in <-chan bool, out chan<- bool) {
-	d := g.getDuration(value, unit)
-	keepGoing := true
-	for keepGoing {
+//stopTimer stops the timer of a previous 'for' command (if it
+//is still running) and drains it so a timer that has already
+//fired can't turn off the gadget after a new command.
+func (g *Gadget) stopTimer() {
+	if g.timer != nil && !g.timer.Stop() {
 		select {
-		case <-in:
-			keepGoing = false
-		case <-time.After(d):
-			keepGoing = false
-			out <- true
+		case <-g.timer.C:
+		default:
 		}
 	}
+	g.timer = nil
 }
 
-func (g *Gadget) readOffCommand(msg *Message) {
+func (g *Gadget) readOffCommand(msg *Message) error {
 	if g.status {
-		g.off()
+		return g.off()
 	}
+	return nil
 }
 
 func (g *Gadget) GetUID() string {
@@ -329,6 +476,9 @@ func (g *Gadget) sendUpdate() {
 			Output: g.Output.Status(),
 			Cmd:    g.lastCmd,
 		}
+		if r, ok := g.Output.(Reporter); ok {
+			value.Data = r.Report()
+		}
 	}
 	g.out <- Message{
 		UUID:        GetUUID(),
@@ -347,35 +497,21 @@ func (g *Gadget) sendUpdate() {
 	}
 }
 
+//ParseCommand returns the value and units of the argument (or
+//duration) of a command, for example 5 and "liters" for
+//'fill tank to 5 liters'.
 func ParseCommand(cmd string) (float64, string, error) {
-	cmd = stripCommand(cmd)
-	value, unit, err := splitCommand(cmd)
-	var v float64
-	if err == nil {
-		v, err = strconv.ParseFloat(value, 64)
-	}
-	return v, unit, err
-}
-
-func splitCommand(cmd string) (string, string, error) {
-	parts := strings.Split(cmd, " ")
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid command: %s", cmd)
+	c, err := rcl.Parse(cmd)
+	if err != nil {
+		return 0, "", err
 	}
-	return parts[0], parts[1], nil
-}
-
-func stripCommand(cmd string) string {
-	cmd = strings.Trim(cmd, " ")
-	i := strings.Index(cmd, " for ")
-	if i != -1 {
-		return cmd[i+5:]
+	if len(c.Arguments) > 0 {
+		return c.Arguments[0].Value, c.Arguments[0].Units, nil
 	}
-	i = strings.Index(cmd, " to ")
-	if i != -1 {
-		return cmd[i+4:]
+	if c.Duration != nil {
+		return c.Duration.Value, c.Duration.Units, nil
 	}
-	return ""
+	return 0, "", fmt.Errorf("invalid command: %s", cmd)
 }
 
 // GetUUID generates a random UUID according to RFC 4122
@@ -1,6 +1,7 @@
 package gogadgets_test
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -205,6 +206,240 @@ var _ = Describe("gadgets", func() {
 			update = <-output
 			Expect(update.Value.Value).To(BeFalse())
 		})
+		It("doesn't get turned off by the timer of an earlier command", func() {
+			for i := 0; i < 5; i++ {
+				g := gogadgets.Gadget{
+					Location:    "lab",
+					Name:        "led",
+					OnCommands:  []string{"turn on lab led"},
+					OffCommands: []string{"turn off lab led"},
+					Output:      &FakeOutput{},
+					UID:         "lab led",
+				}
+				input := make(chan gogadgets.Message)
+				output := make(chan gogadgets.Message)
+				go g.Start(input, output)
+				<-output
+				input <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "turn on lab led for 0.01 seconds"}
+				<-output
+
+				//the timer runs out while the gadget is busy and a new
+				//command is waiting as soon as it is done
+				input <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "update"}
+				time.Sleep(50 * time.Millisecond)
+				go func() {
+					input <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "turn on lab led for 30 seconds"}
+				}()
+				time.Sleep(10 * time.Millisecond)
+				<-output
+
+				on := true
+				for done := false; !done; {
+					select {
+					case update := <-output:
+						on = update.Value.Value.(bool)
+					case <-time.After(50 * time.Millisecond):
+						done = true
+					}
+				}
+				Expect(on).To(BeTrue())
+				stop(input)
+			}
+		})
+		It("turns off when an until condition is met", func() {
+			g := gogadgets.Gadget{
+				Location:    "hlt",
+				Name:        "valve",
+				OnCommands:  []string{"turn on hlt valve"},
+				OffCommands: []string{"turn off hlt valve"},
+				Output:      &FakeOutput{},
+				UID:         "hlt valve",
+			}
+			input := make(chan gogadgets.Message)
+			output := make(chan gogadgets.Message)
+			go g.Start(input, output)
+			update := <-output
+			Expect(update.Value.Value).To(BeFalse())
+
+			input <- gogadgets.Message{
+				Type: "command",
+				Body: "turn on hlt valve until mash tun volume >= 5 gallons",
+			}
+			update = <-output
+			Expect(update.Value.Value).To(BeTrue())
+
+			//another sensor, and then not enough volume yet
+			input <- gogadgets.Message{
+				Sender: "hlt volume",
+				Type:   gogadgets.UPDATE,
+				Value:  gogadgets.Value{Value: 30.0, Units: "liters"},
+			}
+			input <- gogadgets.Message{
+				Sender: "mash tun volume",
+				Type:   gogadgets.UPDATE,
+				Value:  gogadgets.Value{Value: 18.0, Units: "liters"},
+			}
+			input <- gogadgets.Message{
+				Sender: "mash tun volume",
+				Type:   gogadgets.UPDATE,
+				Value:  gogadgets.Value{Value: 19.0, Units: "liters"},
+			}
+			update = <-output
+			Expect(update.Value.Value).To(BeFalse())
+		})
+
+		It("turns off after the timeout of an until condition", func() {
+			g := gogadgets.Gadget{
+				Location:    "greenhouse",
+				Name:        "fan",
+				OnCommands:  []string{"turn on greenhouse fan"},
+				OffCommands: []string{"turn off greenhouse fan"},
+				Output:      &FakeOutput{},
+				UID:         "greenhouse fan",
+			}
+			input := make(chan gogadgets.Message)
+			output := make(chan gogadgets.Message)
+			go g.Start(input, output)
+			<-output
+
+			input <- gogadgets.Message{
+				Type: "command",
+				Body: "turn on greenhouse fan until greenhouse temperature < 25 C or 0.05 seconds",
+			}
+			update := <-output
+			Expect(update.Value.Value).To(BeTrue())
+			input <- gogadgets.Message{
+				Sender: "greenhouse temperature",
+				Type:   gogadgets.UPDATE,
+				Value:  gogadgets.Value{Value: 80.0, Units: "F"},
+			}
+			update = <-output
+			Expect(update.Value.Value).To(BeFalse())
+		})
+
+		It("acknowledges a command", func() {
+			g := gogadgets.Gadget{
+				Location:    "lab",
+				Name:        "led",
+				OnCommands:  []string{"turn on lab led"},
+				OffCommands: []string{"turn off lab led"},
+				Output:      &FakeOutput{},
+				UID:         "lab led",
+			}
+			input := make(chan gogadgets.Message)
+			output := make(chan gogadgets.Message)
+			go g.Start(input, output)
+			<-output
+
+			input <- gogadgets.Message{
+				UUID:   "abc",
+				Type:   gogadgets.COMMAND,
+				Sender: "http api",
+				Body:   "turn on lab led",
+			}
+			update := <-output
+			Expect(update.Type).To(Equal(gogadgets.UPDATE))
+			Expect(update.Value.Value).To(BeTrue())
+			msg := <-output
+			Expect(msg.Type).To(Equal(gogadgets.DONE))
+			Expect(msg.ReplyTo).To(Equal("abc"))
+			Expect(msg.Target).To(Equal("http api"))
+			Expect(msg.Sender).To(Equal("lab led"))
+			Expect(msg.Body).To(Equal("turn on lab led"))
+		})
+
+		It("sends an error when the output fails", func() {
+			g := gogadgets.Gadget{
+				Location:    "lab",
+				Name:        "led",
+				OnCommands:  []string{"turn on lab led"},
+				OffCommands: []string{"turn off lab led"},
+				Output:      &FakeOutput{err: errors.New("gpio is gone")},
+				UID:         "lab led",
+			}
+			input := make(chan gogadgets.Message)
+			output := make(chan gogadgets.Message)
+			go g.Start(input, output)
+			<-output
+
+			input <- gogadgets.Message{
+				UUID:   "abc",
+				Type:   gogadgets.COMMAND,
+				Sender: "http api",
+				Body:   "turn on lab led",
+			}
+			msg := <-output
+			Expect(msg.Type).To(Equal(gogadgets.ERROR))
+			Expect(msg.ReplyTo).To(Equal("abc"))
+			Expect(msg.Body).To(Equal("gpio is gone"))
+			Expect(msg.Value.Cmd).To(Equal("turn on lab led"))
+		})
+
+		It("sends an error for a bad until condition", func() {
+			g := gogadgets.Gadget{
+				Location:    "greenhouse",
+				Name:        "fan",
+				OnCommands:  []string{"turn on greenhouse fan"},
+				OffCommands: []string{"turn off greenhouse fan"},
+				Output:      &FakeOutput{},
+				UID:         "greenhouse fan",
+			}
+			input := make(chan gogadgets.Message)
+			output := make(chan gogadgets.Message)
+			go g.Start(input, output)
+			<-output
+
+			input <- gogadgets.Message{
+				Type:   "command",
+				Sender: "http api",
+				Body:   "turn on greenhouse fan until it's cool",
+			}
+			msg := <-output
+			Expect(msg.Type).To(Equal(gogadgets.ERROR))
+			Expect(msg.Target).To(Equal("http api"))
+			Expect(msg.Body).To(ContainSubstring("at position 38"))
+			Expect(msg.Value.Cmd).To(Equal("turn on greenhouse fan until it's cool"))
+
+			input <- gogadgets.Message{
+				Type: "command",
+				Body: "update",
+			}
+			update := <-output
+			Expect(update.Value.Value).To(BeFalse())
+		})
+
+		It("sends an error for a command with a typo after the gadget", func() {
+			g := gogadgets.Gadget{
+				Location:    "hlt",
+				Name:        "valve",
+				OnCommands:  []string{"turn on hlt valve"},
+				OffCommands: []string{"turn off hlt valve"},
+				Output:      &FakeOutput{},
+				UID:         "hlt valve",
+			}
+			input := make(chan gogadgets.Message)
+			output := make(chan gogadgets.Message)
+			go g.Start(input, output)
+			<-output
+
+			for _, body := range []string{"turn on hlt valve fro 5 minutes", "turn on hlt valve for 5 minuts"} {
+				input <- gogadgets.Message{
+					Type:   "command",
+					Sender: "http api",
+					Body:   body,
+				}
+				msg := <-output
+				Expect(msg.Type).To(Equal(gogadgets.ERROR), body)
+			}
+
+			input <- gogadgets.Message{
+				Type: "command",
+				Body: "update",
+			}
+			update := <-output
+			Expect(update.Value.Value).To(BeFalse())
+		})
+
 		It("starts a switch", func() {
 			location := "lab"
 			name := "switch"
@@ -1,13 +1,17 @@
 package gogadgets_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/cswank/gogadgets"
+	. "github.com/onsi/gomega"
 )
 
 type FakeOutput struct {
-	on bool
+	on  bool
+	err error
 }
 
 func (f *FakeOutput) Commands(l, n string) *gogadgets.Commands {
@@ -23,6 +27,9 @@ func (f *FakeOutput) Update(msg *gogadgets.Message) bool {
 }
 
 func (f *FakeOutput) On(val *gogadgets.Value) error {
+	if f.err != nil {
+		return f.err
+	}
 	f.on = true
 	return nil
 }
@@ -54,3 +61,44 @@ func (f *FakePoller) Wait() (bool, error) {
 	}
 	return f.val, nil
 }
+
+//stop shuts down the gadget that reads from in.
+func stop(in chan<- gogadgets.Message) {
+	in <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+}
+
+//sensorUpdate is an update from the sensor called name at location.
+func sensorUpdate(location, name string, v interface{}, units string) gogadgets.Message {
+	return gogadgets.Message{
+		Type:     gogadgets.UPDATE,
+		Sender:   fmt.Sprintf("%s %s", location, name),
+		Location: location,
+		Name:     name,
+		Value:    gogadgets.Value{Value: v, Units: units},
+	}
+}
+
+//stamped gives msg the time it was sent (like a replayed or
+//spooled update).
+func stamped(msg gogadgets.Message, t time.Time) *gogadgets.Message {
+	msg.Timestamp = t
+	return &msg
+}
+
+//gadgetConfig is the config of a gadget of type typ, args are json.
+func gadgetConfig(typ, args string) *gogadgets.GadgetConfig {
+	cfg := &gogadgets.GadgetConfig{}
+	Expect(json.Unmarshal([]byte(fmt.Sprintf(`{"type": "%s", "args": %s}`, typ, args)), cfg)).To(BeNil())
+	return cfg
+}
+
+//newRecorder makes a recorder from the args (json) of its pin and
+//turns it on.
+func newRecorder(args string) *gogadgets.Recorder {
+	p := &gogadgets.Pin{}
+	Expect(json.Unmarshal([]byte(fmt.Sprintf(`{"args": %s}`, args)), p)).To(BeNil())
+	x, err := gogadgets.NewRecorder(p)
+	Expect(err).To(BeNil())
+	x.On(nil)
+	return x.(*gogadgets.Recorder)
+}
@@ -41,6 +41,14 @@ type GPIO struct {
 	buf           []byte
 }
 
+//DigitalPin is what every GPIO backend provides.  Output
+//devices use it to turn things on and off and input devices
+//(switches, flow meters) use Wait to poll it.
+type DigitalPin interface {
+	OutputDevice
+	Wait() (bool, error)
+}
+
 func GPIOFactory(pin *Pin) (OutputDevice, error) {
 	g, err := NewGPIO(pin)
 	if err != nil {
@@ -49,24 +57,26 @@ func GPIOFactory(pin *Pin) (OutputDevice, error) {
 	return g, nil
 }
 
-func NewGPIO(pin *Pin) (*GPIO, error) {
-	var export string
-	var ok bool
-	if pin.Platform == "rpi" {
-		export, ok = PiPins[pin.Pin]
-		if !ok {
-			return nil, fmt.Errorf("no such pin: %s", pin.Pin)
-		}
-	} else {
-		var portMap map[string]string
-		portMap, ok = Pins["gpio"][pin.Port]
-		if !ok {
-			return nil, fmt.Errorf("no such port: %s", pin.Port)
-		}
-		export, ok = portMap[pin.Pin]
-		if !ok {
-			return nil, fmt.Errorf("no such pin: %s", pin.Pin)
-		}
+//NewGPIO returns the GPIO backend selected by pin.Backend.  The
+//default is the sysfs interface, "cdev" uses /dev/gpiochipN.  Pins
+//on the "sim" platform get a simulated gpio instead.
+func NewGPIO(pin *Pin) (DigitalPin, error) {
+	if pin.Platform == "sim" {
+		return Sim.GPIO(pin), nil
+	}
+	switch pin.Backend {
+	case "", "sysfs":
+		return NewSysfsGPIO(pin)
+	case "cdev":
+		return NewCdevGPIO(pin)
+	}
+	return nil, fmt.Errorf("invalid gpio backend: %s", pin.Backend)
+}
+
+func NewSysfsGPIO(pin *Pin) (*GPIO, error) {
+	export, err := gpioExport(pin)
+	if err != nil {
+		return nil, err
 	}
 	if pin.Direction == "" {
 		pin.Direction = "out"
@@ -82,10 +92,30 @@ func NewGPIO(pin *Pin) (*GPIO, error) {
 		activeLow:     pin.ActiveLow,
 		edge:          pin.Edge,
 	}
-	err := g.Init()
+	err = g.Init()
 	return g, err
 }
 
+//gpioExport looks up the kernel gpio number for a pin.
+func gpioExport(pin *Pin) (string, error) {
+	if pin.Platform == "rpi" {
+		export, ok := PiPins[pin.Pin]
+		if !ok {
+			return "", fmt.Errorf("no such pin: %s", pin.Pin)
+		}
+		return export, nil
+	}
+	portMap, ok := Pins["gpio"][pin.Port]
+	if !ok {
+		return "", fmt.Errorf("no such port: %s", pin.Port)
+	}
+	export, ok := portMap[pin.Pin]
+	if !ok {
+		return "", fmt.Errorf("no such pin: %s", pin.Pin)
+	}
+	return export, nil
+}
+
 func (g *GPIO) Commands(location, name string) *Commands {
 	return nil
 }
@@ -0,0 +1,340 @@
+// +build !windows
+
+package gogadgets
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+//Line flags, attribute ids and event ids from the linux
+//gpio v2 uAPI (include/uapi/linux/gpio.h).
+const (
+	GPIOLineFlagUsed          = 1 << 0
+	GPIOLineFlagActiveLow     = 1 << 1
+	GPIOLineFlagInput         = 1 << 2
+	GPIOLineFlagOutput        = 1 << 3
+	GPIOLineFlagEdgeRising    = 1 << 4
+	GPIOLineFlagEdgeFalling   = 1 << 5
+	GPIOLineFlagOpenDrain     = 1 << 6
+	GPIOLineFlagOpenSource    = 1 << 7
+	GPIOLineFlagBiasPullUp    = 1 << 8
+	GPIOLineFlagBiasPullDown  = 1 << 9
+	GPIOLineFlagBiasDisabled  = 1 << 10
+	GPIOLineFlagEventRealtime = 1 << 11
+
+	GPIOLineAttrFlags        = 1
+	GPIOLineAttrOutputValues = 2
+	GPIOLineAttrDebounce     = 3
+
+	GPIOLineEventRisingEdge  = 1
+	GPIOLineEventFallingEdge = 2
+)
+
+var (
+	GPIO_CDEV_PATH = "/dev"
+
+	//GPIOCdev is what every CdevGPIO uses to talk to the kernel.
+	//Replace it to run the cdev backend without hardware.
+	GPIOCdev GPIOCdevIO = &sysCdev{}
+
+	gpioV2GetLineIoctl   = gpioIOWR(0x07, unsafe.Sizeof(GPIOLineRequest{}))
+	gpioV2GetValuesIoctl = gpioIOWR(0x0E, unsafe.Sizeof(GPIOLineValues{}))
+	gpioV2SetValuesIoctl = gpioIOWR(0x0F, unsafe.Sizeof(GPIOLineValues{}))
+)
+
+//GPIOLineAttribute mirrors struct gpio_v2_line_attribute.  Value
+//holds the flags, values or debounce period depending on ID.
+type GPIOLineAttribute struct {
+	ID      uint32
+	Padding uint32
+	Value   uint64
+}
+
+//GPIOLineConfigAttribute mirrors struct gpio_v2_line_config_attribute.
+type GPIOLineConfigAttribute struct {
+	Attr GPIOLineAttribute
+	Mask uint64
+}
+
+//GPIOLineConfig mirrors struct gpio_v2_line_config.
+type GPIOLineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [10]GPIOLineConfigAttribute
+}
+
+//GPIOLineRequest mirrors struct gpio_v2_line_request.  Fd is
+//filled in by the kernel when the request succeeds.
+type GPIOLineRequest struct {
+	Offsets         [64]uint32
+	Consumer        [32]byte
+	Config          GPIOLineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	Fd              int32
+}
+
+//GPIOLineValues mirrors struct gpio_v2_line_values.
+type GPIOLineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+//GPIOLineEvent mirrors struct gpio_v2_line_event.
+type GPIOLineEvent struct {
+	TimestampNs uint64
+	ID          uint32
+	Offset      uint32
+	Seqno       uint32
+	LineSeqno   uint32
+	Padding     [6]uint32
+}
+
+//GPIOCdevIO is the thin layer between CdevGPIO and the gpio
+//character device.  The default implementation opens the chip
+//and issues ioctls, a fake one lets tests exercise CdevGPIO.
+type GPIOCdevIO interface {
+	RequestLine(chip string, req *GPIOLineRequest) error
+	GetValues(fd int, vals *GPIOLineValues) error
+	SetValues(fd int, vals *GPIOLineValues) error
+	ReadEvent(fd int, ev *GPIOLineEvent) error
+	Close(fd int) error
+}
+
+//CdevGPIO turns pins on and off (and waits for edges) through
+//the gpio character device (/dev/gpiochipN) and the v2 uAPI.
+//It is used instead of GPIO when a Pin has "backend": "cdev",
+//which is needed on kernels that no longer ship /sys/class/gpio.
+//
+//By default the chip and line are worked out from the same Pins
+//and PiPins tables that GPIO uses (each BeagleBone bank has 32
+//lines, the Pi has a single chip).  Set pin.Chip to address a
+//line directly, in which case pin.Pin is the line offset.
+type CdevGPIO struct {
+	chip      string
+	offset    uint32
+	direction string
+	fd        int
+	io        GPIOCdevIO
+}
+
+func NewCdevGPIO(pin *Pin) (*CdevGPIO, error) {
+	chip, offset, err := cdevLine(pin)
+	if err != nil {
+		return nil, err
+	}
+	if pin.Direction == "" {
+		pin.Direction = "out"
+	}
+	flags, err := cdevFlags(pin)
+	if err != nil {
+		return nil, err
+	}
+	req := &GPIOLineRequest{NumLines: 1}
+	req.Offsets[0] = offset
+	req.Config.Flags = flags
+	copy(req.Consumer[:], "gogadgets")
+
+	g := &CdevGPIO{
+		chip:      chip,
+		offset:    offset,
+		direction: pin.Direction,
+		io:        GPIOCdev,
+	}
+	if err := g.io.RequestLine(chip, req); err != nil {
+		return nil, fmt.Errorf("could not request line %d of %s: %v", offset, chip, err)
+	}
+	g.fd = int(req.Fd)
+	return g, nil
+}
+
+func (g *CdevGPIO) Commands(location, name string) *Commands {
+	return nil
+}
+
+func (g *CdevGPIO) Config() ConfigHelper {
+	return ConfigHelper{
+		PinType: "gpio",
+		Pins:    Pins["gpio"],
+		Fields: map[string][]string{
+			"backend": []string{"sysfs", "cdev"},
+			"bias":    []string{"pull-up", "pull-down", "disable"},
+			"drive":   []string{"push-pull", "open-drain", "open-source"},
+			"edge":    []string{"rising", "falling", "both"},
+		},
+	}
+}
+
+func (g *CdevGPIO) Update(msg *Message) bool {
+	return false
+}
+
+func (g *CdevGPIO) On(val *Value) error {
+	return g.io.SetValues(g.fd, &GPIOLineValues{Bits: 1, Mask: 1})
+}
+
+func (g *CdevGPIO) Off() error {
+	return g.io.SetValues(g.fd, &GPIOLineValues{Bits: 0, Mask: 1})
+}
+
+func (g *CdevGPIO) Status() map[string]bool {
+	vals := &GPIOLineValues{Mask: 1}
+	err := g.io.GetValues(g.fd, vals)
+	return map[string]bool{"gpio": err == nil && vals.Bits&1 == 1}
+}
+
+//Wait blocks until the kernel reports an edge on the line.  It
+//returns true for a rising edge (after active low is applied).
+func (g *CdevGPIO) Wait() (bool, error) {
+	var ev GPIOLineEvent
+	if err := g.io.ReadEvent(g.fd, &ev); err != nil {
+		return false, err
+	}
+	return ev.ID == GPIOLineEventRisingEdge, nil
+}
+
+//Close releases the line back to the kernel.
+func (g *CdevGPIO) Close() error {
+	return g.io.Close(g.fd)
+}
+
+func cdevLine(pin *Pin) (string, uint32, error) {
+	if pin.Chip != "" {
+		o, err := strconv.ParseUint(pin.Pin, 10, 32)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid line offset: %s", pin.Pin)
+		}
+		return cdevChipPath(pin.Chip), uint32(o), nil
+	}
+	export, err := gpioExport(pin)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := strconv.ParseUint(export, 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid gpio number: %s", export)
+	}
+	if pin.Platform == "rpi" {
+		return cdevChipPath("gpiochip0"), uint32(n), nil
+	}
+	return cdevChipPath(fmt.Sprintf("gpiochip%d", n/32)), uint32(n % 32), nil
+}
+
+func cdevChipPath(chip string) string {
+	if strings.HasPrefix(chip, "/") {
+		return chip
+	}
+	return path.Join(GPIO_CDEV_PATH, chip)
+}
+
+func cdevFlags(pin *Pin) (uint64, error) {
+	var flags uint64
+	switch pin.Direction {
+	case "in":
+		flags |= GPIOLineFlagInput
+	case "out":
+		flags |= GPIOLineFlagOutput
+	default:
+		return 0, fmt.Errorf("invalid direction: %s", pin.Direction)
+	}
+
+	if pin.ActiveLow == "1" {
+		flags |= GPIOLineFlagActiveLow
+	}
+
+	switch pin.Edge {
+	case "", "none":
+	case "rising":
+		flags |= GPIOLineFlagEdgeRising
+	case "falling":
+		flags |= GPIOLineFlagEdgeFalling
+	case "both":
+		flags |= GPIOLineFlagEdgeRising | GPIOLineFlagEdgeFalling
+	default:
+		return 0, fmt.Errorf("invalid edge: %s", pin.Edge)
+	}
+	if pin.Direction == "out" && flags&(GPIOLineFlagEdgeRising|GPIOLineFlagEdgeFalling) != 0 {
+		return 0, fmt.Errorf("edge detection needs an input pin")
+	}
+
+	switch pin.Bias {
+	case "":
+	case "pull-up":
+		flags |= GPIOLineFlagBiasPullUp
+	case "pull-down":
+		flags |= GPIOLineFlagBiasPullDown
+	case "disable", "disabled":
+		flags |= GPIOLineFlagBiasDisabled
+	default:
+		return 0, fmt.Errorf("invalid bias: %s", pin.Bias)
+	}
+
+	switch pin.Drive {
+	case "", "push-pull":
+	case "open-drain":
+		flags |= GPIOLineFlagOpenDrain
+	case "open-source":
+		flags |= GPIOLineFlagOpenSource
+	default:
+		return 0, fmt.Errorf("invalid drive: %s", pin.Drive)
+	}
+	if pin.Direction == "in" && flags&(GPIOLineFlagOpenDrain|GPIOLineFlagOpenSource) != 0 {
+		return 0, fmt.Errorf("drive can only be set on an output pin")
+	}
+	return flags, nil
+}
+
+//gpioIOWR builds an _IOWR ioctl number for the gpio (0xB4) ioctls.
+func gpioIOWR(nr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | 0xB4<<8 | nr
+}
+
+type sysCdev struct{}
+
+func (s *sysCdev) RequestLine(chip string, req *GPIOLineRequest) error {
+	fd, err := syscall.Open(chip, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	return ioctl(fd, gpioV2GetLineIoctl, unsafe.Pointer(req))
+}
+
+func (s *sysCdev) GetValues(fd int, vals *GPIOLineValues) error {
+	return ioctl(fd, gpioV2GetValuesIoctl, unsafe.Pointer(vals))
+}
+
+func (s *sysCdev) SetValues(fd int, vals *GPIOLineValues) error {
+	return ioctl(fd, gpioV2SetValuesIoctl, unsafe.Pointer(vals))
+}
+
+func (s *sysCdev) ReadEvent(fd int, ev *GPIOLineEvent) error {
+	buf := (*[unsafe.Sizeof(GPIOLineEvent{})]byte)(unsafe.Pointer(ev))[:]
+	n, err := syscall.Read(fd, buf)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return fmt.Errorf("short read of gpio event: %d bytes", n)
+	}
+	return nil
+}
+
+func (s *sysCdev) Close(fd int) error {
+	return syscall.Close(fd)
+}
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package gogadgets_test
+
+import (
+	"sync"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeCdev struct {
+	lock   sync.Mutex
+	chips  map[int]string
+	reqs   map[int]gogadgets.GPIOLineRequest
+	values map[int]uint64
+	events chan gogadgets.GPIOLineEvent
+	closed map[int]bool
+	next   int
+}
+
+func newFakeCdev() *fakeCdev {
+	return &fakeCdev{
+		chips:  map[int]string{},
+		reqs:   map[int]gogadgets.GPIOLineRequest{},
+		values: map[int]uint64{},
+		events: make(chan gogadgets.GPIOLineEvent),
+		closed: map[int]bool{},
+		next:   10,
+	}
+}
+
+func (f *fakeCdev) RequestLine(chip string, req *gogadgets.GPIOLineRequest) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.next++
+	req.Fd = int32(f.next)
+	f.chips[f.next] = chip
+	f.reqs[f.next] = *req
+	return nil
+}
+
+func (f *fakeCdev) GetValues(fd int, vals *gogadgets.GPIOLineValues) error {
+	f.lock.Lock()
+	vals.Bits = f.values[fd] & vals.Mask
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *fakeCdev) SetValues(fd int, vals *gogadgets.GPIOLineValues) error {
+	f.lock.Lock()
+	f.values[fd] = (f.values[fd] &^ vals.Mask) | (vals.Bits & vals.Mask)
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *fakeCdev) ReadEvent(fd int, ev *gogadgets.GPIOLineEvent) error {
+	*ev = <-f.events
+	return nil
+}
+
+func (f *fakeCdev) Close(fd int) error {
+	f.lock.Lock()
+	f.closed[fd] = true
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *fakeCdev) request(fd int) (string, gogadgets.GPIOLineRequest) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.chips[fd], f.reqs[fd]
+}
+
+var _ = Describe("cdev gpio", func() {
+	var (
+		fake *fakeCdev
+		orig gogadgets.GPIOCdevIO
+	)
+
+	BeforeEach(func() {
+		fake = newFakeCdev()
+		orig = gogadgets.GPIOCdev
+		gogadgets.GPIOCdev = fake
+	})
+
+	AfterEach(func() {
+		gogadgets.GPIOCdev = orig
+	})
+
+	Describe("output", func() {
+		It("requests the line that matches the beaglebone pin", func() {
+			g, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend: "cdev",
+				Port:    "8",
+				Pin:     "11",
+				Drive:   "open-drain",
+			})
+			Expect(err).To(BeNil())
+			Expect(g).To(BeAssignableToTypeOf(&gogadgets.CdevGPIO{}))
+
+			chip, req := fake.request(11)
+			Expect(chip).To(Equal("/dev/gpiochip1"))
+			Expect(req.NumLines).To(Equal(uint32(1)))
+			Expect(req.Offsets[0]).To(Equal(uint32(13)))
+			Expect(req.Config.Flags).To(Equal(uint64(gogadgets.GPIOLineFlagOutput | gogadgets.GPIOLineFlagOpenDrain)))
+		})
+
+		It("uses gpiochip0 on the pi", func() {
+			_, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend:  "cdev",
+				Platform: "rpi",
+				Pin:      "11",
+			})
+			Expect(err).To(BeNil())
+			chip, req := fake.request(11)
+			Expect(chip).To(Equal("/dev/gpiochip0"))
+			Expect(req.Offsets[0]).To(Equal(uint32(17)))
+		})
+
+		It("lets the chip and line be set directly", func() {
+			_, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend: "cdev",
+				Chip:    "gpiochip3",
+				Pin:     "21",
+			})
+			Expect(err).To(BeNil())
+			chip, req := fake.request(11)
+			Expect(chip).To(Equal("/dev/gpiochip3"))
+			Expect(req.Offsets[0]).To(Equal(uint32(21)))
+		})
+
+		It("turns on and off", func() {
+			g, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend: "cdev",
+				Port:    "8",
+				Pin:     "11",
+			})
+			Expect(err).To(BeNil())
+			Expect(g.Status()["gpio"]).To(BeFalse())
+			Expect(g.On(nil)).To(BeNil())
+			Expect(g.Status()["gpio"]).To(BeTrue())
+			Expect(g.Off()).To(BeNil())
+			Expect(g.Status()["gpio"]).To(BeFalse())
+		})
+
+		It("releases the line", func() {
+			g, err := gogadgets.NewCdevGPIO(&gogadgets.Pin{
+				Port: "8",
+				Pin:  "11",
+			})
+			Expect(err).To(BeNil())
+			Expect(g.Close()).To(BeNil())
+			Expect(fake.closed[11]).To(BeTrue())
+		})
+
+		It("won't do edge detection on an output", func() {
+			_, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend: "cdev",
+				Port:    "8",
+				Pin:     "11",
+				Edge:    "rising",
+			})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("input", func() {
+		It("sets bias, edge and active low", func() {
+			_, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend:   "cdev",
+				Port:      "8",
+				Pin:       "11",
+				Direction: "in",
+				Edge:      "both",
+				Bias:      "pull-up",
+				ActiveLow: "1",
+			})
+			Expect(err).To(BeNil())
+			_, req := fake.request(11)
+			Expect(req.Config.Flags).To(Equal(uint64(
+				gogadgets.GPIOLineFlagInput |
+					gogadgets.GPIOLineFlagActiveLow |
+					gogadgets.GPIOLineFlagEdgeRising |
+					gogadgets.GPIOLineFlagEdgeFalling |
+					gogadgets.GPIOLineFlagBiasPullUp,
+			)))
+		})
+
+		It("won't set the drive on an input", func() {
+			_, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend:   "cdev",
+				Port:      "8",
+				Pin:       "11",
+				Direction: "in",
+				Drive:     "open-source",
+			})
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("waits for edges", func() {
+			g, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Backend:   "cdev",
+				Port:      "8",
+				Pin:       "11",
+				Direction: "in",
+				Edge:      "both",
+			})
+			Expect(err).To(BeNil())
+			go func() {
+				fake.events <- gogadgets.GPIOLineEvent{ID: gogadgets.GPIOLineEventRisingEdge}
+				fake.events <- gogadgets.GPIOLineEvent{ID: gogadgets.GPIOLineEventFallingEdge}
+			}()
+			v, err := g.Wait()
+			Expect(err).To(BeNil())
+			Expect(v).To(BeTrue())
+			v, err = g.Wait()
+			Expect(err).To(BeNil())
+			Expect(v).To(BeFalse())
+		})
+
+		It("drives a switch", func() {
+			dev, err := gogadgets.NewSwitch(&gogadgets.Pin{
+				Backend: "cdev",
+				Port:    "8",
+				Pin:     "11",
+				Edge:    "both",
+				Value:   true,
+			})
+			Expect(err).To(BeNil())
+			in := make(chan gogadgets.Message)
+			out := make(chan gogadgets.Value)
+			go dev.Start(in, out)
+			val := <-out
+			Expect(val.Value).To(BeFalse())
+			fake.events <- gogadgets.GPIOLineEvent{ID: gogadgets.GPIOLineEventRisingEdge}
+			val = <-out
+			Expect(val.Value).To(BeTrue())
+		})
+	})
+})
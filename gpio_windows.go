@@ -2,7 +2,12 @@ package gogadgets
 
 type GPIO struct{}
 
-func NewGPIO(pin *Pin) (OutputDevice, error) {
+type DigitalPin interface {
+	OutputDevice
+	Wait() (bool, error)
+}
+
+func NewGPIO(pin *Pin) (DigitalPin, error) {
 	return nil, nil
 }
 
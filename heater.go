@@ -15,14 +15,17 @@ type Heater struct {
 	waitTime    time.Duration
 	t1          time.Time
 	target      float64
+	units       string
 	currentTemp float64
 	duration    time.Duration
 	status      bool
 	gpioStatus  bool
 	doPWM       bool
+	hardwarePWM bool
 	gpio        OutputDevice
 	io          chan *Value
 	update      chan *Message
+	stop        chan bool
 	started     bool
 }
 
@@ -34,15 +37,24 @@ func NewHeater(pin *Pin) (OutputDevice, error) {
 	if pin.Frequency == 0 {
 		pin.Frequency = 1
 	}
-	dev, err = NewGPIO(pin)
+	//With a working pwm driver the duty cycle is set in hardware
+	//instead of toggling a gpio.
+	hardwarePWM := doPWM && pin.Backend == "pwmchip"
+	if hardwarePWM {
+		dev, err = NewPWM(pin)
+	} else {
+		dev, err = NewGPIO(pin)
+	}
 	if err == nil {
 		h = &Heater{
-			toggleTime: 100 * time.Hour,
-			gpio:       dev,
-			target:     100.0,
-			doPWM:      doPWM,
-			io:         make(chan *Value),
-			update:     make(chan *Message),
+			toggleTime:  100 * time.Hour,
+			gpio:        dev,
+			target:      100.0,
+			doPWM:       doPWM && !hardwarePWM,
+			hardwarePWM: hardwarePWM,
+			io:          make(chan *Value),
+			update:      make(chan *Message),
+			stop:        make(chan bool),
 		}
 	}
 	return h, err
@@ -97,10 +109,21 @@ func (h *Heater) Off() error {
 	return nil
 }
 
+//Close stops the goroutine that toggles the gpio.  The heater
+//should be turned off first.
+func (h *Heater) Close() error {
+	if h.started {
+		h.started = false
+		h.stop <- true
+	}
+	return nil
+}
+
 /*
 The pwm drivers on beaglebone black seem to be
 broken.  This function brings the same functionality
-using gpio.
+using gpio.  On current kernels the pwmchip backend
+works and the duty cycle is set in hardware instead.
 */
 func (h *Heater) toggle(value chan *Value, update chan *Message) {
 	for {
@@ -108,6 +131,13 @@ func (h *Heater) toggle(value chan *Value, update chan *Message) {
 		case val := <-value:
 			switch v := val.Value.(type) {
 			case float64:
+				if h.hardwarePWM {
+					h.waitTime = 100 * time.Hour
+					h.getTarget(val)
+					h.status = true
+					h.setDuty()
+					continue
+				}
 				h.waitTime = 100 * time.Millisecond
 				h.getTarget(val)
 				h.setDuty()
@@ -129,6 +159,8 @@ func (h *Heater) toggle(value chan *Value, update chan *Message) {
 			}
 		case m := <-update:
 			h.readTemperature(m)
+		case <-h.stop:
+			return
 		case _ = <-time.After(h.waitTime):
 			n := time.Now()
 			diff := n.Sub(h.t1)
@@ -157,12 +189,13 @@ func (h *Heater) getTarget(val *Value) {
 		t, ok := val.ToFloat()
 		if ok {
 			h.target = t
+			h.units = val.Units
 		}
 	}
 }
 
 func (h *Heater) readTemperature(msg *Message) {
-	temp, ok := msg.Value.ToFloat()
+	temp, ok := msg.Value.In(h.units)
 	if ok {
 		h.currentTemp = temp
 		if h.status {
@@ -191,9 +224,20 @@ func (h *Heater) setDuty() {
 		h.onTime = 4 * time.Second
 		h.offTime = 0 * time.Second
 	}
+	if h.hardwarePWM {
+		if h.status {
+			h.gpio.On(h.dutyCycle())
+		}
+		return
+	}
 	if h.gpioStatus {
 		h.toggleTime = h.onTime
 	} else {
 		h.toggleTime = h.offTime
 	}
 }
+
+func (h *Heater) dutyCycle() *Value {
+	d := 100.0 * float64(h.onTime) / float64(h.onTime+h.offTime)
+	return &Value{Value: d, Units: "%"}
+}
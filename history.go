@@ -0,0 +1,538 @@
+package gogadgets
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cswank/rex"
+)
+
+const (
+	defaultRetention  = 7 * 24 * time.Hour
+	defaultRaw        = 24 * time.Hour
+	defaultResolution = time.Minute
+	defaultSegment    = time.Hour
+
+	//how many updates can wait to be written to the history
+	historyBuffer = 1000
+)
+
+//History keeps the values of the gadgets in files so they can be
+//looked at later (even when the node can't reach anything else).
+//
+//The points are written to segment files that each hold Segment
+//worth of time.  Segments older than Raw are downsampled to one
+//point (with the min, max and average) per Resolution for each
+//gadget, and segments older than Retention are deleted.
+type History struct {
+	dir        string
+	retention  time.Duration
+	raw        time.Duration
+	resolution time.Duration
+	segment    time.Duration
+	lock       sync.Mutex
+	f          *os.File
+	w          *bufio.Writer
+	start      time.Time
+
+	//files is held for writing while segments are downsampled or
+	//deleted and for reading by queries, so a query doesn't keep
+	//the new points from being written.
+	files sync.RWMutex
+}
+
+//HistoryConfig turns on the history when Path (a directory) is
+//set.  The others are go durations (like 168h) and default to a
+//week, a day, a minute and an hour.
+type HistoryConfig struct {
+	Path       string `json:"path,omitempty"`
+	Retention  string `json:"retention,omitempty"`
+	Raw        string `json:"raw,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+	Segment    string `json:"segment,omitempty"`
+}
+
+//Point is the min, max and average of the values in a span of
+//time (or just one value when Count is 1).
+type Point struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Avg   float64   `json:"avg"`
+	Max   float64   `json:"max"`
+	Count int       `json:"count"`
+}
+
+//record is how a point is stored, a raw value has a count of 1.
+type record struct {
+	key   string
+	t     int64
+	min   float64
+	max   float64
+	sum   float64
+	count uint32
+}
+
+func NewHistory(cfg HistoryConfig) (*History, error) {
+	h := &History{dir: cfg.Path}
+	for _, d := range []struct {
+		s   string
+		v   *time.Duration
+		def time.Duration
+	}{
+		{cfg.Retention, &h.retention, defaultRetention},
+		{cfg.Raw, &h.raw, defaultRaw},
+		{cfg.Resolution, &h.resolution, defaultResolution},
+		{cfg.Segment, &h.segment, defaultSegment},
+	} {
+		*d.v = d.def
+		if d.s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil {
+			return nil, err
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("history durations have to be positive: %s", d.s)
+		}
+		*d.v = v
+	}
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return nil, err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h, h.compact(time.Now())
+}
+
+//ServerHistory makes the server record every update in h and
+//answer history queries from it.
+func ServerHistory(h *History) func(*Server) {
+	return func(s *Server) {
+		s.history = h
+	}
+}
+
+//Add records the value v of key (a gadget's location and name).
+func (h *History) Add(key string, t time.Time, v float64) error {
+	return h.write(record{key: key, t: t.UnixNano(), min: v, max: v, sum: v, count: 1})
+}
+
+func (h *History) write(r record) error {
+	if len(r.key) > math.MaxUint16 {
+		return fmt.Errorf("history key is too long: %s", r.key)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	start := time.Unix(0, r.t).Truncate(h.segment)
+	if h.f == nil || !start.Equal(h.start) {
+		if start.Before(h.start) {
+			//an old point, it goes to its own segment without
+			//making it the current one.
+			return h.appendTo(start, r)
+		}
+		if err := h.rotate(start); err != nil {
+			return err
+		}
+	}
+	if err := writeRecord(h.w, r); err != nil {
+		return err
+	}
+	return h.w.Flush()
+}
+
+//rotate starts writing to the segment that begins at start and
+//cleans up the old ones.
+func (h *History) rotate(start time.Time) error {
+	if err := h.closeFile(); err != nil {
+		return err
+	}
+	if err := h.compact(time.Now()); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.segmentPath(start), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	h.f = f
+	h.w = bufio.NewWriter(f)
+	h.start = start
+	return nil
+}
+
+func (h *History) appendTo(start time.Time, r record) error {
+	f, err := os.OpenFile(h.segmentPath(start), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := writeRecord(w, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//segmentPath is the file of the segment that starts at start.
+//Downsampled segments end in .ds, the others in .raw.
+func (h *History) segmentPath(start time.Time) string {
+	p := filepath.Join(h.dir, fmt.Sprintf("%d.ds", start.Unix()))
+	if _, err := os.Stat(p); err == nil {
+		return p
+	}
+	return filepath.Join(h.dir, fmt.Sprintf("%d.raw", start.Unix()))
+}
+
+type segment struct {
+	path  string
+	start time.Time
+	raw   bool
+}
+
+//segments returns the segment files, oldest first.
+func (h *History) segments() ([]segment, error) {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []segment
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if ext != ".raw" && ext != ".ds" {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segment{
+			path:  filepath.Join(h.dir, f.Name()),
+			start: time.Unix(sec, 0),
+			raw:   ext == ".raw",
+		})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].start.Before(segs[j].start) })
+	return segs, nil
+}
+
+//compact deletes the segments that are older than the retention
+//and downsamples the ones that are older than raw.
+func (h *History) compact(now time.Time) error {
+	h.files.Lock()
+	defer h.files.Unlock()
+	segs, err := h.segments()
+	if err != nil {
+		return err
+	}
+	for _, seg := range segs {
+		end := seg.start.Add(h.segment)
+		if h.f != nil && seg.start.Equal(h.start) {
+			continue
+		}
+		if end.Before(now.Add(-h.retention)) {
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+			continue
+		}
+		if seg.raw && end.Before(now.Add(-h.raw)) {
+			if err := h.downsample(seg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//downsample replaces a raw segment with one that has a record
+//per gadget for every resolution.
+func (h *History) downsample(seg segment) error {
+	recs, err := readSegment(seg.path, nil)
+	if err != nil {
+		return err
+	}
+	res := int64(h.resolution)
+	buckets := map[string]map[int64]*record{}
+	for _, r := range recs {
+		t := r.t - r.t%res
+		b, ok := buckets[r.key]
+		if !ok {
+			b = map[int64]*record{}
+			buckets[r.key] = b
+		}
+		agg, ok := b[t]
+		if !ok {
+			agg = &record{key: r.key, t: t, min: r.min, max: r.max}
+			b[t] = agg
+		}
+		agg.add(r)
+	}
+
+	tmp := strings.TrimSuffix(seg.path, ".raw") + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, b := range buckets {
+		for _, r := range b {
+			if err := writeRecord(w, *r); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, strings.TrimSuffix(seg.path, ".raw")+".ds"); err != nil {
+		return err
+	}
+	return os.Remove(seg.path)
+}
+
+//Query returns the values of key from (inclusive) to (exclusive).
+//With a step the values are aggregated into one point per step,
+//otherwise every stored point is returned.
+func (h *History) Query(key string, from, to time.Time, step time.Duration) ([]Point, error) {
+	if err := h.flush(); err != nil {
+		return nil, err
+	}
+	h.files.RLock()
+	defer h.files.RUnlock()
+	segs, err := h.segments()
+	if err != nil {
+		return nil, err
+	}
+	f, t := from.UnixNano(), to.UnixNano()
+	var recs []record
+	for _, seg := range segs {
+		if !seg.start.Before(to) || !seg.start.Add(h.segment).After(from) {
+			continue
+		}
+		recs, err = readSegment(seg.path, func(r record) bool {
+			return r.key == key && r.t >= f && r.t < t
+		}, recs...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].t < recs[j].t })
+
+	if step > 0 {
+		var agg []record
+		s := int64(step)
+		for _, r := range recs {
+			b := r.t - r.t%s
+			if len(agg) == 0 || agg[len(agg)-1].t != b {
+				agg = append(agg, record{key: key, t: b, min: r.min, max: r.max})
+			}
+			agg[len(agg)-1].add(r)
+		}
+		recs = agg
+	}
+
+	points := make([]Point, len(recs))
+	for i, r := range recs {
+		points[i] = r.point()
+	}
+	return points, nil
+}
+
+func (h *History) flush() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.w == nil {
+		return nil
+	}
+	return h.w.Flush()
+}
+
+//Close stops writing to the current segment.
+func (h *History) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.closeFile()
+}
+
+func (h *History) closeFile() error {
+	if h.f == nil {
+		return nil
+	}
+	err := h.w.Flush()
+	if cerr := h.f.Close(); err == nil {
+		err = cerr
+	}
+	h.f = nil
+	h.w = nil
+	return err
+}
+
+func (r *record) add(o record) {
+	r.min = math.Min(r.min, o.min)
+	r.max = math.Max(r.max, o.max)
+	r.sum += o.sum
+	r.count += o.count
+}
+
+func (r record) point() Point {
+	return Point{
+		Time:  time.Unix(0, r.t).UTC(),
+		Min:   r.min,
+		Max:   r.max,
+		Avg:   r.sum / float64(r.count),
+		Count: int(r.count),
+	}
+}
+
+//writeRecord writes the time, min, max, sum and count of r
+//followed by the length of its key and the key.
+func writeRecord(w io.Writer, r record) error {
+	var buf [38]byte
+	binary.LittleEndian.PutUint64(buf[0:], uint64(r.t))
+	binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(r.min))
+	binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(r.max))
+	binary.LittleEndian.PutUint64(buf[24:], math.Float64bits(r.sum))
+	binary.LittleEndian.PutUint32(buf[32:], r.count)
+	binary.LittleEndian.PutUint16(buf[36:], uint16(len(r.key)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, r.key)
+	return err
+}
+
+//readSegment appends the records of a segment that keep returns
+//true for (all of them if keep is nil) to recs.  A record that
+//was only partly written (the system lost power) ends the segment.
+func readSegment(path string, keep func(record) bool, recs ...record) ([]record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return recs, err
+	}
+	defer f.Close()
+	rd := bufio.NewReader(f)
+	var buf [38]byte
+	for {
+		if _, err := io.ReadFull(rd, buf[:]); err != nil {
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				return recs, nil
+			}
+			return recs, err
+		}
+		key := make([]byte, binary.LittleEndian.Uint16(buf[36:]))
+		if _, err := io.ReadFull(rd, key); err != nil {
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				return recs, nil
+			}
+			return recs, err
+		}
+		r := record{
+			key:   string(key),
+			t:     int64(binary.LittleEndian.Uint64(buf[0:])),
+			min:   math.Float64frombits(binary.LittleEndian.Uint64(buf[8:])),
+			max:   math.Float64frombits(binary.LittleEndian.Uint64(buf[16:])),
+			sum:   math.Float64frombits(binary.LittleEndian.Uint64(buf[24:])),
+			count: binary.LittleEndian.Uint32(buf[32:]),
+		}
+		if keep == nil || keep(r) {
+			recs = append(recs, r)
+		}
+	}
+}
+
+//queueHistory hands an update to recordHistory so that writing
+//it (and compacting the old segments) doesn't hold up the server.
+//The update is dropped when the history is that far behind.
+func (s *Server) queueHistory(msg Message) {
+	select {
+	case s.historyIn <- msg:
+	default:
+		s.lg.Println("history: too far behind, dropped an update from", msg.Sender)
+	}
+}
+
+func (s *Server) recordHistory() {
+	for msg := range s.historyIn {
+		s.addHistory(msg)
+	}
+	close(s.historyDone)
+}
+
+//addHistory adds the value of an update to the history.  Values
+//that aren't numbers or on/off are skipped.
+func (s *Server) addHistory(msg Message) {
+	v, ok := metricValue(msg.Value.Value)
+	if !ok {
+		return
+	}
+	t := msg.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	if err := s.history.Add(fmt.Sprintf("%s %s", msg.Location, msg.Name), t, v); err != nil {
+		s.lg.Println("history:", err)
+	}
+}
+
+//deviceHistory sends the history of a device.  from and to are
+//RFC 3339 times (the last hour by default) and step is a go
+//duration.
+func (s *Server) deviceHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		s.writeError(w, http.StatusNotFound, errors.New("this system doesn't keep a history"))
+		return
+	}
+	vars := rex.Vars(r, "main")
+	q := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	var step time.Duration
+	var err error
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		from = to.Add(-time.Hour)
+	}
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if v := q.Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil || step < 0 {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("bad step: %s", v))
+			return
+		}
+	}
+	points, err := s.history.Query(fmt.Sprintf("%s %s", vars["location"], vars["device"]), from, to, step)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, points)
+}
@@ -0,0 +1,144 @@
+package gogadgets_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("history", func() {
+	var (
+		tmp string
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "")
+		Expect(err).To(BeNil())
+		now = time.Now().Truncate(time.Minute)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmp)
+	})
+
+	It("keeps the values and aggregates them", func() {
+		h, err := gogadgets.NewHistory(gogadgets.HistoryConfig{Path: tmp})
+		Expect(err).To(BeNil())
+		defer h.Close()
+		for i, v := range []float64{1, 5, 3, 10} {
+			Expect(h.Add("hlt temperature", now.Add(time.Duration(i*20)*time.Second), v)).To(BeNil())
+		}
+		Expect(h.Add("hlt volume", now, 7)).To(BeNil())
+
+		points, err := h.Query("hlt temperature", now, now.Add(time.Hour), 0)
+		Expect(err).To(BeNil())
+		Expect(points).To(HaveLen(4))
+		Expect(points[1].Avg).To(Equal(5.0))
+		Expect(points[1].Count).To(Equal(1))
+
+		points, err = h.Query("hlt temperature", now, now.Add(time.Hour), time.Minute)
+		Expect(err).To(BeNil())
+		Expect(points).To(HaveLen(2))
+		Expect(points[0].Time.Equal(now)).To(BeTrue())
+		Expect(points[0].Min).To(Equal(1.0))
+		Expect(points[0].Max).To(Equal(5.0))
+		Expect(points[0].Avg).To(Equal(3.0))
+		Expect(points[0].Count).To(Equal(3))
+		Expect(points[1].Avg).To(Equal(10.0))
+
+		points, err = h.Query("hlt temperature", now.Add(-time.Hour), now, 0)
+		Expect(err).To(BeNil())
+		Expect(points).To(BeEmpty())
+	})
+
+	It("downsamples old values and deletes the ones that are too old", func() {
+		cfg := gogadgets.HistoryConfig{Path: tmp, Retention: "3h", Raw: "1h", Resolution: "1m", Segment: "1h"}
+		h, err := gogadgets.NewHistory(cfg)
+		Expect(err).To(BeNil())
+		old := now.Add(-150 * time.Minute)
+		Expect(h.Add("tank pump", now.Add(-5*time.Hour), 1)).To(BeNil())
+		for i, v := range []float64{1, 0, 1, 1} {
+			Expect(h.Add("tank pump", old.Add(time.Duration(i*10)*time.Second), v)).To(BeNil())
+		}
+		Expect(h.Add("tank pump", now, 0)).To(BeNil())
+		Expect(h.Close()).To(BeNil())
+
+		h, err = gogadgets.NewHistory(cfg)
+		Expect(err).To(BeNil())
+		defer h.Close()
+		ds, err := filepath.Glob(filepath.Join(tmp, "*.ds"))
+		Expect(err).To(BeNil())
+		Expect(ds).To(HaveLen(1))
+
+		points, err := h.Query("tank pump", now.Add(-6*time.Hour), now.Add(time.Minute), 0)
+		Expect(err).To(BeNil())
+		Expect(points).To(HaveLen(2))
+		Expect(points[0].Time.Equal(old)).To(BeTrue())
+		Expect(points[0].Count).To(Equal(4))
+		Expect(points[0].Min).To(Equal(0.0))
+		Expect(points[0].Max).To(Equal(1.0))
+		Expect(points[0].Avg).To(Equal(0.75))
+		Expect(points[1].Count).To(Equal(1))
+	})
+
+	It("records updates and serves them", func() {
+		h, err := gogadgets.NewHistory(gogadgets.HistoryConfig{Path: tmp})
+		Expect(err).To(BeNil())
+		defer h.Close()
+
+		port := 1024 + rand.Intn(65535-1024)
+		s := gogadgets.NewServer("", "", port, &fakeLogger{}, gogadgets.ServerHistory(h))
+		out := make(chan gogadgets.Message)
+		go s.Start(out, make(chan gogadgets.Message))
+		defer func() {
+			out <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+		}()
+		for i, v := range []interface{}{20.0, 22.0, "hot"} {
+			out <- gogadgets.Message{
+				Type:      gogadgets.UPDATE,
+				Sender:    "hlt temperature",
+				Location:  "hlt",
+				Name:      "temperature",
+				Value:     gogadgets.Value{Value: v},
+				Timestamp: now.Add(time.Duration(i) * time.Second),
+			}
+		}
+
+		u := fmt.Sprintf("http://localhost:%d/gadgets/locations/hlt/devices/temperature/history?from=%s&to=%s",
+			port, now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+		//the updates are written after the server has passed them on
+		var points []gogadgets.Point
+		Eventually(func() int {
+			points = nil
+			r, err := http.Get(u + "&step=1h")
+			if err != nil {
+				return 0
+			}
+			defer r.Body.Close()
+			Expect(r.StatusCode).To(Equal(http.StatusOK))
+			Expect(json.NewDecoder(r.Body).Decode(&points)).To(BeNil())
+			if len(points) == 0 {
+				return 0
+			}
+			return points[0].Count
+		}).Should(Equal(2))
+		Expect(points).To(HaveLen(1))
+		Expect(points[0].Avg).To(Equal(21.0))
+
+		r, err := http.Get(u + "&step=soon")
+		Expect(err).To(BeNil())
+		r.Body.Close()
+		Expect(r.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
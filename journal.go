@@ -0,0 +1,300 @@
+package gogadgets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJournalSize = 10 * 1024 * 1024
+	defaultJournalKeep = 5
+)
+
+//Journal writes every message that goes through the broker to a
+//file, one json encoded Message per line.  When the file gets
+//bigger than MaxSize it is renamed to path.1 (path.1 to path.2
+//and so on) and a new one is started.  Only Keep old files are
+//kept.
+type Journal struct {
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+	lock    sync.Mutex
+}
+
+//JournalConfig turns on the journal when Path is set.  The zero
+//values of MaxSize and Keep mean 10 MB and 5 files.
+type JournalConfig struct {
+	Path    string `json:"path,omitempty"`
+	MaxSize int64  `json:"max_size,omitempty"`
+	Keep    int    `json:"keep,omitempty"`
+}
+
+func NewJournal(path string, maxSize int64, keep int) (*Journal, error) {
+	if maxSize <= 0 {
+		maxSize = defaultJournalSize
+	}
+	if keep <= 0 {
+		keep = defaultJournalKeep
+	}
+	j := &Journal{
+		path:    path,
+		maxSize: maxSize,
+		keep:    keep,
+	}
+	return j, j.open()
+}
+
+//BrokerJournal makes the broker write every message it sends to j.
+func BrokerJournal(j *Journal) func(*Broker) {
+	return func(b *Broker) {
+		b.journal = j
+	}
+}
+
+//Write appends msg to the journal.  Messages without a timestamp
+//get the current time so they can be replayed.
+func (j *Journal) Write(msg Message) error {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now().UTC()
+	}
+	d, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	d = append(d, '\n')
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.f == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+	if j.size > 0 && j.size+int64(len(d)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.f.Write(d)
+	j.size += int64(n)
+	return err
+}
+
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	j.f = f
+	j.size = fi.Size()
+	return nil
+}
+
+func (j *Journal) rotate() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	j.f = nil
+	os.Remove(rotated(j.path, j.keep))
+	for i := j.keep - 1; i > 0; i-- {
+		os.Rename(rotated(j.path, i), rotated(j.path, i+1))
+	}
+	if err := os.Rename(j.path, rotated(j.path, 1)); err != nil {
+		return err
+	}
+	return j.open()
+}
+
+func rotated(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+//OpenJournal opens the journal at path along with all of its
+//rotated files, oldest first, so it can be read (or replayed)
+//from start to end.
+func OpenJournal(path string) (io.ReadCloser, error) {
+	var paths []string
+	for i := 1; ; i++ {
+		p := rotated(path, i)
+		if _, err := os.Stat(p); err != nil {
+			break
+		}
+		paths = append([]string{p}, paths...)
+	}
+	paths = append(paths, path)
+
+	jr := &journalReader{}
+	var readers []io.Reader
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			jr.Close()
+			return nil, err
+		}
+		jr.files = append(jr.files, f)
+		readers = append(readers, f)
+	}
+	jr.Reader = io.MultiReader(readers...)
+	return jr, nil
+}
+
+type journalReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (j *journalReader) Close() error {
+	var err error
+	for _, f := range j.files {
+		if e := f.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+//Replayer is a gadget that sends the messages of a journal back
+//into a system, waiting between each one as long as they were
+//apart when they were recorded (divided by Speed, so a Speed of 10
+//replays a brew day in a tenth of the time and 0 sends them as
+//fast as they can be read).  The shutdown command and messages
+//from the senders in Skip aren't replayed.
+type Replayer struct {
+	Speed float64
+	Skip  map[string]bool
+	r     io.Reader
+	err   error
+	done  chan bool
+}
+
+func NewReplayer(r io.Reader, speed float64, skip ...string) *Replayer {
+	rp := &Replayer{
+		Speed: speed,
+		Skip:  map[string]bool{},
+		r:     r,
+		done:  make(chan bool),
+	}
+	for _, s := range skip {
+		rp.Skip[s] = true
+	}
+	return rp
+}
+
+//Replay adds a Replayer to the app that feeds it the journal in r
+//once it starts.  Messages from the app's outputs are skipped
+//because the outputs send them again themselves when the replayed
+//commands and sensor readings drive them.  Messages from the
+//senders in skip are left out too (a live sensor, for example).
+//It has to be called before Start (or Run).
+func (a *App) Replay(r io.Reader, speed float64, skip ...string) *Replayer {
+	for _, g := range a.gadgets {
+		if g.GetDirection() == "output" {
+			skip = append(skip, g.GetUID())
+		}
+	}
+	rp := NewReplayer(r, speed, skip...)
+	a.gadgets = append(a.gadgets, rp)
+	return rp
+}
+
+func (r *Replayer) GetUID() string {
+	return "replayer"
+}
+
+func (r *Replayer) GetDirection() string {
+	return "na"
+}
+
+//Subscriptions are empty, the replayer only listens for
+//shutdown.
+func (r *Replayer) Subscriptions() *Subscriptions {
+	return NewSubscriptions()
+}
+
+//Done is closed when the whole journal has been replayed (or
+//the replay stopped because of an error, see Err).
+func (r *Replayer) Done() <-chan bool {
+	return r.done
+}
+
+//Err is the error that stopped the replay, if there was one.
+func (r *Replayer) Err() error {
+	<-r.done
+	return r.err
+}
+
+func (r *Replayer) Start(in <-chan Message, out chan<- Message) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		r.err = r.replay(ctx, out)
+		close(r.done)
+	}()
+	for msg := range in {
+		if msg.isShutdown() {
+			return
+		}
+	}
+}
+
+func (r *Replayer) replay(ctx context.Context, out chan<- Message) error {
+	dec := json.NewDecoder(r.r)
+	var last time.Time
+	for {
+		var msg Message
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.isShutdown() || r.Skip[msg.Sender] {
+			continue
+		}
+		if err := r.wait(ctx, last, msg.Timestamp); err != nil {
+			return err
+		}
+		last = msg.Timestamp
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *Replayer) wait(ctx context.Context, last, ts time.Time) error {
+	if r.Speed <= 0 || last.IsZero() || !ts.After(last) {
+		return nil
+	}
+	d := time.Duration(float64(ts.Sub(last)) / r.Speed)
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
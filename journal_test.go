@@ -0,0 +1,203 @@
+package gogadgets_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("journal", func() {
+	var (
+		tmp  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "")
+		Expect(err).To(BeNil())
+		path = filepath.Join(tmp, "journal")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmp)
+	})
+
+	read := func(p string) []gogadgets.Message {
+		f, err := os.Open(p)
+		Expect(err).To(BeNil())
+		defer f.Close()
+		var msgs []gogadgets.Message
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var msg gogadgets.Message
+			Expect(json.Unmarshal(s.Bytes(), &msg)).To(BeNil())
+			msgs = append(msgs, msg)
+		}
+		return msgs
+	}
+
+	It("writes every message that goes through the broker", func() {
+		j, err := gogadgets.NewJournal(path, 0, 0)
+		Expect(err).To(BeNil())
+		in := make(chan gogadgets.Message)
+		out := make(chan gogadgets.Message)
+		x := make(chan gogadgets.Message)
+		b := gogadgets.NewBroker(map[string]chan gogadgets.Message{"x": x}, in, out, gogadgets.BrokerJournal(j))
+		go b.Start()
+
+		out <- gogadgets.Message{Sender: "x", Type: gogadgets.UPDATE}
+		out <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "turn on x"}
+		Expect((<-x).Body).To(Equal("turn on x"))
+		in <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+		Expect((<-x).Body).To(Equal("shutdown"))
+		b.Stop()
+		Expect(j.Close()).To(BeNil())
+
+		msgs := read(path)
+		Expect(msgs).To(HaveLen(3))
+		Expect(msgs[0].Sender).To(Equal("x"))
+		Expect(msgs[1].Body).To(Equal("turn on x"))
+		Expect(msgs[2].Body).To(Equal("shutdown"))
+		Expect(msgs[1].Timestamp.IsZero()).To(BeFalse())
+	})
+
+	It("rotates", func() {
+		j, err := gogadgets.NewJournal(path, 200, 2)
+		Expect(err).To(BeNil())
+		for i := 0; i < 10; i++ {
+			Expect(j.Write(gogadgets.Message{Body: fmt.Sprintf("%d", i)})).To(BeNil())
+		}
+		Expect(j.Close()).To(BeNil())
+
+		Expect(path + ".2").To(BeAnExistingFile())
+		Expect(path + ".3").ToNot(BeAnExistingFile())
+
+		r, err := gogadgets.OpenJournal(path)
+		Expect(err).To(BeNil())
+		defer r.Close()
+		dec := json.NewDecoder(r)
+		var bodies []string
+		for dec.More() {
+			var msg gogadgets.Message
+			Expect(dec.Decode(&msg)).To(BeNil())
+			bodies = append(bodies, msg.Body)
+		}
+		Expect(len(bodies)).To(BeNumerically("<", 10))
+		Expect(bodies[len(bodies)-1]).To(Equal("9"))
+		for i := 1; i < len(bodies); i++ {
+			Expect(bodies[i] > bodies[i-1]).To(BeTrue())
+		}
+	})
+
+	It("replays a journal into an app", func() {
+		ts := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		for i, msg := range []gogadgets.Message{
+			{Type: gogadgets.COMMAND, Body: "turn on tank pump"},
+			{Type: gogadgets.COMMAND, Sender: "tank pump", Body: "turn on tank valve"},
+			{Type: gogadgets.COMMAND, Body: "shutdown"},
+			{Type: gogadgets.COMMAND, Body: "turn off tank pump"},
+		} {
+			msg.Timestamp = ts.Add(time.Duration(i) * time.Second)
+			Expect(enc.Encode(&msg)).To(BeNil())
+		}
+
+		pump := &FakeOutput{}
+		valve := &FakeOutput{}
+		cfg := &gogadgets.Config{
+			Port:   1024 + rand.Intn(65535-1024),
+			Logger: &fakeLogger{},
+		}
+		a := gogadgets.NewApp(cfg,
+			&gogadgets.Gadget{
+				Location:    "tank",
+				Name:        "pump",
+				OnCommands:  []string{"turn on tank pump"},
+				OffCommands: []string{"turn off tank pump"},
+				Output:      pump,
+				UID:         "tank pump",
+			},
+			&gogadgets.Gadget{
+				Location:    "tank",
+				Name:        "valve",
+				OnCommands:  []string{"turn on tank valve"},
+				OffCommands: []string{"turn off tank valve"},
+				Output:      valve,
+				UID:         "tank valve",
+			},
+		)
+		rp := a.Replay(buf, 10)
+		start := time.Now()
+		go a.Start()
+		defer a.Stop()
+
+		Eventually(func() bool { return pump.on }).Should(BeTrue())
+		Eventually(rp.Done()).Should(BeClosed())
+		Expect(rp.Err()).To(BeNil())
+		Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+		Eventually(func() bool { return pump.on }).Should(BeFalse())
+		Expect(valve.on).To(BeFalse())
+	})
+
+	It("replays recorded sensor readings into a thermostat", func() {
+		gogadgets.Sim.Reset()
+		ts := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		for i, msg := range []gogadgets.Message{
+			{Type: gogadgets.COMMAND, Body: "heat home to 70 F"},
+			sensorUpdate("home", "temperature", 65.0, "F"),
+			sensorUpdate("home", "temperature", 72.0, "F"),
+		} {
+			msg.Timestamp = ts.Add(time.Duration(i) * time.Second)
+			Expect(enc.Encode(&msg)).To(BeNil())
+		}
+
+		thermometer, err := gogadgets.NewGadget(&gogadgets.GadgetConfig{
+			Location: "home",
+			Name:     "temperature",
+			Pin:      gogadgets.Pin{Type: "thermometer", Platform: "sim", OneWireId: "28-0000041cb544", Units: "F", Sleep: time.Hour},
+		})
+		Expect(err).To(BeNil())
+		furnace, err := gogadgets.NewGadget(&gogadgets.GadgetConfig{
+			Location: "home",
+			Name:     "furnace",
+			Pin: gogadgets.Pin{
+				Type:     "thermostat",
+				Platform: "sim",
+				Pins: map[string]gogadgets.Pin{
+					"heat": {Port: "8", Pin: "11", Direction: "out"},
+					"cool": {Port: "8", Pin: "12", Direction: "out"},
+				},
+				Args: map[string]interface{}{"sensor": "home temperature", "timeout": "0s"},
+			},
+		})
+		Expect(err).To(BeNil())
+		cfg := &gogadgets.Config{
+			Port:   1024 + rand.Intn(65535-1024),
+			Logger: &fakeLogger{},
+		}
+		a := gogadgets.NewApp(cfg, thermometer, furnace)
+		rp := a.Replay(buf, 10)
+		go a.Start()
+		defer a.Stop()
+
+		Eventually(func() bool { return gogadgets.Sim.Pin("8", "11") }).Should(BeTrue())
+		Eventually(rp.Done()).Should(BeClosed())
+		Expect(rp.Err()).To(BeNil())
+		Eventually(func() bool { return gogadgets.Sim.Pin("8", "11") }).Should(BeFalse())
+		Expect(gogadgets.Sim.Pin("8", "12")).To(BeFalse())
+	})
+})
@@ -4,19 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
-)
 
-var (
-	timeExp = regexp.MustCompile(`for (\d*\.?\d*) (seconds?|minutes?|hours?)`)
-	stepExp = regexp.MustCompile(`for (.+) (>=|>|==|<=|<) ([\w\.]+) ?(.+)?`)
+	"github.com/cswank/gogadgets/rcl"
 )
 
 type stepChecker func(msg *Message) bool
-type comparitor func(value interface{}) bool
+type comparitor func(value *Value) bool
 
 //  Gadgets respond to the Robot Command Language (RCL) and a
 //  list of RCL messages can be run to form a method.  Runner
@@ -41,6 +36,7 @@ type MethodRunner struct {
 	uid         string
 	out         chan<- Message
 	timeOut     chan bool
+	stop        chan bool
 }
 
 func (m *MethodRunner) GetUID() string {
@@ -56,6 +52,8 @@ func (m *MethodRunner) Start(in <-chan Message, out chan<- Message) {
 	m.out = out
 	shutdown := false
 	m.timeOut = make(chan bool)
+	m.stop = make(chan bool)
+	defer close(m.stop)
 	for !shutdown {
 		select {
 		case msg := <-in:
@@ -69,6 +67,11 @@ func (m *MethodRunner) Start(in <-chan Message, out chan<- Message) {
 
 func (m *MethodRunner) readMessage(msg *Message) (shutdown bool) {
 	if msg.Type == METHOD {
+		if err := m.checkMethod(msg.Method); err != nil {
+			m.reply(msg, err)
+			return false
+		}
+		m.reply(msg, nil)
 		m.method = msg.Method
 		m.step = -1
 		m.runNextStep()
@@ -77,6 +80,7 @@ func (m *MethodRunner) readMessage(msg *Message) (shutdown bool) {
 		m.sendUpdate()
 	} else if msg.Type == COMMAND && msg.Body == "clear method" {
 		m.clear()
+		m.reply(msg, nil)
 		m.sendUpdate()
 	} else if len(m.method.Steps) != 0 && (msg.Type == UPDATE || msg.Type == METHODUPDATE) {
 		m.checkUpdate(msg)
@@ -89,6 +93,38 @@ func (m *MethodRunner) readMessage(msg *Message) (shutdown bool) {
 	return shutdown
 }
 
+//checkMethod makes sure every step of a method is valid RCL
+//before any of it is run.
+func (m *MethodRunner) checkMethod(method Method) error {
+	for i, step := range method.Steps {
+		if _, err := rcl.Parse(step); err != nil {
+			return fmt.Errorf("step %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+//reply answers a method (or clear method) with DONE, or ERROR if it
+//can't be run, so the api can wait for it.  Like the gadgets, it
+//only sends DONE when msg has a UUID to reply to.
+func (m *MethodRunner) reply(msg *Message, err error) {
+	reply := Message{
+		UUID:    GetUUID(),
+		ReplyTo: msg.UUID,
+		Sender:  m.GetUID(),
+		Target:  msg.Sender,
+		Type:    DONE,
+		Body:    msg.Body,
+	}
+	if err != nil {
+		reply.Type = ERROR
+		reply.Body = err.Error()
+	} else if msg.UUID == "" {
+		return
+	}
+	m.out <- reply
+}
+
 func (m *MethodRunner) sendUpdate() {
 	m.method.Step = m.step
 	msg := Message{
@@ -124,8 +160,8 @@ func (m *MethodRunner) runNextStep() {
 		return
 	}
 	cmd := m.method.Steps[m.step]
-	if strings.Index(cmd, "wait") == 0 {
-		m.readWaitCommand(cmd)
+	if c, err := rcl.Parse(cmd); err == nil && strings.HasPrefix(c.Action, "wait") {
+		m.readWaitCommand(cmd, c)
 	} else {
 		m.sendCommand(cmd)
 		m.runNextStep()
@@ -141,14 +177,14 @@ func (m *MethodRunner) sendCommand(cmd string) {
 	m.out <- msg
 }
 
-func (m *MethodRunner) readWaitCommand(cmd string) {
-	waitTime, err := m.getWaitTime(cmd)
-	if strings.Index(cmd, "wait for user") == 0 {
+func (m *MethodRunner) readWaitCommand(cmd string, c *rcl.Command) {
+	if c.Action == "wait for user" {
 		m.setUserStepChecker(cmd)
-	} else if err == nil {
+	} else if c.Duration != nil {
+		waitTime, _ := Quantity{Value: c.Duration.Value, Units: c.Duration.Units}.Duration()
 		go m.doCountdown(waitTime)
 	} else {
-		m.setStepChecker(cmd)
+		m.setStepChecker(c.Condition)
 	}
 }
 
@@ -158,89 +194,60 @@ func (m *MethodRunner) setUserStepChecker(cmd string) {
 	}
 }
 
-func (m *MethodRunner) setStepChecker(cmd string) {
-	uid, operator, value, err := m.parseWaitCommand(cmd)
-	if err == nil {
-		compare, err := m.getCompare(operator, value)
-		if err == nil {
-			m.stepChecker = func(msg *Message) bool {
-				return msg.Sender == uid &&
-					compare(msg.Value.Value)
-			}
-		} else {
-			log.Println(err)
-			m.stepChecker = func(msg *Message) bool {
-				return false
-			}
-		}
-	} else {
+func (m *MethodRunner) setStepChecker(cond *rcl.Condition) {
+	compare, err := getCompare(cond.Operator, cond.Value, cond.Units)
+	if err != nil {
 		log.Println(err)
+		m.stepChecker = func(msg *Message) bool {
+			return false
+		}
+		return
+	}
+	m.stepChecker = func(msg *Message) bool {
+		return msg.Sender == cond.Sensor &&
+			compare(&msg.Value)
 	}
 }
 
-func (m *MethodRunner) getCompare(operator string, value interface{}) (cmp comparitor, err error) {
+//getCompare is shared by the wait commands of methods and the
+//until commands of gadgets.
+func getCompare(operator string, value interface{}, unit string) (cmp comparitor, err error) {
 	switch v := value.(type) {
 	case float64:
-		return m.getFloatCompare(operator, v)
+		return getFloatCompare(operator, v, strings.TrimSpace(unit))
 	default:
-		return func(x interface{}) bool { return x == v }, nil
+		if operator == "!=" {
+			return func(x *Value) bool { return x.Value != v }, nil
+		}
+		return func(x *Value) bool { return x.Value == v }, nil
 	}
 }
 
-func (m *MethodRunner) getFloatCompare(operator string, value float64) (cmp comparitor, err error) {
+//getFloatCompare compares the values of updates (converted to
+//unit) to value, so 'wait for boiler temperature >= 200 F' works
+//with a thermometer that reports C.
+func getFloatCompare(operator string, value float64, unit string) (cmp comparitor, err error) {
+	var f func(x float64) bool
 	if operator == "<=" {
-		cmp = func(x interface{}) bool { return x.(float64) <= value }
+		f = func(x float64) bool { return x <= value }
 	} else if operator == "<" {
-		cmp = func(x interface{}) bool { return x.(float64) < value }
+		f = func(x float64) bool { return x < value }
 	} else if operator == "==" {
-		cmp = func(x interface{}) bool { return x.(float64) == value }
+		f = func(x float64) bool { return x == value }
+	} else if operator == "!=" {
+		f = func(x float64) bool { return x != value }
 	} else if operator == ">=" {
-		cmp = func(x interface{}) bool { return x.(float64) >= value }
+		f = func(x float64) bool { return x >= value }
 	} else if operator == ">" {
-		cmp = func(x interface{}) bool { return x.(float64) > value }
+		f = func(x float64) bool { return x > value }
 	} else {
-		err = errors.New(fmt.Sprintf("invalid operator: %s", operator))
-	}
-	return cmp, err
-}
-
-func (m *MethodRunner) parseWaitCommand(cmd string) (uid, operator string, value interface{}, err error) {
-	result := stepExp.FindStringSubmatch(cmd)
-	if len(result) == 5 {
-		uid = result[1]
-		operator = result[2]
-		v := result[3]
-		if v == "true" {
-			value = true
-		} else if v == "false" {
-			value = false
-		} else {
-			value, err = strconv.ParseFloat(v, 64)
-		}
+		return cmp, errors.New(fmt.Sprintf("invalid operator: %s", operator))
 	}
-	return uid, operator, value, err
-}
-
-func (m *MethodRunner) getWaitTime(cmd string) (waitTime time.Duration, err error) {
-	result := timeExp.FindStringSubmatch(cmd)
-	if len(result) != 3 {
-		err = errors.New(fmt.Sprintf("could not parse command %s", cmd))
-		return waitTime, err
+	cmp = func(x *Value) bool {
+		v, ok := x.In(unit)
+		return ok && f(v)
 	}
-	units := result[2]
-	t, err := strconv.ParseFloat(result[1], 64)
-	if err != nil {
-		err = errors.New(fmt.Sprintf("could not parse command %s", cmd))
-		return waitTime, err
-	} else {
-		if units == "minutes" || units == "minute" {
-			t *= 60.0
-		} else if units == "hours" || units == "hour" {
-			t *= 3600.0
-		}
-		waitTime = time.Duration(t * float64(time.Second))
-	}
-	return waitTime, err
+	return cmp, err
 }
 
 func (m *MethodRunner) doCountdown(waitTime time.Duration) {
@@ -257,7 +264,11 @@ func (m *MethodRunner) doCountdown(waitTime time.Duration) {
 		},
 	}
 	for {
-		time.Sleep(sleepTime)
+		select {
+		case <-time.After(sleepTime):
+		case <-m.stop:
+			return
+		}
 		i += 1.0
 		t2 := time.Now()
 		d := t2.Sub(t1)
@@ -272,7 +283,10 @@ func (m *MethodRunner) doCountdown(waitTime time.Duration) {
 			},
 		}
 		if d > waitTime {
-			m.timeOut <- true
+			select {
+			case m.timeOut <- true:
+			case <-m.stop:
+			}
 			return
 		}
 	}
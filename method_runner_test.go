@@ -117,5 +117,95 @@ var _ = Describe("method runner", func() {
 			Expect(msg.Type).To(Equal("command"))
 			Expect(msg.Body).To(Equal("shutdown"))
 		})
+		It("converts units in a wait command", func() {
+			go m.Start(out, in)
+			out <- gogadgets.Message{
+				Type: gogadgets.METHOD,
+				Method: gogadgets.Method{
+					Steps: []string{
+						"wait for boiler temperature >= 200 F",
+						"shutdown",
+					},
+				},
+			}
+			<-in
+			out <- gogadgets.Message{
+				Sender: "boiler temperature",
+				Type:   "update",
+				Value: gogadgets.Value{
+					Value: 93.0,
+					Units: "C",
+				},
+			}
+
+			var x bool
+			select {
+			case <-in:
+				x = false
+			case <-time.After(100 * time.Millisecond):
+				x = true
+			}
+			Expect(x).To(BeTrue())
+
+			out <- gogadgets.Message{
+				Sender: "boiler temperature",
+				Type:   "update",
+				Value: gogadgets.Value{
+					Value: 93.5,
+					Units: "C",
+				},
+			}
+			msg := <-in
+			Expect(msg.Type).To(Equal("method update"))
+			Expect(msg.Method.Step).To(Equal(1))
+		})
+		It("answers a method and clearing it", func() {
+			go m.Start(out, in)
+			out <- gogadgets.Message{
+				UUID:   "abc",
+				Type:   gogadgets.METHOD,
+				Sender: "http api",
+				Method: gogadgets.Method{Steps: []string{"wait for user to start"}},
+			}
+			msg := <-in
+			Expect(msg.Type).To(Equal(gogadgets.DONE))
+			Expect(msg.ReplyTo).To(Equal("abc"))
+			Expect(msg.Target).To(Equal("http api"))
+			msg = <-in
+			Expect(msg.Type).To(Equal(gogadgets.METHODUPDATE))
+
+			out <- gogadgets.Message{UUID: "def", Type: gogadgets.COMMAND, Body: "clear method"}
+			msg = <-in
+			Expect(msg.Type).To(Equal(gogadgets.DONE))
+			Expect(msg.ReplyTo).To(Equal("def"))
+		})
+
+		It("doesn't run a method with a bad step", func() {
+			go m.Start(out, in)
+			out <- gogadgets.Message{
+				UUID:   "abc",
+				Type:   gogadgets.METHOD,
+				Sender: "http api",
+				Method: gogadgets.Method{
+					Steps: []string{
+						"turn on lab led",
+						"wait for boiler temperature >= hot",
+						"shutdown",
+					},
+				},
+			}
+			msg := <-in
+			Expect(msg.Type).To(Equal(gogadgets.ERROR))
+			Expect(msg.Target).To(Equal("http api"))
+			Expect(msg.ReplyTo).To(Equal("abc"))
+			Expect(msg.Body).To(HavePrefix("step 2: expected a number, true or false, got 'hot'"))
+
+			out <- gogadgets.Message{
+				Type: gogadgets.COMMAND,
+				Body: "update",
+			}
+			msg = <-in
+			Expect(msg.Method.Steps).To(BeEmpty())
+		})
 	})
 })
@@ -0,0 +1,192 @@
+package gogadgets
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//metrics sends the state of the system in the Prometheus text
+//format: the latest value of every input and output, the method
+//that is running, the broker queues and counters of the messages
+//the server has seen.
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	var m metricWriter
+
+	s.statusLock.Lock()
+	updates := make([]Message, 0, len(s.updates))
+	for _, msg := range s.updates {
+		updates = append(updates, msg)
+	}
+	s.statusLock.Unlock()
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Sender < updates[j].Sender })
+
+	m.header("gogadgets_value", "gauge", "The latest value of an input.")
+	for _, msg := range updates {
+		if msg.Type != UPDATE || msg.Info.Direction == "output" {
+			continue
+		}
+		if v, ok := metricValue(msg.Value.Value); ok {
+			m.sample("gogadgets_value", v, "location", msg.Location, "name", msg.Name, "units", msg.Value.Units)
+		}
+	}
+
+	m.header("gogadgets_output_on", "gauge", "1 if an output is on.")
+	for _, msg := range updates {
+		if msg.Type != UPDATE || msg.Info.Direction != "output" {
+			continue
+		}
+		if v, ok := metricValue(msg.Value.Value); ok {
+			m.sample("gogadgets_output_on", v, "location", msg.Location, "name", msg.Name)
+		}
+	}
+
+	m.header("gogadgets_output_status", "gauge", "The state of each pin of an output (Output.Status).")
+	for _, msg := range updates {
+		if msg.Type != UPDATE {
+			continue
+		}
+		for _, k := range sortedKeys(msg.Value.Output) {
+			v, _ := metricValue(msg.Value.Output[k])
+			m.sample("gogadgets_output_status", v, "location", msg.Location, "name", msg.Name, "output", k)
+		}
+	}
+
+	for _, g := range []struct {
+		name, help string
+		value      func(Method) float64
+	}{
+		{"gogadgets_method_step", "The step the method runner is on.", func(m Method) float64 { return float64(m.Step) }},
+		{"gogadgets_method_steps", "How many steps the running method has (0 when none is running).", func(m Method) float64 { return float64(len(m.Steps)) }},
+		{"gogadgets_method_wait_seconds", "How long the wait the method is on has left.", func(m Method) float64 { return float64(m.Time) }},
+	} {
+		m.header(g.name, "gauge", g.help)
+		for _, msg := range updates {
+			if msg.Type == METHODUPDATE {
+				m.sample(g.name, g.value(msg.Method), "sender", msg.Sender)
+			}
+		}
+	}
+
+	if s.broker != nil {
+		st := s.broker.Stats()
+		uids := make([]string, 0, len(st.Gadgets))
+		for uid := range st.Gadgets {
+			uids = append(uids, uid)
+		}
+		sort.Strings(uids)
+		m.header("gogadgets_broker_queue", "gauge", "Messages waiting to be handed out by the broker.")
+		m.sample("gogadgets_broker_queue", float64(st.Queue))
+		for _, g := range []struct {
+			name, typ, help string
+			value           func(MailboxStats) float64
+		}{
+			{"gogadgets_broker_depth", "gauge", "Messages waiting for a gadget.", func(s MailboxStats) float64 { return float64(s.Depth) }},
+			{"gogadgets_broker_delivered_total", "counter", "Messages delivered to a gadget.", func(s MailboxStats) float64 { return float64(s.Delivered) }},
+			{"gogadgets_broker_dropped_total", "counter", "Messages dropped because a gadget's mailbox was full.", func(s MailboxStats) float64 { return float64(s.Dropped) }},
+			{"gogadgets_broker_coalesced_total", "counter", "Updates replaced by a newer one before a gadget read them.", func(s MailboxStats) float64 { return float64(s.Coalesced) }},
+			{"gogadgets_broker_latency_seconds", "gauge", "How long messages wait for a gadget.", func(s MailboxStats) float64 { return s.Latency }},
+			{"gogadgets_broker_max_latency_seconds", "gauge", "The longest a message has waited for a gadget.", func(s MailboxStats) float64 { return s.MaxLatency }},
+		} {
+			m.header(g.name, g.typ, g.help)
+			for _, uid := range uids {
+				m.sample(g.name, g.value(st.Gadgets[uid]), "gadget", uid)
+			}
+		}
+	}
+
+	s.metricsLock.Lock()
+	m.header("gogadgets_messages_total", "counter", "Messages seen by the server, by type.")
+	for _, k := range sortedKeys(s.messages) {
+		m.sample("gogadgets_messages_total", float64(s.messages[k]), "type", k)
+	}
+	m.header("gogadgets_push_failures_total", "counter", "Messages that couldn't be sent to a master or client.")
+	for _, k := range sortedKeys(s.pushFailures) {
+		m.sample("gogadgets_push_failures_total", float64(s.pushFailures[k]), "host", k)
+	}
+	s.metricsLock.Unlock()
+
+	m.header("gogadgets_uptime_seconds", "gauge", "How long the system has been running.")
+	m.sample("gogadgets_uptime_seconds", time.Since(s.started).Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(m.Bytes())
+}
+
+//countMessage adds msg to the message counters.
+func (s *Server) countMessage(msg Message) {
+	s.metricsLock.Lock()
+	s.messages[msg.Type]++
+	s.metricsLock.Unlock()
+}
+
+//pushFailed counts a message that couldn't be sent to host.
+func (s *Server) pushFailed(host string) {
+	s.metricsLock.Lock()
+	s.pushFailures[host]++
+	s.metricsLock.Unlock()
+}
+
+type metricWriter struct {
+	bytes.Buffer
+}
+
+func (m *metricWriter) header(name, typ, help string) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+//sample writes one value, labels are pairs of names and values.
+func (m *metricWriter) sample(name string, v float64, labels ...string) {
+	m.WriteString(name)
+	if len(labels) > 0 {
+		l := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			l = append(l, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+		}
+		fmt.Fprintf(m, "{%s}", strings.Join(l, ","))
+	}
+	fmt.Fprintf(m, " %s\n", strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//metricValue turns the value of a gadget into a number, on and
+//off are 1 and 0.
+func metricValue(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch x := m.(type) {
+	case map[string]bool:
+		for k := range x {
+			keys = append(keys, k)
+		}
+	case map[string]int:
+		for k := range x {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,6 +1,7 @@
 package gogadgets
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
@@ -28,11 +29,12 @@ type GoGadgeter interface {
 }
 
 type Value struct {
-	Value  interface{}     `json:"value,omitempty"`
-	Units  string          `json:"units,omitempty"`
-	Output map[string]bool `json:"io,omitempty"`
-	ID     string          `json:"id,omitempty"`
-	Cmd    string          `json:"command,omitempty"`
+	Value  interface{}            `json:"value,omitempty"`
+	Units  string                 `json:"units,omitempty"`
+	Output map[string]bool        `json:"io,omitempty"`
+	ID     string                 `json:"id,omitempty"`
+	Cmd    string                 `json:"command,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
 }
 
 func (v *Value) ToFloat() (f float64, ok bool) {
@@ -64,9 +66,11 @@ type Method struct {
 }
 
 //Message is what all Gadgets pass around to each
-//other.
+//other.  A DONE or ERROR message that answers a command
+//has the UUID of that command in ReplyTo.
 type Message struct {
 	UUID        string    `json:"uuid"`
+	ReplyTo     string    `json:"reply_to,omitempty"`
 	From        string    `json:"from,omitempty"`
 	Name        string    `json:"name,omitempty"`
 	Location    string    `json:"location,omitempty"`
@@ -83,6 +87,18 @@ type Message struct {
 	Config      Config    `json:"config,omitempty"`
 }
 
+//isShutdown is true for the command that stops a gadgets system.
+func (m *Message) isShutdown() bool {
+	return m.Type == COMMAND && m.Body == "shutdown"
+}
+
+//isDroppable is true for the messages a full mailbox can drop
+//(a newer update will come along), commands and replies have to
+//get through.
+func (m *Message) isDroppable() bool {
+	return m.Type == UPDATE || m.Type == METHODUPDATE
+}
+
 type Pin struct {
 	Type        string                 `json:"type,omitempty"`
 	Port        string                 `json:"port,omitempty"`
@@ -96,12 +112,25 @@ type Pin struct {
 	Value       interface{}            `json:"value,omitempty"`
 	Units       string                 `json:"units,omitempty"`
 	Platform    string                 `json:"platform,omitempty"`
+	Backend     string                 `json:"backend,omitempty"`
+	Chip        string                 `json:"chip,omitempty"`
+	Bias        string                 `json:"bias,omitempty"`
+	Drive       string                 `json:"drive,omitempty"`
 	Frequency   int                    `json:"frequency,omitempty"`
 	Args        map[string]interface{} `json:"args,omitempty"`
 	Pins        map[string]Pin         `json:"pins,omitempty"`
 	Lock        sync.Mutex             `json:"-"`
 }
 
+//subPin gives a sub-pin (of a motor or a thermostat) the platform
+//of its pin, so a config with "platform": "sim" covers them too.
+func (pin *Pin) subPin(p *Pin) *Pin {
+	if pin.Platform != "" {
+		p.Platform = pin.Platform
+	}
+	return p
+}
+
 type GadgetConfig struct {
 	Type         string                 `json:"type,omitempty"`
 	Location     string                 `json:"location,omitempty"`
@@ -114,11 +143,53 @@ type GadgetConfig struct {
 }
 
 type Config struct {
-	Master  string         `json:"master,omitempty"`
-	Host    string         `json:"host,omitempty"`
-	Port    int            `json:"port,omitempty"`
-	Gadgets []GadgetConfig `json:"gadgets,omitempty"`
-	Logger  Logger         `json:"-"`
+	Master   string         `json:"master,omitempty"`
+	Host     string         `json:"host,omitempty"`
+	Port     int            `json:"port,omitempty"`
+	Platform string         `json:"platform,omitempty"`
+	Gadgets  []GadgetConfig `json:"gadgets,omitempty"`
+	Broker   BrokerConfig   `json:"broker,omitempty"`
+	Journal  JournalConfig  `json:"journal,omitempty"`
+	History  HistoryConfig  `json:"history,omitempty"`
+	Auth     AuthConfig     `json:"auth,omitempty"`
+	TLS      TLSConfig      `json:"tls,omitempty"`
+	Logger   Logger         `json:"-"`
+}
+
+//BrokerConfig sets how many messages can wait for each gadget
+//and what happens when there are more (drop oldest, drop newest
+//or coalesce).  Sizes and Policies override the size and policy
+//of single gadgets (by uid).
+type BrokerConfig struct {
+	Size     int               `json:"size,omitempty"`
+	Policy   string            `json:"policy,omitempty"`
+	Sizes    map[string]int    `json:"sizes,omitempty"`
+	Policies map[string]string `json:"policies,omitempty"`
+}
+
+func (b BrokerConfig) options() ([]func(*Broker), error) {
+	if err := checkPolicy(b.Policy); err != nil {
+		return nil, err
+	}
+	opts := []func(*Broker){BrokerSize(b.Size), BrokerPolicy(b.Policy)}
+	for uid, size := range b.Sizes {
+		opts = append(opts, BrokerSize(size, uid))
+	}
+	for uid, p := range b.Policies {
+		if err := checkPolicy(p); err != nil {
+			return nil, fmt.Errorf("%s: %s", uid, err)
+		}
+		opts = append(opts, BrokerPolicy(p, uid))
+	}
+	return opts, nil
+}
+
+func checkPolicy(p string) error {
+	switch p {
+	case "", DropOldest, DropNewest, Coalesce:
+		return nil
+	}
+	return fmt.Errorf("invalid broker policy: %s", p)
 }
 
 type ConfigHelper struct {
@@ -13,17 +13,17 @@ type Motor struct {
 
 func NewMotor(pin *Pin) (OutputDevice, error) {
 	p := pin.Pins["gpio_a"]
-	gpioA, err := NewGPIO(&p)
+	gpioA, err := NewGPIO(pin.subPin(&p))
 	if err != nil {
 		return nil, err
 	}
 	p = pin.Pins["gpio_b"]
-	gpioB, err := NewGPIO(&p)
+	gpioB, err := NewGPIO(pin.subPin(&p))
 	if err != nil {
 		return nil, err
 	}
 	p = pin.Pins["pwm"]
-	pwm, err := NewPWM(&p)
+	pwm, err := NewPWM(pin.subPin(&p))
 	if err != nil {
 		return nil, err
 	}
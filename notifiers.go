@@ -0,0 +1,223 @@
+package gogadgets
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Notifier sends alerts somewhere people will see them.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+//NotifierConfig is one of the notifiers of an alerts gadget.  Type
+//picks the notifier and the other fields are used by the notifiers
+//that need them:
+//
+//	{"type": "smtp", "addr": "smtp.example.com:587", "from": "gadgets@example.com", "to": ["me@example.com"], "username": "gadgets", "password": "...", "timeout": "30s"}
+//	{"type": "webhook", "url": "https://example.com/hooks/gadgets", "token": "Bearer ..."}
+//	{"type": "command", "command": ["/usr/local/bin/page-me", "--urgent"]}
+//
+//The smtp notifier gives up on a mail server that takes longer
+//than its timeout (a minute by default).  The webhook posts the
+//Alert as json (token is sent as the Authorization header).  The
+//command gets the alert on its stdin and in the GADGETS_ALERT_RULE,
+//_STATE, _SENSOR, _VALUE and _UNITS environment variables.
+type NotifierConfig struct {
+	Type     string   `json:"type"`
+	Addr     string   `json:"addr,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Token    string   `json:"token,omitempty"`
+	Command  []string `json:"command,omitempty"`
+	Timeout  string   `json:"timeout,omitempty"`
+}
+
+var (
+	notifiersLock sync.Mutex
+	notifiers     = map[string]func(NotifierConfig) (Notifier, error){
+		"smtp":    newSMTPNotifier,
+		"webhook": newWebhookNotifier,
+		"command": newCommandNotifier,
+	}
+)
+
+//RegisterNotifier adds a type of notifier that alerts can use.
+func RegisterNotifier(name string, f func(NotifierConfig) (Notifier, error)) {
+	notifiersLock.Lock()
+	notifiers[name] = f
+	notifiersLock.Unlock()
+}
+
+//NewNotifier makes the notifier that cfg describes.
+func NewNotifier(cfg NotifierConfig) (Notifier, error) {
+	notifiersLock.Lock()
+	f, ok := notifiers[cfg.Type]
+	notifiersLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown notifier: %s", cfg.Type)
+	}
+	return f(cfg)
+}
+
+//smtpNotifier sends an email.  It uses STARTTLS when the server
+//offers it, and plain auth when there's a username.  The whole
+//conversation with the server has to fit in the timeout (so a
+//stalled server can't hold up shutting down the alerts).
+type smtpNotifier struct {
+	addr    string
+	host    string
+	from    string
+	to      []string
+	auth    smtp.Auth
+	timeout time.Duration
+}
+
+func newSMTPNotifier(cfg NotifierConfig) (Notifier, error) {
+	if cfg.Addr == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("the smtp notifier needs an addr, from and to")
+	}
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	n := &smtpNotifier{addr: cfg.Addr, host: host, from: cfg.From, to: cfg.To, timeout: time.Minute}
+	if cfg.Timeout != "" {
+		if n.timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("could not parse the timeout of the smtp notifier: %s", err)
+		}
+		if n.timeout <= 0 {
+			return nil, fmt.Errorf("the timeout of the smtp notifier has to be longer than 0, got %s", n.timeout)
+		}
+	}
+	if cfg.Username != "" {
+		n.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return n, nil
+}
+
+func (n *smtpNotifier) Notify(a Alert) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", n.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&buf, "Subject: [gogadgets] %s\r\n", a.Subject())
+	fmt.Fprintf(&buf, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", a)
+	return n.send(buf.Bytes())
+}
+
+//send does what smtp.SendMail does, on a connection with a deadline.
+func (n *smtpNotifier) send(msg []byte) error {
+	conn, err := net.DialTimeout("tcp", n.addr, n.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(n.timeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+	if n.auth != nil {
+		if err := c.Auth(n.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+//webhookNotifier posts the alert as json.
+type webhookNotifier struct {
+	postSink
+	url string
+}
+
+func newWebhookNotifier(cfg NotifierConfig) (Notifier, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("the webhook notifier needs a url")
+	}
+	return &webhookNotifier{
+		postSink: postSink{client: &http.Client{Timeout: 10 * time.Second}, token: cfg.Token},
+		url:      cfg.URL,
+	}, nil
+}
+
+func (n *webhookNotifier) Notify(a Alert) error {
+	d, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return n.post(n.url, "application/json", d)
+}
+
+//commandNotifier runs a command for every alert, it is killed if
+//it takes longer than a minute.
+type commandNotifier struct {
+	command []string
+}
+
+func newCommandNotifier(cfg NotifierConfig) (Notifier, error) {
+	if len(cfg.Command) == 0 {
+		return nil, fmt.Errorf("the command notifier needs a command")
+	}
+	return &commandNotifier{command: cfg.Command}, nil
+}
+
+func (n *commandNotifier) Notify(a Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, n.command[0], n.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GADGETS_ALERT_RULE="+a.Rule,
+		"GADGETS_ALERT_STATE="+a.State,
+		"GADGETS_ALERT_SENSOR="+a.Sensor,
+		fmt.Sprintf("GADGETS_ALERT_VALUE=%v", a.Value.Value),
+		"GADGETS_ALERT_UNITS="+a.Value.Units,
+	)
+	cmd.Stdin = strings.NewReader(a.String() + "\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s %s", n.command[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package gogadgets
+
+import "net/http"
+
+//openAPI serves the OpenAPI spec of the http api.  It doesn't
+//need a token so tools can find out how to get one.
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
+
+//openAPISpec has to be kept up to date with Server.router.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "gogadgets",
+    "description": "Control and watch the gadgets of a gogadgets system.  Commands are Robot Command Language (RCL) strings like 'turn on lab led for 5 minutes'.",
+    "version": "` + Version + `"
+  },
+  "security": [{"bearer": []}, {"token": []}],
+  "paths": {
+    "/gadgets": {
+      "get": {
+        "summary": "The latest update of every gadget, by uid",
+        "responses": {
+          "200": {"description": "updates", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/Message"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Send a message (usually a command) to the gadgets",
+        "parameters": [{"$ref": "#/components/parameters/wait"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/sent"},
+          "400": {"$ref": "#/components/responses/badWait"},
+          "401": {"$ref": "#/components/responses/unauthorized"},
+          "403": {"$ref": "#/components/responses/forbidden"},
+          "422": {"$ref": "#/components/responses/failed"},
+          "503": {"$ref": "#/components/responses/unavailable"},
+          "504": {"$ref": "#/components/responses/timeout"}
+        }
+      },
+      "put": {
+        "summary": "Same as POST",
+        "parameters": [{"$ref": "#/components/parameters/wait"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/sent"},
+          "400": {"$ref": "#/components/responses/badWait"},
+          "401": {"$ref": "#/components/responses/unauthorized"},
+          "403": {"$ref": "#/components/responses/forbidden"},
+          "422": {"$ref": "#/components/responses/failed"},
+          "503": {"$ref": "#/components/responses/unavailable"},
+          "504": {"$ref": "#/components/responses/timeout"}
+        }
+      }
+    },
+    "/gadgets/values": {
+      "get": {
+        "summary": "The latest value of every gadget, by location and name",
+        "responses": {
+          "200": {"description": "values", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/Value"}}}}}}
+        }
+      }
+    },
+    "/gadgets/locations": {
+      "get": {
+        "summary": "Every location and its devices, with the commands they take",
+        "responses": {
+          "200": {"description": "locations", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/DeviceInfo"}}}}}}
+        }
+      }
+    },
+    "/gadgets/locations/{location}/devices/{device}/status": {
+      "parameters": [{"$ref": "#/components/parameters/location"}, {"$ref": "#/components/parameters/device"}],
+      "get": {
+        "summary": "The value of a device",
+        "responses": {
+          "200": {"description": "value", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Value"}}}},
+          "404": {"description": "no such device"}
+        }
+      }
+    },
+    "/gadgets/locations/{location}/devices/{device}/history": {
+      "parameters": [{"$ref": "#/components/parameters/location"}, {"$ref": "#/components/parameters/device"}],
+      "get": {
+        "summary": "The history of a device (when the system keeps one)",
+        "parameters": [
+          {"name": "from", "in": "query", "description": "RFC 3339 time, an hour before to by default", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "RFC 3339 time, now by default", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "step", "in": "query", "description": "go duration (like 5m) to aggregate the points by, every stored point is sent without it", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "points", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Point"}}}}},
+          "400": {"description": "a bad from, to or step", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"description": "the system doesn't keep a history"}
+        }
+      }
+    },
+    "/gadgets/locations/{location}/devices/{device}/on": {
+      "parameters": [{"$ref": "#/components/parameters/location"}, {"$ref": "#/components/parameters/device"}, {"$ref": "#/components/parameters/wait"}],
+      "post": {
+        "summary": "Turn a device on",
+        "requestBody": {"required": false, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeviceCommand"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/sent"},
+          "400": {"$ref": "#/components/responses/badCommand"},
+          "401": {"$ref": "#/components/responses/unauthorized"},
+          "403": {"$ref": "#/components/responses/forbidden"},
+          "404": {"$ref": "#/components/responses/notFound"},
+          "422": {"$ref": "#/components/responses/failed"},
+          "504": {"$ref": "#/components/responses/timeout"}
+        }
+      }
+    },
+    "/gadgets/locations/{location}/devices/{device}/off": {
+      "parameters": [{"$ref": "#/components/parameters/location"}, {"$ref": "#/components/parameters/device"}, {"$ref": "#/components/parameters/wait"}],
+      "post": {
+        "summary": "Turn a device off",
+        "responses": {
+          "200": {"$ref": "#/components/responses/sent"},
+          "400": {"$ref": "#/components/responses/badCommand"},
+          "401": {"$ref": "#/components/responses/unauthorized"},
+          "403": {"$ref": "#/components/responses/forbidden"},
+          "404": {"$ref": "#/components/responses/notFound"},
+          "422": {"$ref": "#/components/responses/failed"},
+          "504": {"$ref": "#/components/responses/timeout"}
+        }
+      }
+    },
+    "/gadgets/types": {
+      "get": {
+        "summary": "The types of devices that can be configured and their settings",
+        "responses": {
+          "200": {"description": "types", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/ConfigHelper"}}}}}
+        }
+      }
+    },
+    "/gadgets/broker": {
+      "get": {
+        "summary": "Queue depth, drops and delivery latency of every gadget",
+        "responses": {
+          "200": {"description": "stats", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BrokerStats"}}}},
+          "404": {"description": "the system isn't running"}
+        }
+      }
+    },
+    "/gadgets/stream": {
+      "get": {
+        "summary": "Server-Sent Events of updates, method updates and errors",
+        "parameters": [{"$ref": "#/components/parameters/filterLocation"}, {"$ref": "#/components/parameters/filterName"}, {"$ref": "#/components/parameters/filterType"}],
+        "responses": {
+          "200": {"description": "events named after the message type with a Message as the data", "content": {"text/event-stream": {"schema": {"type": "string"}}}}
+        }
+      }
+    },
+    "/gadgets/ws": {
+      "get": {
+        "summary": "WebSocket that streams messages (like /gadgets/stream) and takes commands",
+        "parameters": [{"$ref": "#/components/parameters/filterLocation"}, {"$ref": "#/components/parameters/filterName"}, {"$ref": "#/components/parameters/filterType"}],
+        "responses": {
+          "101": {"description": "switching to the websocket protocol"},
+          "400": {"description": "not a websocket handshake"}
+        }
+      }
+    },
+    "/gadgets/openapi.json": {
+      "get": {
+        "summary": "This spec",
+        "security": [],
+        "responses": {"200": {"description": "the spec"}}
+      }
+    },
+    "/clients": {
+      "get": {
+        "summary": "The clients of a master (their addresses and tokens)",
+        "responses": {"200": {"description": "clients", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string"}}}}}}
+      },
+      "post": {
+        "summary": "Register a client with the master",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {"address": {"type": "string"}, "token": {"type": "string"}}}}}},
+        "responses": {"200": {"description": "registered"}, "400": {"description": "missing address or token"}}
+      },
+      "delete": {
+        "summary": "Unregister a client",
+        "responses": {"200": {"description": "unregistered"}}
+      }
+    },
+    "/metrics": {
+      "get": {
+        "summary": "Values, outputs, the running method, broker queues and message counters in the Prometheus text format",
+        "responses": {"200": {"description": "metrics", "content": {"text/plain": {"schema": {"type": "string"}}}}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer", "description": "an api token from the auth config"},
+      "token": {"type": "apiKey", "in": "query", "name": "token", "description": "the token for clients that can't set headers"},
+      "signature": {"type": "apiKey", "in": "header", "name": "X-Gadgets-Signature", "description": "HMAC-SHA256 of the method, path, X-Gadgets-Timestamp and body, used between nodes"}
+    },
+    "parameters": {
+      "wait": {"name": "wait", "in": "query", "description": "how long to wait for the answer to the command (a Go duration like 2s)", "schema": {"type": "string"}},
+      "location": {"name": "location", "in": "path", "required": true, "schema": {"type": "string"}},
+      "device": {"name": "device", "in": "path", "required": true, "schema": {"type": "string"}},
+      "filterLocation": {"name": "location", "in": "query", "description": "only messages from this location (can be repeated)", "schema": {"type": "string"}},
+      "filterName": {"name": "name", "in": "query", "description": "only messages from devices with this name, or that match this pattern (can be repeated)", "schema": {"type": "string"}},
+      "filterType": {"name": "type", "in": "query", "description": "only messages of this type (can be repeated, the default is update, method update and error)", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "sent": {"description": "the message that was sent, or with ?wait the done message that answers it", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+      "badWait": {"description": "bad wait duration"},
+      "badCommand": {"description": "the arguments don't make a valid command", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "unauthorized": {"description": "no (or a bad) token"},
+      "forbidden": {"description": "the token is read only"},
+      "notFound": {"description": "no such device", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "failed": {"description": "the error message that answers the command", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+      "unavailable": {"description": "the system is shutting down"},
+      "timeout": {"description": "nothing answered in time"}
+    },
+    "schemas": {
+      "Error": {"type": "object", "properties": {"error": {"type": "string"}}},
+      "Value": {
+        "type": "object",
+        "properties": {
+          "value": {},
+          "units": {"type": "string"},
+          "io": {"type": "object", "additionalProperties": {"type": "boolean"}},
+          "id": {"type": "string"},
+          "command": {"type": "string"},
+          "data": {"type": "object"}
+        }
+      },
+      "Message": {
+        "type": "object",
+        "properties": {
+          "uuid": {"type": "string"},
+          "reply_to": {"type": "string"},
+          "from": {"type": "string"},
+          "name": {"type": "string"},
+          "location": {"type": "string"},
+          "type": {"type": "string", "enum": ["command", "error", "method", "done", "update", "method update"]},
+          "sender": {"type": "string"},
+          "target": {"type": "string"},
+          "body": {"type": "string", "description": "an RCL command"},
+          "host": {"type": "string"},
+          "method": {"type": "object", "properties": {"step": {"type": "integer"}, "steps": {"type": "array", "items": {"type": "string"}}, "time": {"type": "integer"}}},
+          "timestamp": {"type": "string", "format": "date-time"},
+          "value": {"$ref": "#/components/schemas/Value"},
+          "target_value": {"$ref": "#/components/schemas/Value"},
+          "info": {"type": "object", "properties": {"direction": {"type": "string"}, "on": {"type": "array", "items": {"type": "string"}}, "off": {"type": "array", "items": {"type": "string"}}}}
+        }
+      },
+      "Point": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "min": {"type": "number"},
+          "avg": {"type": "number"},
+          "max": {"type": "number"},
+          "count": {"type": "integer"}
+        }
+      },
+      "DeviceInfo": {
+        "type": "object",
+        "properties": {
+          "direction": {"type": "string"},
+          "on": {"type": "array", "items": {"type": "string"}},
+          "off": {"type": "array", "items": {"type": "string"}},
+          "value": {"$ref": "#/components/schemas/Value"}
+        }
+      },
+      "DeviceCommand": {
+        "type": "object",
+        "properties": {
+          "command": {"type": "string", "description": "which on command to use if the device has more than one"},
+          "value": {"type": "number", "description": "turn on to this value"},
+          "units": {"type": "string"},
+          "duration": {"type": "string", "description": "a Go duration longer than 0 like 30m"},
+          "condition": {"type": "string", "description": "an RCL condition like 'tank volume >= 5 liters'"}
+        }
+      },
+      "ConfigHelper": {
+        "type": "object",
+        "properties": {
+          "fields": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}},
+          "units": {"type": "array", "items": {"type": "string"}},
+          "args": {"type": "object"},
+          "pins": {"type": "object"},
+          "pinType": {"type": "string"}
+        }
+      },
+      "BrokerStats": {
+        "type": "object",
+        "properties": {
+          "queue": {"type": "integer"},
+          "gadgets": {
+            "type": "object",
+            "additionalProperties": {
+              "type": "object",
+              "properties": {
+                "depth": {"type": "integer"},
+                "delivered": {"type": "integer"},
+                "dropped": {"type": "integer"},
+                "coalesced": {"type": "integer"},
+                "latency": {"type": "number"},
+                "max_latency": {"type": "number"}
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,435 @@
+package gogadgets
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+PID holds a temperature (or anything else a sensor reports) at a
+setpoint with a proportional-integral-derivative controller.
+Configure it like:
+
+	{
+	    "location": "hlt",
+	    "name": "heater",
+	    "pin": {
+	        "type": "pid",
+	        "port": "8",
+	        "pin": "11",
+	        "args": {
+	            "sensor": "hlt temperature",
+	            "kp": 8.0,
+	            "ki": 0.05,
+	            "kd": 30.0,
+	            "min": 0.0,
+	            "max": 100.0,
+	            "filter": 0.3,
+	            "output": "gpio",
+	            "window": "4s"
+	        }
+	    }
+	}
+
+and then send it 'heat hlt to 152 F'.  The controller output is
+a percent (between min and max).  With "output": "gpio" the pin is
+turned on for that percent of every window (time proportioning),
+with "output": "pwm" it is the duty cycle of a pwm pin.
+
+The derivative is taken on the measurement (so changing the
+setpoint doesn't kick the output) and smoothed by filter (1.0 means
+no smoothing).  The integral stops growing while the output is
+saturated so it doesn't overshoot once the sensor catches up.
+Set "action": "cool" for something that cools.  Like Boiler, the
+sensor must be in the same location as the pid.
+
+To find the gains send it 'autotune hlt heater at 150 F'.  The
+output is switched between min and max around the setpoint until
+the sensor has oscillated autotune_cycles times (default 4,
+autotune_hysteresis defaults to 0.5 degrees).  The suggested gains
+are then used to hold the setpoint and are reported in the data
+of the gadget's updates (and so by the HTTP API) so they can be
+copied into the config.
+*/
+type PID struct {
+	sensor   string
+	action   string
+	usePWM   bool
+	window   time.Duration
+	dev      OutputDevice
+	ctrl     *pidController
+	lock     sync.Mutex
+	status   bool
+	setpoint *float64
+	units    string
+	output   float64
+	stop     chan bool
+	tuner    *relayTuner
+	tuneArgs map[string]interface{}
+}
+
+func NewPID(pin *Pin) (OutputDevice, error) {
+	sensor, ok := pin.Args["sensor"].(string)
+	if !ok {
+		return nil, errors.New("a pid needs a sensor")
+	}
+
+	action := "heat"
+	if a, ok := pin.Args["action"].(string); ok {
+		action = a
+	}
+	if action != "heat" && action != "cool" {
+		return nil, fmt.Errorf("invalid pid action: %s", action)
+	}
+
+	ctrl := &pidController{
+		kp:     getFloatArg(pin.Args, "kp", 1.0),
+		ki:     getFloatArg(pin.Args, "ki", 0.0),
+		kd:     getFloatArg(pin.Args, "kd", 0.0),
+		min:    getFloatArg(pin.Args, "min", 0.0),
+		max:    getFloatArg(pin.Args, "max", 100.0),
+		filter: getFloatArg(pin.Args, "filter", 1.0),
+	}
+	if action == "cool" {
+		ctrl.reverse = true
+	}
+	if ctrl.min >= ctrl.max {
+		return nil, fmt.Errorf("invalid pid output limits: %f %f", ctrl.min, ctrl.max)
+	}
+	if ctrl.filter <= 0.0 || ctrl.filter > 1.0 {
+		return nil, fmt.Errorf("invalid pid derivative filter: %f", ctrl.filter)
+	}
+	window, err := getDurationArg(pin.Args, "window", 4*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("the pid window has to be longer than 0, got %s", window)
+	}
+
+	p := &PID{
+		sensor:   sensor,
+		action:   action,
+		usePWM:   pin.Args["output"] == "pwm",
+		window:   window,
+		ctrl:     ctrl,
+		tuneArgs: pin.Args,
+	}
+
+	if p.usePWM {
+		if pin.Frequency == 0 {
+			pin.Frequency = 1
+		}
+		p.dev, err = NewPWM(pin)
+	} else {
+		p.dev, err = NewGPIO(pin)
+	}
+	return p, err
+}
+
+func (p *PID) Commands(location, name string) *Commands {
+	return &Commands{
+		On: []string{
+			fmt.Sprintf("%s %s", p.action, location),
+			fmt.Sprintf("autotune %s %s", location, name),
+		},
+		Off: []string{
+			fmt.Sprintf("turn off %s %s", location, name),
+		},
+	}
+}
+
+func (p *PID) Config() ConfigHelper {
+	return ConfigHelper{
+		PinType: "gpio",
+		Units:   []string{"C", "F"},
+		Pins:    Pins["gpio"],
+		Args: map[string]interface{}{
+			"sensor": []string{},
+			"action": []string{"heat", "cool"},
+			"kp":     []string{},
+			"ki":     []string{},
+			"kd":     []string{},
+			"min":    []string{},
+			"max":    []string{},
+			"filter": []string{},
+			"output": []string{"gpio", "pwm"},
+			"window": []string{},
+
+			"autotune_cycles":     []string{},
+			"autotune_hysteresis": []string{},
+		},
+	}
+}
+
+func (p *PID) Update(msg *Message) bool {
+	if msg.Sender != p.sensor {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, ok := msg.Value.In(p.units)
+	if !ok {
+		return false
+	}
+	ts := msg.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	if !p.status || p.setpoint == nil {
+		return false
+	}
+	if p.tuner != nil && !p.tuner.done {
+		p.output = p.tuner.update(v, ts)
+		if p.tuner.done {
+			p.ctrl.kp = p.tuner.kp
+			p.ctrl.ki = p.tuner.ki
+			p.ctrl.kd = p.tuner.kd
+			p.ctrl.reset()
+			p.output = p.ctrl.update(*p.setpoint, v, ts)
+		}
+	} else {
+		p.output = p.ctrl.update(*p.setpoint, v, ts)
+	}
+	if p.usePWM {
+		p.dev.On(&Value{Value: p.output, Units: "%"})
+	}
+	return true
+}
+
+func (p *PID) On(val *Value) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if val != nil {
+		if sp, ok := val.Value.(float64); ok {
+			p.setpoint = &sp
+			p.units = val.Units
+		}
+	}
+	if p.setpoint == nil {
+		return errors.New("a pid needs a setpoint")
+	}
+	if val != nil && strings.HasPrefix(val.Cmd, "autotune") {
+		p.startTuning()
+	} else if p.tuner != nil && !p.tuner.done {
+		p.tuner = nil
+	}
+	if p.status {
+		return nil
+	}
+	p.status = true
+	p.output = 0.0
+	p.ctrl.reset()
+	if !p.usePWM {
+		p.stop = make(chan bool)
+		go p.proportion(p.stop)
+	}
+	return nil
+}
+
+func (p *PID) Off() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.status = false
+	p.output = 0.0
+	if p.tuner != nil && !p.tuner.done {
+		p.tuner = nil
+	}
+	return p.dev.Off()
+}
+
+func (p *PID) startTuning() {
+	p.tuner = newRelayTuner(
+		*p.setpoint,
+		getFloatArg(p.tuneArgs, "autotune_hysteresis", 0.5),
+		p.ctrl.min,
+		p.ctrl.max,
+		int(getFloatArg(p.tuneArgs, "autotune_cycles", 4.0)),
+	)
+	if p.ctrl.reverse {
+		p.tuner.low, p.tuner.high = p.ctrl.max, p.ctrl.min
+	}
+}
+
+//Report is sent along with the gadget's updates.  It has the
+//current gains and output and, once autotune has been run,
+//what it found.
+func (p *PID) Report() map[string]interface{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	r := map[string]interface{}{
+		"output": p.output,
+		"kp":     p.ctrl.kp,
+		"ki":     p.ctrl.ki,
+		"kd":     p.ctrl.kd,
+	}
+	if p.setpoint != nil {
+		r["setpoint"] = *p.setpoint
+	}
+	if p.tuner != nil {
+		r["autotune"] = p.tuner.report()
+	}
+	return r
+}
+
+func (p *PID) Status() map[string]bool {
+	return p.dev.Status()
+}
+
+//Output returns the current controller output in percent.
+func (p *PID) Output() float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.output
+}
+
+//proportion turns the gpio on for output percent of every window.
+func (p *PID) proportion(stop <-chan bool) {
+	for {
+		on := p.onTime()
+		p.setGPIO(stop, on > 0)
+		if !sleepUnless(on, stop) {
+			return
+		}
+		p.setGPIO(stop, on >= p.window)
+		if !sleepUnless(p.window-on, stop) {
+			return
+		}
+	}
+}
+
+func (p *PID) onTime() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return time.Duration(p.output / 100.0 * float64(p.window))
+}
+
+func (p *PID) setGPIO(stop <-chan bool, on bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-stop:
+		//turned off while we were asleep
+		return
+	default:
+	}
+	if on {
+		p.dev.On(nil)
+	} else {
+		p.dev.Off()
+	}
+}
+
+//sleepUnless sleeps for d and returns false if stop was closed
+//in the meantime.
+func sleepUnless(d time.Duration, stop <-chan bool) bool {
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+type pidController struct {
+	kp         float64
+	ki         float64
+	kd         float64
+	min        float64
+	max        float64
+	filter     float64
+	reverse    bool
+	integral   float64
+	derivative float64
+	lastInput  *float64
+	lastTime   time.Time
+	output     float64
+}
+
+func (c *pidController) reset() {
+	c.integral = 0.0
+	c.derivative = 0.0
+	c.lastInput = nil
+	c.output = 0.0
+}
+
+func (c *pidController) update(setpoint, input float64, t time.Time) float64 {
+	e := setpoint - input
+	if c.reverse {
+		e = -e
+	}
+
+	var dt float64
+	if c.lastInput != nil {
+		dt = t.Sub(c.lastTime).Seconds()
+		if dt <= 0.0 {
+			return c.output
+		}
+		d := (input - *c.lastInput) / dt
+		if !c.reverse {
+			d = -d
+		}
+		c.derivative += c.filter * (d - c.derivative)
+	}
+	c.lastInput = &input
+	c.lastTime = t
+
+	p := c.kp * e
+	dterm := c.kd * c.derivative
+	i := c.integral + c.ki*e*dt
+
+	//Conditional integration: don't let the integral keep growing
+	//in the direction that is already saturating the output.
+	out := p + i + dterm
+	if !(out > c.max && e > 0) && !(out < c.min && e < 0) {
+		c.integral = clamp(i, c.min, c.max)
+	}
+
+	c.output = clamp(p+c.integral+dterm, c.min, c.max)
+	return c.output
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func getFloatArg(args map[string]interface{}, key string, def float64) float64 {
+	v, ok := args[key].(float64)
+	if !ok {
+		return def
+	}
+	return v
+}
+
+func getDurationArg(args map[string]interface{}, key string, def time.Duration) (time.Duration, error) {
+	v, ok := args[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid pid %s: %v", key, v)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid %s: %s", key, s)
+	}
+	return d, nil
+}
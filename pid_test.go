@@ -0,0 +1,187 @@
+package gogadgets_test
+
+import (
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("pid", func() {
+	var (
+		start time.Time
+	)
+
+	newPID := func(args map[string]interface{}) *gogadgets.PID {
+		args["sensor"] = "hlt temperature"
+		args["output"] = "pwm"
+		p, err := gogadgets.NewPID(&gogadgets.Pin{
+			Platform: "sim",
+			Port:     "8",
+			Pin:      "13",
+			Args:     args,
+		})
+		Expect(err).To(BeNil())
+		return p.(*gogadgets.PID)
+	}
+
+	temperature := func(p *gogadgets.PID, v float64, secs int) bool {
+		return p.Update(&gogadgets.Message{
+			Sender:    "hlt temperature",
+			Timestamp: start.Add(time.Duration(secs) * time.Second),
+			Value:     gogadgets.Value{Value: v, Units: "F"},
+		})
+	}
+
+	BeforeEach(func() {
+		gogadgets.Sim.Reset()
+		start = time.Now()
+	})
+
+	It("sets a pwm in proportion to the error", func() {
+		p := newPID(map[string]interface{}{"kp": 10.0})
+		Expect(p.On(&gogadgets.Value{Value: 152.0, Units: "F"})).To(BeNil())
+		Expect(temperature(p, 150.0, 0)).To(BeTrue())
+		Expect(p.Output()).To(Equal(20.0))
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(20.0))
+
+		Expect(temperature(p, 140.0, 1)).To(BeTrue())
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(100.0))
+
+		Expect(temperature(p, 160.0, 2)).To(BeTrue())
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(0.0))
+
+		Expect(p.Off()).To(BeNil())
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(0.0))
+	})
+
+	It("ignores other sensors and waits for a setpoint", func() {
+		p := newPID(map[string]interface{}{"kp": 10.0})
+		Expect(p.On(nil)).ToNot(BeNil())
+		Expect(p.On(&gogadgets.Value{Value: 152.0, Units: "F"})).To(BeNil())
+		Expect(p.Update(&gogadgets.Message{
+			Sender: "mash temperature",
+			Value:  gogadgets.Value{Value: 100.0, Units: "F"},
+		})).To(BeFalse())
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(0.0))
+	})
+
+	It("doesn't wind up the integral while saturated", func() {
+		p := newPID(map[string]interface{}{"kp": 0.0, "ki": 1.0})
+		Expect(p.On(&gogadgets.Value{Value: 152.0, Units: "F"})).To(BeNil())
+		temperature(p, 142.0, 0)
+		for i := 1; i <= 100; i++ {
+			temperature(p, 142.0, i*10)
+		}
+		Expect(p.Output()).To(Equal(100.0))
+		temperature(p, 157.0, 1001)
+		Expect(p.Output()).To(Equal(95.0))
+	})
+
+	It("takes a filtered derivative of the measurement", func() {
+		p := newPID(map[string]interface{}{"kp": 0.0, "kd": 10.0, "filter": 0.5, "min": -100.0})
+		Expect(p.On(&gogadgets.Value{Value: 152.0, Units: "F"})).To(BeNil())
+		temperature(p, 140.0, 0)
+		temperature(p, 142.0, 1)
+		Expect(p.Output()).To(Equal(-10.0))
+		temperature(p, 144.0, 2)
+		Expect(p.Output()).To(Equal(-15.0))
+	})
+
+	It("reverses for cooling", func() {
+		p := newPID(map[string]interface{}{"kp": 10.0, "action": "cool"})
+		Expect(p.Commands("fermenter", "chiller").On).To(ContainElement("cool fermenter"))
+		Expect(p.On(&gogadgets.Value{Value: 65.0, Units: "F"})).To(BeNil())
+		temperature(p, 68.0, 0)
+		Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(30.0))
+	})
+
+	It("needs a sensor", func() {
+		_, err := gogadgets.NewPID(&gogadgets.Pin{Platform: "sim", Args: map[string]interface{}{}})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("needs a window longer than 0", func() {
+		for _, w := range []interface{}{"0s", "-4s", "4 seconds", 4.0} {
+			_, err := gogadgets.NewPID(&gogadgets.Pin{
+				Platform: "sim",
+				Port:     "8",
+				Pin:      "11",
+				Args:     map[string]interface{}{"sensor": "hlt temperature", "window": w},
+			})
+			Expect(err).ToNot(BeNil())
+		}
+	})
+
+	It("time proportions a gpio", func() {
+		g, err := gogadgets.NewPID(&gogadgets.Pin{
+			Platform: "sim",
+			Port:     "8",
+			Pin:      "11",
+			Args: map[string]interface{}{
+				"sensor": "hlt temperature",
+				"kp":     25.0,
+				"window": "100ms",
+			},
+		})
+		Expect(err).To(BeNil())
+		p := g.(*gogadgets.PID)
+		Expect(p.On(&gogadgets.Value{Value: 152.0, Units: "F"})).To(BeNil())
+		temperature(p, 150.0, 0)
+		Expect(p.Output()).To(Equal(50.0))
+		Eventually(func() bool {
+			return gogadgets.Sim.Pin("8", "11")
+		}).Should(BeTrue())
+		Eventually(func() bool {
+			return gogadgets.Sim.Pin("8", "11")
+		}).Should(BeFalse())
+		Eventually(func() bool {
+			return gogadgets.Sim.Pin("8", "11")
+		}).Should(BeTrue())
+		Expect(p.Off()).To(BeNil())
+		Consistently(func() bool {
+			return gogadgets.Sim.Pin("8", "11")
+		}, 250*time.Millisecond).Should(BeFalse())
+	})
+
+	It("heats to a setpoint from a command", func() {
+		g, err := gogadgets.NewGadget(&gogadgets.GadgetConfig{
+			Location: "hlt",
+			Name:     "heater",
+			Pin: gogadgets.Pin{
+				Type:     "pid",
+				Platform: "sim",
+				Port:     "8",
+				Pin:      "13",
+				Args: map[string]interface{}{
+					"sensor": "hlt temperature",
+					"output": "pwm",
+					"kp":     10.0,
+				},
+			},
+		})
+		Expect(err).To(BeNil())
+		input := make(chan gogadgets.Message)
+		output := make(chan gogadgets.Message)
+		go g.Start(input, output)
+		<-output
+		input <- gogadgets.Message{
+			Type: gogadgets.COMMAND,
+			Body: "heat hlt to 152 F",
+		}
+		msg := <-output
+		Expect(msg.Value.Value).To(BeTrue())
+		input <- gogadgets.Message{
+			Type:     gogadgets.UPDATE,
+			Sender:   "hlt temperature",
+			Location: "hlt",
+			Name:     "temperature",
+			Value:    gogadgets.Value{Value: 148.0, Units: "F"},
+		}
+		<-output
+		Eventually(func() float64 {
+			return gogadgets.Sim.PWM("8", "13")
+		}).Should(Equal(40.0))
+	})
+})
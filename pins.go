@@ -37,6 +37,30 @@ var (
 			},
 		},
 	}
+	//The pwmchip and channel behind each of the pins in Pins["pwm"]
+	//for kernels that use /sys/class/pwm.  The BeagleBone chips are
+	//found by device name because their pwmchip numbers change
+	//between kernel versions.  The pins still need to be muxed for
+	//pwm (config-pin P8_13 pwm).
+	PWMChannels = map[string]map[string]PWMChannel{
+		"8": map[string]PWMChannel{
+			"13": PWMChannel{Chip: "48304200.pwm", Channel: 1},
+			"19": PWMChannel{Chip: "48304200.pwm", Channel: 0},
+		},
+		"9": map[string]PWMChannel{
+			"14": PWMChannel{Chip: "48302200.pwm", Channel: 0},
+			"16": PWMChannel{Chip: "48302200.pwm", Channel: 1},
+			"21": PWMChannel{Chip: "48300200.pwm", Channel: 1},
+			"22": PWMChannel{Chip: "48300200.pwm", Channel: 0},
+		},
+	}
+	//The Raspberry Pi pwm pins (with dtoverlay=pwm-2chan).
+	PiPWMChannels = map[string]PWMChannel{
+		"12": PWMChannel{Chip: "pwmchip0", Channel: 0},
+		"32": PWMChannel{Chip: "pwmchip0", Channel: 0},
+		"33": PWMChannel{Chip: "pwmchip0", Channel: 1},
+		"35": PWMChannel{Chip: "pwmchip0", Channel: 1},
+	}
 	PiPins = map[string]string{
 		"11": "17",
 		"13": "27",
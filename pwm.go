@@ -33,6 +33,12 @@ type PWM struct {
 }
 
 func NewPWM(pin *Pin) (OutputDevice, error) {
+	if pin.Platform == "sim" {
+		return Sim.PWMDevice(pin), nil
+	}
+	if pin.Backend == "pwmchip" {
+		return NewPWMChip(pin)
+	}
 	// err := writePWMDeviceTree(pin.Port, pin.Pin)
 	// if err != nil {
 	// 	return nil, err
@@ -88,13 +94,20 @@ func (p *PWM) Status() map[string]bool {
 }
 
 func (p *PWM) getDuty(val interface{}) []byte {
+	return []byte(fmt.Sprintf("%d", pwmDuty(val, p.period)))
+}
+
+//pwmDuty converts a % value into nanoseconds of the period.
+func pwmDuty(val interface{}, period int) int {
 	d, ok := val.(float64)
 	if !ok {
-		return []byte("0")
+		return 0
 	}
 	d = math.Abs(d)
-	f := (d / 100.0) * float64(p.period)
-	return []byte(fmt.Sprintf("%d", int(f)))
+	if d > 100.0 {
+		d = 100.0
+	}
+	return int((d / 100.0) * float64(period))
 }
 
 func setupPWM(pin *Pin) (devPath string, period int, err error) {
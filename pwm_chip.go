@@ -0,0 +1,194 @@
+package gogadgets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cswank/gogadgets/utils"
+)
+
+var (
+	PWM_CHIP_PATH = "/sys/class/pwm"
+)
+
+//PWMChannel is a single output of a pwmchip.  Chip is either the
+//name of the chip (pwmchip0) or the name of the device behind it
+//(48304200.pwm).
+type PWMChannel struct {
+	Chip    string
+	Channel int
+}
+
+//PWMChip drives a pin through the generic /sys/class/pwm
+//interface that current BeagleBone and Raspberry Pi kernels use.
+//It is used when a pwm Pin has "backend": "pwmchip".  The chip and
+//channel come from PWMChannels (or PiPWMChannels), or set pin.Chip
+//to address a chip directly, in which case pin.Pin is the channel.
+//Set active_low to "1" for inversed polarity.
+type PWMChip struct {
+	period     int
+	status     bool
+	dutyPath   string
+	enablePath string
+}
+
+func NewPWMChip(pin *Pin) (*PWMChip, error) {
+	if pin.Frequency <= 0 {
+		return nil, fmt.Errorf("invalid pwm frequency: %d", pin.Frequency)
+	}
+	ch, err := pwmChannel(pin)
+	if err != nil {
+		return nil, err
+	}
+	chipPath, err := findPWMChip(ch.Chip)
+	if err != nil {
+		return nil, err
+	}
+	devPath := path.Join(chipPath, fmt.Sprintf("pwm%d", ch.Channel))
+	if err := exportPWM(chipPath, devPath, ch.Channel); err != nil {
+		return nil, err
+	}
+
+	p := &PWMChip{
+		period:     int(NANO / float64(pin.Frequency)),
+		dutyPath:   path.Join(devPath, "duty_cycle"),
+		enablePath: path.Join(devPath, "enable"),
+	}
+
+	//the duty cycle can never be longer than the period and
+	//polarity can only be changed while the pwm is disabled.
+	if err := p.write(p.enablePath, "0"); err != nil {
+		return nil, err
+	}
+	if err := p.write(p.dutyPath, "0"); err != nil {
+		return nil, err
+	}
+	if err := p.write(path.Join(devPath, "period"), strconv.Itoa(p.period)); err != nil {
+		return nil, err
+	}
+	polarity := "normal"
+	if pin.ActiveLow == "1" {
+		polarity = "inversed"
+	}
+	if err := p.write(path.Join(devPath, "polarity"), polarity); err != nil && polarity == "inversed" {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PWMChip) Commands(location, name string) *Commands {
+	return nil
+}
+
+func (p *PWMChip) Config() ConfigHelper {
+	return ConfigHelper{
+		PinType: "pwm",
+		Fields: map[string][]string{
+			"frequency": []string{},
+			"backend":   []string{"pwmchip"},
+		},
+		Pins: Pins["pwm"],
+	}
+}
+
+func (p *PWMChip) Update(msg *Message) bool {
+	return false
+}
+
+func (p *PWMChip) On(val *Value) error {
+	duty := p.period
+	if val != nil && val.Units == "%" {
+		duty = pwmDuty(val.Value, p.period)
+	}
+	if err := p.write(p.dutyPath, strconv.Itoa(duty)); err != nil {
+		return err
+	}
+	p.status = true
+	return p.write(p.enablePath, "1")
+}
+
+func (p *PWMChip) Off() error {
+	p.status = false
+	if err := p.write(p.dutyPath, "0"); err != nil {
+		return err
+	}
+	return p.write(p.enablePath, "0")
+}
+
+func (p *PWMChip) Status() map[string]bool {
+	return map[string]bool{"pwm": p.status}
+}
+
+func (p *PWMChip) write(pth, val string) error {
+	return ioutil.WriteFile(pth, []byte(val), PWMMode)
+}
+
+func pwmChannel(pin *Pin) (PWMChannel, error) {
+	if pin.Chip != "" {
+		c, err := strconv.Atoi(pin.Pin)
+		if err != nil {
+			return PWMChannel{}, fmt.Errorf("invalid pwm channel: %s", pin.Pin)
+		}
+		return PWMChannel{Chip: pin.Chip, Channel: c}, nil
+	}
+	if pin.Platform == "rpi" {
+		ch, ok := PiPWMChannels[pin.Pin]
+		if !ok {
+			return ch, fmt.Errorf("no pwm on pin: %s", pin.Pin)
+		}
+		return ch, nil
+	}
+	ch, ok := PWMChannels[pin.Port][pin.Pin]
+	if !ok {
+		return ch, fmt.Errorf("no pwm on port %s pin %s", pin.Port, pin.Pin)
+	}
+	return ch, nil
+}
+
+//findPWMChip returns the sysfs directory of a chip.  If the chip
+//is a device name then the pwmchips are searched for the one whose
+//device link points at it.
+func findPWMChip(chip string) (string, error) {
+	if strings.HasPrefix(chip, "/") {
+		return chip, nil
+	}
+	if strings.HasPrefix(chip, "pwmchip") {
+		return path.Join(PWM_CHIP_PATH, chip), nil
+	}
+	chips, err := filepath.Glob(path.Join(PWM_CHIP_PATH, "pwmchip*"))
+	if err != nil {
+		return "", err
+	}
+	for _, c := range chips {
+		dev, err := os.Readlink(path.Join(c, "device"))
+		if err == nil && path.Base(dev) == chip {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("couldn't find a pwmchip for %s in %s", chip, PWM_CHIP_PATH)
+}
+
+//exportPWM asks the kernel for the channel and waits for it to
+//show up.
+func exportPWM(chipPath, devPath string, channel int) error {
+	if utils.FileExists(devPath) {
+		return nil
+	}
+	err := ioutil.WriteFile(path.Join(chipPath, "export"), []byte(strconv.Itoa(channel)), PWMMode)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 100; i++ {
+		if utils.FileExists(devPath) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("pwm channel %d was not exported", channel)
+}
@@ -0,0 +1,157 @@
+package gogadgets_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func setupPWMChip(tmp, chip, device string, channels ...string) string {
+	d := path.Join(tmp, chip)
+	Expect(os.MkdirAll(d, 0777)).To(BeNil())
+	Expect(ioutil.WriteFile(path.Join(d, "export"), []byte(""), 0777)).To(BeNil())
+	if device != "" {
+		Expect(os.Symlink(path.Join("../../devices/platform/ocp", device), path.Join(d, "device"))).To(BeNil())
+	}
+	for _, ch := range channels {
+		setupPWMChannel(path.Join(d, ch))
+	}
+	return d
+}
+
+func setupPWMChannel(d string) {
+	Expect(os.MkdirAll(d, 0777)).To(BeNil())
+	for _, f := range []string{"period", "duty_cycle", "polarity", "enable"} {
+		Expect(ioutil.WriteFile(path.Join(d, f), []byte(""), 0777)).To(BeNil())
+	}
+}
+
+func readPWM(pth string) string {
+	b, _ := ioutil.ReadFile(pth)
+	return strings.TrimSpace(string(b))
+}
+
+var _ = Describe("pwmchip", func() {
+	var (
+		tmp  string
+		orig string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "")
+		Expect(err).To(BeNil())
+		orig = gogadgets.PWM_CHIP_PATH
+		gogadgets.PWM_CHIP_PATH = tmp
+		gogadgets.PWMMode = 0777
+	})
+
+	AfterEach(func() {
+		gogadgets.PWM_CHIP_PATH = orig
+		os.RemoveAll(tmp)
+	})
+
+	It("finds the beaglebone chip by device and sets it up", func() {
+		setupPWMChip(tmp, "pwmchip1", "48300200.pwm", "pwm0")
+		chip := setupPWMChip(tmp, "pwmchip4", "48302200.pwm", "pwm0")
+		p, err := gogadgets.NewPWM(&gogadgets.Pin{
+			Backend:   "pwmchip",
+			Port:      "9",
+			Pin:       "14",
+			Frequency: 20000,
+		})
+		Expect(err).To(BeNil())
+		Expect(readPWM(path.Join(chip, "pwm0", "period"))).To(Equal("50000"))
+		Expect(readPWM(path.Join(chip, "pwm0", "duty_cycle"))).To(Equal("0"))
+		Expect(readPWM(path.Join(chip, "pwm0", "polarity"))).To(Equal("normal"))
+		Expect(readPWM(path.Join(chip, "pwm0", "enable"))).To(Equal("0"))
+
+		Expect(p.On(&gogadgets.Value{Value: 25.0, Units: "%"})).To(BeNil())
+		Expect(readPWM(path.Join(chip, "pwm0", "duty_cycle"))).To(Equal("12500"))
+		Expect(readPWM(path.Join(chip, "pwm0", "enable"))).To(Equal("1"))
+		Expect(p.Status()["pwm"]).To(BeTrue())
+
+		Expect(p.On(nil)).To(BeNil())
+		Expect(readPWM(path.Join(chip, "pwm0", "duty_cycle"))).To(Equal("50000"))
+
+		Expect(p.Off()).To(BeNil())
+		Expect(readPWM(path.Join(chip, "pwm0", "duty_cycle"))).To(Equal("0"))
+		Expect(readPWM(path.Join(chip, "pwm0", "enable"))).To(Equal("0"))
+		Expect(p.Status()["pwm"]).To(BeFalse())
+	})
+
+	It("uses pwmchip0 on the pi and sets the polarity", func() {
+		chip := setupPWMChip(tmp, "pwmchip0", "", "pwm1")
+		_, err := gogadgets.NewPWM(&gogadgets.Pin{
+			Backend:   "pwmchip",
+			Platform:  "rpi",
+			Pin:       "33",
+			Frequency: 1000,
+			ActiveLow: "1",
+		})
+		Expect(err).To(BeNil())
+		Expect(readPWM(path.Join(chip, "pwm1", "period"))).To(Equal("1000000"))
+		Expect(readPWM(path.Join(chip, "pwm1", "polarity"))).To(Equal("inversed"))
+	})
+
+	It("exports the channel", func() {
+		chip := setupPWMChip(tmp, "pwmchip2", "")
+		go func() {
+			defer GinkgoRecover()
+			Eventually(func() string {
+				return readPWM(path.Join(chip, "export"))
+			}).Should(Equal("3"))
+			setupPWMChannel(path.Join(chip, "pwm3"))
+		}()
+		_, err := gogadgets.NewPWM(&gogadgets.Pin{
+			Backend:   "pwmchip",
+			Chip:      "pwmchip2",
+			Pin:       "3",
+			Frequency: 1000,
+		})
+		Expect(err).To(BeNil())
+		Expect(readPWM(path.Join(chip, "pwm3", "period"))).To(Equal("1000000"))
+	})
+
+	It("needs a frequency", func() {
+		setupPWMChip(tmp, "pwmchip4", "48302200.pwm", "pwm0")
+		_, err := gogadgets.NewPWM(&gogadgets.Pin{
+			Backend: "pwmchip",
+			Port:    "9",
+			Pin:     "14",
+		})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("lets a heater set the duty cycle in hardware", func() {
+		chip := setupPWMChip(tmp, "pwmchip7", "48304200.pwm", "pwm1")
+		h, err := gogadgets.NewHeater(&gogadgets.Pin{
+			Backend: "pwmchip",
+			Port:    "8",
+			Pin:     "13",
+			Args:    map[string]interface{}{"pwm": true},
+		})
+		Expect(err).To(BeNil())
+		h.Update(&gogadgets.Message{
+			Name:  "temperature",
+			Value: gogadgets.Value{Value: 99.5, Units: "F"},
+		})
+		Expect(h.On(&gogadgets.Value{Value: 100.0, Units: "F"})).To(BeNil())
+		Eventually(func() string {
+			return readPWM(path.Join(chip, "pwm1", "duty_cycle"))
+		}).Should(Equal("250000000"))
+		Eventually(func() string {
+			return readPWM(path.Join(chip, "pwm1", "enable"))
+		}).Should(Equal("1"))
+
+		Expect(h.Off()).To(BeNil())
+		Eventually(func() string {
+			return readPWM(path.Join(chip, "pwm1", "enable"))
+		}).Should(Equal("0"))
+	})
+})
@@ -2,11 +2,25 @@ package gogadgets
 
 import (
 	"sync"
+	"time"
+)
+
+//What a bounded Queue does with a message when it is full.
+const (
+	//DropOldest makes room by throwing away the oldest message.
+	DropOldest = "drop oldest"
+	//DropNewest throws away the message that was just pushed.
+	DropNewest = "drop newest"
+	//Coalesce makes room by throwing away the update from the same
+	//sender that is waiting (the new one goes to the back of the
+	//queue) and otherwise works like DropOldest.
+	Coalesce = "coalesce"
 )
 
 type queuenode struct {
-	data *Message
-	next *queuenode
+	data   *Message
+	next   *queuenode
+	pushed time.Time
 }
 
 /*
@@ -16,11 +30,15 @@ all gadgets with be able to send their message without
 being blocked.
 */
 type Queue struct {
-	head  *queuenode
-	tail  *queuenode
-	count int
-	lock  *sync.Mutex
-	cond  *sync.Cond
+	head      *queuenode
+	tail      *queuenode
+	count     int
+	size      int
+	policy    string
+	dropped   int
+	coalesced int
+	lock      *sync.Mutex
+	cond      *sync.Cond
 }
 
 func NewQueue() *Queue {
@@ -30,6 +48,18 @@ func NewQueue() *Queue {
 	return q
 }
 
+//NewBoundedQueue returns a queue that holds at most size
+//updates.  The policy (DropOldest, DropNewest or Coalesce) says
+//what happens to them when it is full.  Commands and replies
+//(everything that isn't an UPDATE or a METHODUPDATE) are never
+//dropped, they are queued even past size.
+func NewBoundedQueue(size int, policy string) *Queue {
+	q := NewQueue()
+	q.size = size
+	q.policy = policy
+	return q
+}
+
 func (q *Queue) Len() int {
 	q.lock.Lock()
 	c := q.count
@@ -39,7 +69,33 @@ func (q *Queue) Len() int {
 
 func (q *Queue) Push(item *Message) {
 	q.lock.Lock()
-	n := &queuenode{data: item}
+	if q.push(item) {
+		q.lock.Unlock()
+		//signal while holding the lock of the cond so a
+		//goroutine that is about to Wait doesn't miss it
+		q.cond.L.Lock()
+		q.cond.Signal()
+		q.cond.L.Unlock()
+	} else {
+		q.lock.Unlock()
+	}
+}
+
+func (q *Queue) push(item *Message) bool {
+	if q.size > 0 && q.count >= q.size && item.isDroppable() {
+		sameSender := func(m *Message) bool {
+			return m.Type == UPDATE && m.Sender == item.Sender
+		}
+		if q.policy == Coalesce && item.Type == UPDATE && q.remove(sameSender) {
+			q.coalesced++
+		} else {
+			q.dropped++
+			if q.policy == DropNewest || !q.remove((*Message).isDroppable) {
+				return false
+			}
+		}
+	}
+	n := &queuenode{data: item, pushed: time.Now()}
 	if q.tail == nil {
 		q.tail = n
 		q.head = n
@@ -48,13 +104,53 @@ func (q *Queue) Push(item *Message) {
 		q.tail = n
 	}
 	q.count++
-	q.cond.Signal()
-	q.lock.Unlock()
+	return true
+}
+
+//remove takes the oldest message that matches out of the queue, it
+//returns false when none of them do.
+func (q *Queue) remove(match func(*Message) bool) bool {
+	var prev *queuenode
+	for n := q.head; n != nil; prev, n = n, n.next {
+		if !match(n.data) {
+			continue
+		}
+		if prev == nil {
+			q.head = n.next
+		} else {
+			prev.next = n.next
+		}
+		if q.tail == n {
+			q.tail = prev
+		}
+		q.count--
+		return true
+	}
+	return false
 }
 
 func (q *Queue) Get() *Message {
 	q.lock.Lock()
 	defer q.lock.Unlock()
+	n := q.pop()
+	if n == nil {
+		return nil
+	}
+	return n.data
+}
+
+//getWithTime returns the next message and when it was pushed.
+func (q *Queue) getWithTime() (*Message, time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	n := q.pop()
+	if n == nil {
+		return nil, time.Time{}
+	}
+	return n.data, n.pushed
+}
+
+func (q *Queue) pop() *queuenode {
 	if q.head == nil {
 		return nil
 	}
@@ -64,7 +160,15 @@ func (q *Queue) Get() *Message {
 		q.tail = nil
 	}
 	q.count--
-	return n.data
+	return n
+}
+
+//Counts returns how many messages have been dropped and
+//coalesced since the queue was made.
+func (q *Queue) Counts() (dropped, coalesced int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.dropped, q.coalesced
 }
 
 /*
@@ -79,6 +183,11 @@ func (q *Queue) Wait() {
 	q.cond.Wait()
 }
 
+//Broadcast wakes up every goroutine that is blocked in Wait.
+func (q *Queue) Broadcast() {
+	q.cond.Broadcast()
+}
+
 func (q *Queue) Lock() {
 	q.cond.L.Lock()
 }
@@ -0,0 +1,458 @@
+/*
+Package rcl parses Robot Command Language (RCL) commands, the
+strings that gadgets send each other to get things done:
+
+	turn on living room light
+	turn on living room light for 30 minutes
+	heat boiler to 22 C
+	autotune hlt heater at 150 F
+	turn on hlt valve until mash tun volume >= 20 liters or 10 minutes
+	wait for 5 seconds
+	wait for boiler temperature >= 200 F
+	wait for user to add the hops
+
+The grammar is
+
+	command   = action [target] {clause}
+	          | "wait for user" text
+	action    = "turn on" | "turn off" | word
+	target    = word {word}
+	clause    = ("to" | "at") quantity
+	          | "for" quantity
+	          | "for" condition        (wait only)
+	          | "until" condition ["or" quantity]
+	condition = word {word} operator value [unit]
+	quantity  = number [unit]
+	operator  = "<" | "<=" | "==" | "!=" | ">=" | ">"
+	value     = number | "true" | "false"
+
+A target ends at the first of the keywords to, at, for and until.
+A quantity after for (or or) whose units are a time is the duration
+(or timeout) of the command.  Any other quantity after for has to be
+an amount in units RCL knows (fill tank for 5 gallons), so a typo in
+a time (for 5 minuts) is an error rather than a command that never
+stops.
+*/
+package rcl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	actions   = [][]string{{"wait", "for", "user"}, {"turn", "on"}, {"turn", "off"}}
+	keywords  = map[string]bool{"to": true, "at": true, "for": true, "until": true}
+	operators = map[string]bool{"<": true, "<=": true, "==": true, "!=": true, ">=": true, ">": true}
+)
+
+//Command is a parsed RCL command.
+type Command struct {
+	Action    string
+	Target    string
+	Arguments []Argument
+	Duration  *Quantity
+	Condition *Condition
+	Timeout   *Quantity
+}
+
+//Argument is a quantity that follows to, at (or for when it isn't a
+//time), for example 'to 22 C'.
+type Argument struct {
+	Prep string
+	Quantity
+}
+
+//Quantity is a number and its (optional) units.
+type Quantity struct {
+	Value float64
+	Units string
+}
+
+//Condition compares the value of a sensor to a number or a bool,
+//for example 'mash tun volume >= 20 liters'.
+type Condition struct {
+	Sensor   string
+	Operator string
+	Value    interface{}
+	Units    string
+}
+
+//Error is returned by Parse.  Pos is the byte offset in Input
+//where the problem is.
+type Error struct {
+	Input string
+	Pos   int
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d of '%s'", e.Msg, e.Pos, e.Input)
+}
+
+//Caret returns the input with a ^ under the position of the
+//error, for showing to people.
+func (e *Error) Caret() string {
+	return fmt.Sprintf("%s\n%s^", e.Input, strings.Repeat(" ", e.Pos))
+}
+
+//Parse parses a single command.
+func Parse(s string) (*Command, error) {
+	p := &parser{input: s, toks: lex(s)}
+	return p.parse()
+}
+
+//ParseCondition parses a condition on its own, for example
+//'greenhouse temperature >= 12 C'.
+func ParseCondition(s string) (*Condition, error) {
+	p := &parser{input: s, toks: lex(s)}
+	if p.peek() == nil {
+		return nil, p.errorf(0, "empty condition")
+	}
+	c, err := p.condition(nil)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, p.errorf(t.pos, "unexpected '%s'", t.text)
+	}
+	return c, nil
+}
+
+//Format returns the canonical form of a command.
+func Format(s string) (string, error) {
+	c, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+func (c *Command) String() string {
+	parts := []string{c.Action}
+	if c.Target != "" {
+		parts = append(parts, c.Target)
+	}
+	for _, a := range c.Arguments {
+		parts = append(parts, a.Prep, a.Quantity.String())
+	}
+	if c.Duration != nil {
+		parts = append(parts, "for", c.Duration.String())
+	}
+	if c.Condition != nil {
+		if c.Action == "wait" {
+			parts = append(parts, "for")
+		} else {
+			parts = append(parts, "until")
+		}
+		parts = append(parts, c.Condition.String())
+	}
+	if c.Timeout != nil {
+		parts = append(parts, "or", c.Timeout.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (q Quantity) String() string {
+	s := formatFloat(q.Value)
+	if q.Units != "" {
+		s += " " + q.Units
+	}
+	return s
+}
+
+func (c *Condition) String() string {
+	var v string
+	switch x := c.Value.(type) {
+	case float64:
+		v = formatFloat(x)
+	default:
+		v = fmt.Sprintf("%v", x)
+	}
+	s := fmt.Sprintf("%s %s %s", c.Sensor, c.Operator, v)
+	if c.Units != "" {
+		s += " " + c.Units
+	}
+	return s
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+func (t token) isOperator() bool {
+	return strings.IndexByte("<>=!", t.text[0]) != -1
+}
+
+//lex splits a command into words.  Operators are split out even
+//if they aren't surrounded by spaces (volume>=20).
+func lex(s string) []token {
+	var toks []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		if isSpace(c) {
+			i++
+			continue
+		}
+		op := strings.IndexByte("<>=!", c) != -1
+		j := i
+		for j < len(s) && !isSpace(s[j]) && (strings.IndexByte("<>=!", s[j]) != -1) == op {
+			j++
+		}
+		toks = append(toks, token{text: s[i:j], pos: i})
+		i = j
+	}
+	return toks
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+type parser struct {
+	input string
+	toks  []token
+	i     int
+}
+
+func (p *parser) peek() *token {
+	if p.i >= len(p.toks) {
+		return nil
+	}
+	return &p.toks[p.i]
+}
+
+func (p *parser) next() *token {
+	t := p.peek()
+	if t != nil {
+		p.i++
+	}
+	return t
+}
+
+//pos is where the next token starts (or the end of the input).
+func (p *parser) pos() int {
+	if t := p.peek(); t != nil {
+		return t.pos
+	}
+	return len(p.input)
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &Error{Input: p.input, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parse() (*Command, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, p.errorf(0, "empty command")
+	}
+	if keywords[t.text] || t.isOperator() {
+		return nil, p.errorf(t.pos, "expected an action, got '%s'", t.text)
+	}
+
+	c := &Command{Action: p.action()}
+	if c.Action == "wait for user" {
+		c.Target = strings.TrimSpace(p.input[p.pos():])
+		return c, nil
+	}
+
+	target, err := p.words()
+	if err != nil {
+		return nil, err
+	}
+	c.Target = target
+	if c.Target == "" && (c.Action == "turn on" || c.Action == "turn off") {
+		return nil, p.errorf(p.pos(), "expected something to %s", c.Action)
+	}
+
+	for p.peek() != nil {
+		if err := p.clause(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Action == "wait" && c.Duration == nil && c.Condition == nil {
+		return nil, p.errorf(p.pos(), "expected a time or a condition to wait for")
+	}
+	return c, nil
+}
+
+func (p *parser) action() string {
+	for _, a := range actions {
+		if p.matches(a) {
+			p.i += len(a)
+			return strings.Join(a, " ")
+		}
+	}
+	return p.next().text
+}
+
+func (p *parser) matches(words []string) bool {
+	if len(p.toks)-p.i < len(words) {
+		return false
+	}
+	for j, w := range words {
+		if p.toks[p.i+j].text != w {
+			return false
+		}
+	}
+	return true
+}
+
+//words reads the words of a target up to the next keyword.
+func (p *parser) words() (string, error) {
+	var w []string
+	for t := p.peek(); t != nil && !keywords[t.text]; t = p.peek() {
+		if t.isOperator() {
+			return "", p.errorf(t.pos, "unexpected '%s'", t.text)
+		}
+		w = append(w, p.next().text)
+	}
+	return strings.Join(w, " "), nil
+}
+
+func (p *parser) clause(c *Command) error {
+	t := p.next()
+	switch t.text {
+	case "to", "at":
+		q, err := p.quantity(t)
+		if err != nil {
+			return err
+		}
+		c.Arguments = append(c.Arguments, Argument{Prep: t.text, Quantity: q})
+	case "for":
+		if c.Action == "wait" && !p.isNumber() {
+			return p.setCondition(c, t)
+		}
+		pos := p.pos()
+		q, err := p.quantity(t)
+		if err != nil {
+			return err
+		}
+		if !IsTime(q.Units) {
+			if _, _, ok := LookupUnit(q.Units); !ok {
+				return p.errorf(pos, "expected a time or an amount after 'for', got '%s'", q)
+			}
+			c.Arguments = append(c.Arguments, Argument{Prep: t.text, Quantity: q})
+			return nil
+		}
+		if c.Duration != nil {
+			return p.errorf(t.pos, "more than one 'for'")
+		}
+		c.Duration = &q
+	case "until":
+		if err := p.setCondition(c, t); err != nil {
+			return err
+		}
+		if n := p.peek(); n != nil && n.text == "or" {
+			p.next()
+			pos := p.pos()
+			q, err := p.quantity(n)
+			if err != nil {
+				return err
+			}
+			if !IsTime(q.Units) {
+				return p.errorf(pos, "expected a time after 'or'")
+			}
+			c.Timeout = &q
+		}
+	default:
+		return p.errorf(t.pos, "unexpected '%s'", t.text)
+	}
+	return nil
+}
+
+func (p *parser) setCondition(c *Command, t *token) error {
+	if c.Condition != nil {
+		return p.errorf(t.pos, "more than one condition")
+	}
+	cond, err := p.condition(t)
+	if err != nil {
+		return err
+	}
+	c.Condition = cond
+	return nil
+}
+
+func (p *parser) isNumber() bool {
+	t := p.peek()
+	if t == nil {
+		return false
+	}
+	_, err := strconv.ParseFloat(t.text, 64)
+	return err == nil
+}
+
+func (p *parser) quantity(after *token) (Quantity, error) {
+	t := p.next()
+	if t == nil {
+		return Quantity{}, p.errorf(len(p.input), "expected a number after '%s'", after.text)
+	}
+	v, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return Quantity{}, p.errorf(t.pos, "expected a number after '%s', got '%s'", after.text, t.text)
+	}
+	return Quantity{Value: v, Units: p.units()}, nil
+}
+
+//units reads the (optional) units that follow a number.
+func (p *parser) units() string {
+	t := p.peek()
+	if t == nil || keywords[t.text] || t.text == "or" || t.isOperator() {
+		return ""
+	}
+	return p.next().text
+}
+
+func (p *parser) condition(after *token) (*Condition, error) {
+	var sensor []string
+	for t := p.peek(); t == nil || !t.isOperator(); t = p.peek() {
+		if t == nil || keywords[t.text] {
+			return nil, p.errorf(p.pos(), "expected a comparison (<, <=, ==, !=, >= or >)")
+		}
+		sensor = append(sensor, p.next().text)
+	}
+	if len(sensor) == 0 && after == nil {
+		return nil, p.errorf(p.pos(), "expected a sensor")
+	}
+	if len(sensor) == 0 {
+		return nil, p.errorf(p.pos(), "expected a sensor after '%s'", after.text)
+	}
+	op := p.next()
+	if !operators[op.text] {
+		return nil, p.errorf(op.pos, "unknown comparison '%s'", op.text)
+	}
+
+	t := p.next()
+	if t == nil {
+		return nil, p.errorf(len(p.input), "expected a value after '%s'", op.text)
+	}
+	var val interface{}
+	switch t.text {
+	case "true":
+		val = true
+	case "false":
+		val = false
+	default:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t.pos, "expected a number, true or false, got '%s'", t.text)
+		}
+		val = f
+	}
+
+	return &Condition{
+		Sensor:   strings.Join(sensor, " "),
+		Operator: op.text,
+		Value:    val,
+		Units:    p.units(),
+	}, nil
+}
@@ -0,0 +1,221 @@
+package rcl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in  string
+		cmd Command
+	}{
+		{
+			in:  "turn on living room light",
+			cmd: Command{Action: "turn on", Target: "living room light"},
+		},
+		{
+			in: "turn on living room light for 30 minutes",
+			cmd: Command{
+				Action:   "turn on",
+				Target:   "living room light",
+				Duration: &Quantity{30, "minutes"},
+			},
+		},
+		{
+			in: "turn on living room light for 30 Minutes",
+			cmd: Command{
+				Action:   "turn on",
+				Target:   "living room light",
+				Duration: &Quantity{30, "Minutes"},
+			},
+		},
+		{
+			in: "heat boiler to 22 C",
+			cmd: Command{
+				Action:    "heat",
+				Target:    "boiler",
+				Arguments: []Argument{{"to", Quantity{22, "C"}}},
+			},
+		},
+		{
+			in: "fill tank for 5 gallons",
+			cmd: Command{
+				Action:    "fill",
+				Target:    "tank",
+				Arguments: []Argument{{"for", Quantity{5, "gallons"}}},
+			},
+		},
+		{
+			in: "turn on hlt valve until mash tun volume>=20 liters or 10 minutes",
+			cmd: Command{
+				Action:    "turn on",
+				Target:    "hlt valve",
+				Condition: &Condition{"mash tun volume", ">=", 20.0, "liters"},
+				Timeout:   &Quantity{10, "minutes"},
+			},
+		},
+		{
+			in: "turn on pump until tank float switch == true",
+			cmd: Command{
+				Action:    "turn on",
+				Target:    "pump",
+				Condition: &Condition{"tank float switch", "==", true, ""},
+			},
+		},
+		{
+			in: "wait for 0.5 seconds",
+			cmd: Command{
+				Action:   "wait",
+				Duration: &Quantity{0.5, "seconds"},
+			},
+		},
+		{
+			in: "wait for boiler temperature >= 200 F",
+			cmd: Command{
+				Action:    "wait",
+				Condition: &Condition{"boiler temperature", ">=", 200.0, "F"},
+			},
+		},
+		{
+			in:  "wait for user to add the hops",
+			cmd: Command{Action: "wait for user", Target: "to add the hops"},
+		},
+		{
+			in:  "shutdown",
+			cmd: Command{Action: "shutdown"},
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("%s: %s", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(*c, tt.cmd) {
+			t.Errorf("%s: got %+v, want %+v", tt.in, *c, tt.cmd)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos int
+		msg string
+	}{
+		{"", 0, "empty command"},
+		{"to 22 C", 0, "expected an action, got 'to'"},
+		{"turn on", 7, "expected something to turn on"},
+		{"heat boiler to hot", 15, "expected a number after 'to', got 'hot'"},
+		{"heat boiler to", 14, "expected a number after 'to'"},
+		{"turn on fan until it's cool", 27, "expected a comparison (<, <=, ==, !=, >= or >)"},
+		{"turn on fan until >= 3", 18, "expected a sensor after 'until'"},
+		{"turn on fan until temp => 3", 23, "unknown comparison '=>'"},
+		{"turn on fan until temp < cool", 25, "expected a number, true or false, got 'cool'"},
+		{"turn on fan until temp < 3 C or 4 liters", 32, "expected a time after 'or'"},
+		{"turn on fan for 1 minute for 2 minutes", 25, "more than one 'for'"},
+		{"turn on fan until a > 1 until b > 2", 24, "more than one condition"},
+		{"wait", 4, "expected a time or a condition to wait for"},
+		{"turn on fan > 3", 12, "unexpected '>'"},
+		{"turn on fan for 5 minuts", 16, "expected a time or an amount after 'for', got '5 minuts'"},
+		{"turn on fan for 5", 16, "expected a time or an amount after 'for', got '5'"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.in)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected an *Error, got %v", tt.in, err)
+			continue
+		}
+		if e.Pos != tt.pos || e.Msg != tt.msg {
+			t.Errorf("%s: got '%s' at %d, want '%s' at %d", tt.in, e.Msg, e.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	c, err := ParseCondition("freezer temperature > -10 C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Condition{Sensor: "freezer temperature", Operator: ">", Value: -10.0, Units: "C"}
+	if *c != want {
+		t.Errorf("got %+v, want %+v", *c, want)
+	}
+
+	tests := []struct {
+		in  string
+		pos int
+		msg string
+	}{
+		{"", 0, "empty condition"},
+		{">= 3", 0, "expected a sensor"},
+		{"temp > 3 C for 5 minutes", 11, "unexpected 'for'"},
+		{"temp", 4, "expected a comparison (<, <=, ==, !=, >= or >)"},
+	}
+	for _, tt := range tests {
+		_, err := ParseCondition(tt.in)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected an *Error, got %v", tt.in, err)
+			continue
+		}
+		if e.Pos != tt.pos || e.Msg != tt.msg {
+			t.Errorf("%s: got '%s' at %d, want '%s' at %d", tt.in, e.Msg, e.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestErrorCaret(t *testing.T) {
+	_, err := Parse("heat boiler to hot")
+	want := "heat boiler to hot\n               ^"
+	if c := err.(*Error).Caret(); c != want {
+		t.Errorf("got %q, want %q", c, want)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"turn on  living room light", "turn on living room light"},
+		{"heat boiler to 22.0 C", "heat boiler to 22 C"},
+		{"turn on hlt valve until mash tun volume>=20 liters or 10 minutes", "turn on hlt valve until mash tun volume >= 20 liters or 10 minutes"},
+		{"wait for boiler temperature>=200 F", "wait for boiler temperature >= 200 F"},
+		{"wait for user to add the hops", "wait for user to add the hops"},
+	}
+
+	for _, tt := range tests {
+		s, err := Format(tt.in)
+		if err != nil {
+			t.Errorf("%s: %s", tt.in, err)
+			continue
+		}
+		if s != tt.out {
+			t.Errorf("%s: got '%s', want '%s'", tt.in, s, tt.out)
+		}
+		//the canonical form has to parse to the same command
+		a, _ := Parse(tt.in)
+		b, err := Parse(s)
+		if err != nil || !reflect.DeepEqual(a, b) {
+			t.Errorf("%s: round trip gave %+v, want %+v", tt.in, b, a)
+		}
+	}
+}
+
+func TestIsTime(t *testing.T) {
+	for _, u := range []string{"seconds", "s", "Minutes", "MIN", "hour"} {
+		if !IsTime(u) {
+			t.Errorf("%s should be a time", u)
+		}
+	}
+	for _, u := range []string{"", "minuts", "liters", "C"} {
+		if IsTime(u) {
+			t.Errorf("%s shouldn't be a time", u)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rcl
+
+import "strings"
+
+//unitList is every unit RCL knows, the kind of quantity it is and
+//the names it can be written with (case doesn't matter).  The
+//gogadgets package converts between them (see gogadgets.GetUnit).
+var unitList = []struct {
+	unit  string
+	kind  string
+	names []string
+}{
+	{"C", "temperature", []string{"c", "celsius", "celcius"}},
+	{"F", "temperature", []string{"f", "fahrenheit"}},
+	{"K", "temperature", []string{"k", "kelvin"}},
+
+	{"liters", "volume", []string{"liters", "liter", "l"}},
+	{"milliliters", "volume", []string{"milliliters", "milliliter", "ml"}},
+	{"gallons", "volume", []string{"gallons", "gallon", "gal"}},
+	{"quarts", "volume", []string{"quarts", "quart", "qt"}},
+
+	{"liters/second", "flow", []string{"liters/second", "l/s"}},
+	{"liters/minute", "flow", []string{"liters/minute", "l/min"}},
+	{"gallons/minute", "flow", []string{"gallons/minute", "gpm"}},
+
+	{"seconds", "time", []string{"seconds", "second", "s", "sec"}},
+	{"minutes", "time", []string{"minutes", "minute", "min"}},
+	{"hours", "time", []string{"hours", "hour", "h"}},
+
+	{"%", "percent", []string{"%", "percent"}},
+
+	{"kPa", "pressure", []string{"kpa"}},
+	{"Pa", "pressure", []string{"pa"}},
+	{"hPa", "pressure", []string{"hpa", "mbar"}},
+	{"bar", "pressure", []string{"bar"}},
+	{"psi", "pressure", []string{"psi"}},
+
+	{"%RH", "humidity", []string{"%rh", "rh"}},
+}
+
+var unitNames = map[string]int{}
+
+func init() {
+	for i, u := range unitList {
+		for _, n := range u.names {
+			unitNames[n] = i
+		}
+	}
+}
+
+//LookupUnit returns the name and kind (temperature, volume, time...)
+//of the unit that name is one of the names of, so LookupUnit("Minutes")
+//returns minutes and time.
+func LookupUnit(name string) (unit, kind string, ok bool) {
+	i, ok := unitNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return "", "", false
+	}
+	return unitList[i].unit, unitList[i].kind, true
+}
+
+//IsTime returns true if units are seconds, minutes or hours (in
+//any case and any of their names).
+func IsTime(units string) bool {
+	_, kind, ok := LookupUnit(units)
+	return ok && kind == "time"
+}
@@ -1,43 +1,100 @@
 package gogadgets
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"sync"
 	"time"
 )
 
-type summary struct {
-	start time.Time
-	n     int
-	v     float64
-}
-
 //Recorder takes all the update messages it receives and saves them
-//by posting to quimby
+//to its sinks (see SinkConfig).  Values can be converted before
+//they are saved by setting the units for each kind of quantity, for
+//example:
+//
+//	"args": {
+//	    "sinks": [
+//	        {"type": "csv", "path": "/var/lib/gogadgets/values.csv"},
+//	        {"type": "influx", "url": "http://localhost:8086/write?db=gadgets"}
+//	    ],
+//	    "units": {"temperature": "C", "volume": "liters"}
+//	}
+//
+//host and token (without sinks) still post to quimby.  The values
+//of some gadgets can be summarized instead of saving every one of
+//them (see SummaryConfig).
 type Recorder struct {
-	url       string
-	token     string
-	status    bool
-	filter    []string
-	summaries map[string]time.Duration
-	history   map[string]summary
+	sinks      []Sink
+	reported   int
+	reportedAt time.Time
+	status     bool
+	filter     []string
+	units      map[string]string
+	summaries  map[string]summaryConfig
+	lock       sync.Mutex
+	series     map[string]*series
+	stop       chan bool
+	wg         sync.WaitGroup
 }
 
 func NewRecorder(pin *Pin) (OutputDevice, error) {
-	s := getSummaries(pin.Args["summarize"])
+	s, err := getSummaries(pin.Args["summarize"])
+	if err != nil {
+		return nil, err
+	}
+	sinks, err := getSinks(pin.Args)
+	if err != nil {
+		return nil, err
+	}
 	r := &Recorder{
-		url:       pin.Args["host"].(string),
-		token:     pin.Args["token"].(string),
+		sinks:     sinks,
 		filter:    getFilter(pin.Args["filter"]),
-		history:   map[string]summary{},
+		units:     getRecorderUnits(pin.Args["units"]),
+		series:    map[string]*series{},
 		summaries: s,
+		stop:      make(chan bool),
+	}
+	if len(s) > 0 {
+		r.wg.Add(1)
+		go r.closeWindows()
 	}
 	return r, nil
 }
 
+//getSinks makes the sinks in the "sinks" arg, or a quimby sink
+//from the "host" and "token" args.
+func getSinks(args map[string]interface{}) ([]Sink, error) {
+	var cfgs []SinkConfig
+	if s, ok := args["sinks"]; ok {
+		d, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(d, &cfgs); err != nil {
+			return nil, fmt.Errorf("could not parse the recorder sinks: %s", err)
+		}
+	} else if host, ok := args["host"].(string); ok {
+		token, _ := args["token"].(string)
+		cfgs = append(cfgs, SinkConfig{Type: "quimby", URL: host, Token: token})
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("the recorder needs sinks (or a host)")
+	}
+	sinks := make([]Sink, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		s, err := NewSink(cfg)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
 func (r *Recorder) Commands(location, name string) *Commands {
 	return nil
 }
@@ -45,36 +102,49 @@ func (r *Recorder) Commands(location, name string) *Commands {
 func (r *Recorder) Config() ConfigHelper {
 	return ConfigHelper{
 		Args: map[string]interface{}{
-			"host": []string{},
+			"sinks":     []SinkConfig{},
+			"units":     map[string]string{},
+			"summarize": map[string]SummaryConfig{},
 		},
 	}
 }
 
-func getSummaries(s interface{}) map[string]time.Duration {
-	if s == nil {
-		return map[string]time.Duration{}
+//Update saves msg.  It returns true (so the recorder sends an
+//update) when values start or stop waiting in a spool, and every
+//30 seconds while the number that is waiting changes.
+func (r *Recorder) Update(msg *Message) bool {
+	if r.status && msg.Type == "update" {
+		r.save(msg)
 	}
-	d, _ := json.Marshal(s)
-	vals := map[string]int{}
-	err := json.Unmarshal(d, &vals)
-	out := map[string]time.Duration{}
-	if err != nil {
-		log.Println("WARNING, could not parse recorder summaires", s)
-		return out
+	n, ok := r.spooled()
+	if !ok || n == r.reported {
+		return false
 	}
-	for key, val := range vals {
-		var d time.Duration
-		d = time.Duration(val) * time.Minute
-		out[key] = d
+	return (n == 0) != (r.reported == 0) || time.Since(r.reportedAt) >= 30*time.Second
+}
+
+//Report sends how many values are waiting in the spools of the
+//sinks (if they have any).
+func (r *Recorder) Report() map[string]interface{} {
+	n, ok := r.spooled()
+	if !ok {
+		return nil
 	}
-	return out
+	r.reported = n
+	r.reportedAt = time.Now()
+	return map[string]interface{}{"spooled": n}
 }
 
-func (r *Recorder) Update(msg *Message) bool {
-	if r.status && msg.Type == "update" {
-		r.save(msg)
+func (r *Recorder) spooled() (int, bool) {
+	var n int
+	var ok bool
+	for _, s := range r.sinks {
+		if sp, isSpool := s.(*spool); isSpool {
+			n += sp.Depth()
+			ok = true
+		}
 	}
-	return false
+	return n, ok
 }
 
 func (r *Recorder) On(val *Value) error {
@@ -91,18 +161,54 @@ func (r *Recorder) Status() map[string]bool {
 	return map[string]bool{"recorder": r.status}
 }
 
+//Close stops closing summary windows (the ones that haven't ended
+//aren't saved) and closes the sinks.
+func (r *Recorder) Close() error {
+	close(r.stop)
+	r.wg.Wait()
+	var err error
+	for _, s := range r.sinks {
+		if e := s.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
 func (r *Recorder) save(msg *Message) {
 	if len(r.filter) > 0 {
 		if !r.inFilter(msg) {
 			return
 		}
 	}
-	d, ok := r.summaries[msg.Sender]
+	m := r.convert(*msg)
+	cfg, ok := r.summaries[msg.Sender]
 	if ok {
-		r.summarize(msg, d)
+		r.summarize(&m, cfg)
 	} else {
-		r.doSave(msg)
+		r.doSave(&m)
+	}
+}
+
+//convert puts the value of the message in the units the recorder
+//was configured with (so a thermometer that is switched from F to C
+//doesn't mess up the history).
+func (r *Recorder) convert(msg Message) Message {
+	q, ok := msg.Value.Quantity()
+	if !ok {
+		return msg
+	}
+	to, ok := r.units[q.Kind()]
+	if !ok {
+		return msg
+	}
+	v, err := q.In(to)
+	if err != nil {
+		return msg
 	}
+	msg.Value.Value = v
+	msg.Value.Units = to
+	return msg
 }
 
 func (r *Recorder) inFilter(msg *Message) bool {
@@ -114,50 +220,26 @@ func (r *Recorder) inFilter(msg *Message) bool {
 	return false
 }
 
-func (r *Recorder) summarize(msg *Message, duration time.Duration) {
-	now := time.Now().UTC()
-	s, ok := r.history[msg.Sender]
-	if !ok {
-		s = summary{start: now}
-	}
-	s.n += 1
-	f, _ := msg.Value.ToFloat()
-	s.v += f
-	lapsed := now.Sub(s.start)
-	if lapsed >= duration {
-		msg.Value.Value = s.v / float64(s.n)
-		r.doSave(msg)
-		delete(r.history, msg.Sender)
-	} else {
-		r.history[msg.Sender] = s
+func (r *Recorder) doSave(msg *Message) {
+	for _, s := range r.sinks {
+		if err := s.Save(*msg); err != nil {
+			log.Println("couldn't save data", err)
+		}
 	}
 }
 
-func (r *Recorder) doSave(msg *Message) {
-	v, ok := msg.Value.Value.(float64)
+func getRecorderUnits(u interface{}) map[string]string {
+	out := map[string]string{}
+	m, ok := u.(map[string]interface{})
 	if !ok {
-		return
-	}
-	m := map[string]float64{
-		"value": v,
-	}
-	buf := bytes.Buffer{}
-	enc := json.NewEncoder(&buf)
-	enc.Encode(m)
-
-	u := fmt.Sprintf(r.url, msg.Location, msg.Name)
-	req, err := http.NewRequest("POST", u, &buf)
-	if err != nil {
-		log.Println("couldn't post data", err)
-		return
+		return out
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", r.token))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("couldn't post data", err)
-		return
+	for kind, val := range m {
+		if s, ok := val.(string); ok {
+			out[kind] = s
+		}
 	}
-	resp.Body.Close()
+	return out
 }
 
 func getFilter(f interface{}) []string {
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cswank/gogadgets"
 	. "github.com/onsi/ginkgo"
@@ -67,4 +72,243 @@ var _ = Describe("Recorder", func() {
 			Expect(strings.TrimSpace(posts[0])).To(Equal(`{"value":411}`))
 		})
 	})
+
+	Describe("with units", func() {
+		It("converts values before saving them", func() {
+			x, err := gogadgets.NewRecorder(&gogadgets.Pin{
+				Args: map[string]interface{}{
+					"host":  ts.URL + "/%s/%s",
+					"token": "xyz",
+					"units": map[string]interface{}{"temperature": "C"},
+				},
+			})
+			Expect(err).To(BeNil())
+			x.On(nil)
+			x.Update(&gogadgets.Message{
+				Type:     gogadgets.UPDATE,
+				Location: "lab",
+				Name:     "thermometer",
+				Value:    gogadgets.Value{Value: 212.0, Units: "F"},
+			})
+			x.Update(&gogadgets.Message{
+				Type:     gogadgets.UPDATE,
+				Location: "lab",
+				Name:     "pump",
+				Value:    gogadgets.Value{Value: 40.0, Units: "%"},
+			})
+			Expect(len(posts)).To(Equal(2))
+			Expect(strings.TrimSpace(posts[0])).To(Equal(`{"value":100,"units":"C"}`))
+			Expect(strings.TrimSpace(posts[1])).To(Equal(`{"value":40,"units":"%"}`))
+		})
+	})
+
+	Describe("with sinks", func() {
+		var tmp string
+
+		BeforeEach(func() {
+			var err error
+			tmp, err = ioutil.TempDir("", "")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmp)
+		})
+
+		It("saves every value (on and off too) to all of them", func() {
+			cfg := fmt.Sprintf(`{
+  "args": {
+    "sinks": [
+      {"type": "csv", "path": "%[1]s/values.csv"},
+      {"type": "jsonl", "path": "%[1]s/values.jsonl"},
+      {"type": "store", "path": "%[1]s/store"},
+      {"type": "influx", "url": "%[2]s/write?db=gadgets", "token": "abc", "measurement": "house"}
+    ],
+    "units": {"temperature": "C"}
+  }
+}`, tmp, ts.URL)
+			p := &gogadgets.Pin{}
+			Expect(json.Unmarshal([]byte(cfg), p)).To(BeNil())
+			x, err := gogadgets.NewRecorder(p)
+			Expect(err).To(BeNil())
+			x.On(nil)
+
+			ts1 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+			x.Update(&gogadgets.Message{
+				Type:      gogadgets.UPDATE,
+				Location:  "living room",
+				Name:      "temperature",
+				Value:     gogadgets.Value{Value: 212.0, Units: "F"},
+				Timestamp: ts1,
+			})
+			x.Update(&gogadgets.Message{
+				Type:      gogadgets.UPDATE,
+				Location:  "tank",
+				Name:      "pump",
+				Value:     gogadgets.Value{Value: true},
+				Timestamp: ts1.Add(time.Second),
+			})
+			Expect(x.(*gogadgets.Recorder).Close()).To(BeNil())
+
+			d, err := ioutil.ReadFile(filepath.Join(tmp, "values.csv"))
+			Expect(err).To(BeNil())
+			Expect(string(d)).To(Equal(`time,location,name,value,units
+2026-10-17T12:00:00Z,living room,temperature,100,C
+2026-10-17T12:00:01Z,tank,pump,1,
+`))
+
+			d, err = ioutil.ReadFile(filepath.Join(tmp, "values.jsonl"))
+			Expect(err).To(BeNil())
+			Expect(string(d)).To(Equal(`{"time":"2026-10-17T12:00:00Z","location":"living room","name":"temperature","value":100,"units":"C"}
+{"time":"2026-10-17T12:00:01Z","location":"tank","name":"pump","value":true}
+`))
+
+			h, err := gogadgets.NewHistory(gogadgets.HistoryConfig{Path: filepath.Join(tmp, "store"), Retention: "876000h", Raw: "876000h"})
+			Expect(err).To(BeNil())
+			defer h.Close()
+			points, err := h.Query("tank pump", ts1, ts1.Add(time.Minute), 0)
+			Expect(err).To(BeNil())
+			Expect(points).To(HaveLen(1))
+			Expect(points[0].Avg).To(Equal(1.0))
+
+			Expect(posts).To(Equal([]string{
+				fmt.Sprintf("house,location=living\\ room,name=temperature,units=C value=100 %d\n", ts1.UnixNano()),
+				fmt.Sprintf("house,location=tank,name=pump value=1 %d\n", ts1.Add(time.Second).UnixNano()),
+			}))
+		})
+
+		It("won't start with a sink it doesn't know", func() {
+			_, err := gogadgets.NewRecorder(&gogadgets.Pin{
+				Args: map[string]interface{}{
+					"sinks": []interface{}{map[string]interface{}{"type": "mongo"}},
+				},
+			})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("with summaries", func() {
+		var (
+			lock  sync.Mutex
+			saved []gogadgets.Message
+			t0    time.Time
+		)
+
+		gogadgets.RegisterSink("memory", func(cfg gogadgets.SinkConfig) (gogadgets.Sink, error) {
+			return memorySink(func(msg gogadgets.Message) {
+				lock.Lock()
+				saved = append(saved, msg)
+				lock.Unlock()
+			}), nil
+		})
+
+		BeforeEach(func() {
+			lock.Lock()
+			saved = nil
+			lock.Unlock()
+			t0 = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+		})
+
+		getSaved := func() []gogadgets.Message {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]gogadgets.Message{}, saved...)
+		}
+
+		recorder := func(summarize string) *gogadgets.Recorder {
+			return newRecorder(fmt.Sprintf(`{"sinks": [{"type": "memory"}], "summarize": %s}`, summarize))
+		}
+
+		values := func(msgs []gogadgets.Message, t time.Time) map[string]interface{} {
+			out := map[string]interface{}{}
+			for _, msg := range msgs {
+				if msg.Timestamp.Equal(t) {
+					out[msg.Name] = msg.Value.Value
+				}
+			}
+			return out
+		}
+
+		It("saves the aggregations of each window", func() {
+			r := recorder(`{"lab thermometer": {"window": "1m", "aggregations": ["mean", "min", "max", "last", "count", "twmean"]}}`)
+			defer r.Close()
+			r.Update(stamped(sensorUpdate("lab", "thermometer", 10.0, "C"), t0))
+			r.Update(stamped(sensorUpdate("lab", "thermometer", 20.0, "C"), t0.Add(45*time.Second)))
+			r.Update(stamped(sensorUpdate("lab", "thermometer", 30.0, "C"), t0.Add(90*time.Second)))
+
+			//the window that just ended gets the last value too
+			Eventually(func() int { return len(getSaved()) }, 2*time.Second).Should(BeNumerically(">=", 18))
+			msgs := getSaved()
+			Expect(values(msgs, t0)).To(Equal(map[string]interface{}{
+				"thermometer":        15.0,
+				"thermometer min":    10.0,
+				"thermometer max":    20.0,
+				"thermometer last":   20.0,
+				"thermometer count":  2.0,
+				"thermometer twmean": 12.5,
+			}))
+			Expect(values(msgs, t0.Add(time.Minute))).To(Equal(map[string]interface{}{
+				"thermometer":        30.0,
+				"thermometer min":    30.0,
+				"thermometer max":    30.0,
+				"thermometer last":   30.0,
+				"thermometer count":  1.0,
+				"thermometer twmean": 25.0,
+			}))
+			Expect(msgs[0].Value.Units).To(Equal("C"))
+		})
+
+		It("saves how long an output was on", func() {
+			r := recorder(`{"lab pump": {"window": "1m"}, "lab thermometer": 1}`)
+			defer r.Close()
+			r.Update(stamped(sensorUpdate("lab", "pump", true, ""), t0))
+			r.Update(stamped(sensorUpdate("lab", "pump", false, ""), t0.Add(15*time.Second)))
+			r.Update(stamped(sensorUpdate("lab", "pump", true, ""), t0.Add(45*time.Second)))
+			r.Update(stamped(sensorUpdate("lab", "thermometer", 10.0, "C"), t0))
+			r.Update(stamped(sensorUpdate("lab", "thermometer", 20.0, "C"), t0.Add(45*time.Second)))
+
+			Eventually(func() map[string]interface{} {
+				return values(getSaved(), t0)
+			}, 2*time.Second).Should(Equal(map[string]interface{}{
+				"pump duty":   0.5,
+				"thermometer": 15.0,
+			}))
+		})
+
+		It("closes a window when the gadget doesn't send anything", func() {
+			r := recorder(`{"lab thermometer": {"window": "50ms", "aggregations": ["last", "count"]}}`)
+			defer r.Close()
+			r.Update(stamped(sensorUpdate("lab", "thermometer", 10.0, "C"), time.Now()))
+
+			Eventually(getSaved).Should(HaveLen(6))
+			for i, msg := range getSaved() {
+				if msg.Name == "thermometer last" {
+					Expect(msg.Value.Value).To(Equal(10.0))
+				} else if i > 1 {
+					Expect(msg.Value.Value).To(Equal(0.0))
+				}
+			}
+		})
+
+		It("won't start with an aggregation it doesn't know", func() {
+			_, err := gogadgets.NewRecorder(&gogadgets.Pin{
+				Args: map[string]interface{}{
+					"sinks":     []interface{}{map[string]interface{}{"type": "memory"}},
+					"summarize": map[string]interface{}{"lab thermometer": map[string]interface{}{"window": "1m", "aggregations": []string{"median"}}},
+				},
+			})
+			Expect(err).ToNot(BeNil())
+		})
+	})
 })
+
+type memorySink func(gogadgets.Message)
+
+func (m memorySink) Save(msg gogadgets.Message) error {
+	m(msg)
+	return nil
+}
+
+func (m memorySink) Close() error {
+	return nil
+}
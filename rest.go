@@ -0,0 +1,187 @@
+package gogadgets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cswank/gogadgets/rcl"
+	"github.com/cswank/rex"
+)
+
+//DeviceCommand is the (optional) body of a POST to
+///gadgets/locations/{location}/devices/{device}/on.  It is turned
+//into an RCL command, for example
+//
+//	{"value": 70, "units": "F"}               heat house to 70 F
+//	{"duration": "30m"}                       turn on lab led for 30 minutes
+//	{"condition": "tank volume >= 5 liters"}  turn on tank pump until tank volume >= 5 liters
+//
+//Command picks which of the device's on commands to use when it has
+//more than one (a thermostat can 'heat house' or 'cool house').
+type DeviceCommand struct {
+	Command   string   `json:"command,omitempty"`
+	Value     *float64 `json:"value,omitempty"`
+	Units     string   `json:"units,omitempty"`
+	Duration  string   `json:"duration,omitempty"`
+	Condition string   `json:"condition,omitempty"`
+}
+
+//DeviceInfo is what GET /gadgets/locations returns for each
+//device.
+type DeviceInfo struct {
+	Direction string   `json:"direction,omitempty"`
+	On        []string `json:"on,omitempty"`
+	Off       []string `json:"off,omitempty"`
+	Value     Value    `json:"value"`
+}
+
+//rcl turns the command into RCL, on is the on command of the
+//device it is for.
+func (d DeviceCommand) rcl(on string) (string, error) {
+	parts := []string{on}
+	if d.Value != nil {
+		parts = append(parts, "to", strconv.FormatFloat(*d.Value, 'f', -1, 64))
+		if d.Units != "" {
+			parts = append(parts, d.Units)
+		}
+	}
+	if d.Duration != "" {
+		t, err := time.ParseDuration(d.Duration)
+		if err != nil {
+			return "", err
+		}
+		if t <= 0 {
+			return "", fmt.Errorf("the duration has to be longer than 0, got %s", d.Duration)
+		}
+		parts = append(parts, "for", rclDuration(t))
+	}
+	if d.Condition != "" {
+		parts = append(parts, "until", d.Condition)
+	}
+	s := strings.Join(parts, " ")
+	_, err := rcl.Parse(s)
+	return s, err
+}
+
+//rclDuration writes d in the biggest unit that keeps it a whole
+//number (1 hours, 90 minutes, 2.5 seconds).
+func rclDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+	return fmt.Sprintf("%s seconds", strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+}
+
+//locations sends every location and its devices.
+func (s *Server) locations(w http.ResponseWriter, r *http.Request) {
+	l := map[string]map[string]DeviceInfo{}
+	s.statusLock.Lock()
+	for _, msg := range s.updates {
+		if msg.Type != UPDATE {
+			continue
+		}
+		d, ok := l[msg.Location]
+		if !ok {
+			d = map[string]DeviceInfo{}
+			l[msg.Location] = d
+		}
+		d[msg.Name] = DeviceInfo{
+			Direction: msg.Info.Direction,
+			On:        msg.Info.On,
+			Off:       msg.Info.Off,
+			Value:     msg.Value,
+		}
+	}
+	s.statusLock.Unlock()
+	s.writeJSON(w, l)
+}
+
+func (s *Server) types(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, GetTypes())
+}
+
+func (s *Server) deviceOn(w http.ResponseWriter, r *http.Request) {
+	s.deviceCommand(w, r, true)
+}
+
+func (s *Server) deviceOff(w http.ResponseWriter, r *http.Request) {
+	s.deviceCommand(w, r, false)
+}
+
+//deviceCommand turns a device on or off.  It responds like a POST to
+///gadgets: it can ?wait for the answer.
+func (s *Server) deviceCommand(w http.ResponseWriter, r *http.Request, on bool) {
+	vars := rex.Vars(r, "main")
+	s.statusLock.Lock()
+	dev, ok := s.updates[fmt.Sprintf("%s %s", vars["location"], vars["device"])]
+	s.statusLock.Unlock()
+	if !ok {
+		s.writeError(w, http.StatusNotFound, fmt.Errorf("there is no %s in %s", vars["device"], vars["location"]))
+		return
+	}
+
+	cmds := dev.Info.Off
+	if on {
+		cmds = dev.Info.On
+	}
+	if len(cmds) == 0 {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("%s %s can't be turned on or off", dev.Location, dev.Name))
+		return
+	}
+
+	var d DeviceCommand
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	body := cmds[0]
+	if on {
+		if d.Command != "" {
+			body = ""
+			for _, c := range cmds {
+				if c == d.Command {
+					body = c
+				}
+			}
+			if body == "" {
+				s.writeError(w, http.StatusBadRequest, fmt.Errorf("%s isn't one of %s", d.Command, strings.Join(cmds, ", ")))
+				return
+			}
+		}
+		var err error
+		if body, err = d.rcl(body); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	s.command(w, r, Message{
+		UUID:   GetUUID(),
+		Type:   COMMAND,
+		Sender: "client",
+		Body:   body,
+	})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.lg.Println(err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
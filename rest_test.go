@@ -0,0 +1,204 @@
+package gogadgets_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("rest", func() {
+	var (
+		addr string
+		in   chan gogadgets.Message
+		out  chan gogadgets.Message
+	)
+
+	BeforeEach(func() {
+		port := 1024 + rand.Intn(65535-1024)
+		addr = fmt.Sprintf("http://localhost:%d/gadgets", port)
+		s := gogadgets.NewServer("", "", port, &fakeLogger{})
+		in = make(chan gogadgets.Message)
+		out = make(chan gogadgets.Message)
+		go s.Start(out, in)
+		out <- gogadgets.Message{
+			Type:     gogadgets.UPDATE,
+			Sender:   "house thermostat",
+			Location: "house",
+			Name:     "thermostat",
+			Value:    gogadgets.Value{Value: false},
+			Info: gogadgets.Info{
+				Direction: "output",
+				On:        []string{"heat house", "cool house"},
+				Off:       []string{"turn off thermostat"},
+			},
+		}
+		out <- gogadgets.Message{
+			Type:     gogadgets.UPDATE,
+			Sender:   "house temperature",
+			Location: "house",
+			Name:     "temperature",
+			Value:    gogadgets.Value{Value: 68.5, Units: "F"},
+			Info:     gogadgets.Info{Direction: "input"},
+		}
+		Eventually(func() error {
+			r, err := http.Get(addr)
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		out <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+	})
+
+	post := func(u string, body interface{}) (int, map[string]string) {
+		buf := &bytes.Buffer{}
+		if body != nil {
+			Expect(json.NewEncoder(buf).Encode(body)).To(BeNil())
+		}
+		resp := make(chan *http.Response)
+		go func() {
+			defer GinkgoRecover()
+			r, err := http.Post(addr+u, "application/json", buf)
+			Expect(err).To(BeNil())
+			resp <- r
+		}()
+		var r *http.Response
+		select {
+		case r = <-resp:
+		case m := <-in:
+			r = <-resp
+			Expect(r.StatusCode).To(Equal(http.StatusOK))
+			r.Body.Close()
+			return r.StatusCode, map[string]string{"body": m.Body}
+		}
+		defer r.Body.Close()
+		var e map[string]string
+		json.NewDecoder(r.Body).Decode(&e)
+		return r.StatusCode, e
+	}
+
+	It("lists the locations and what their devices do", func() {
+		r, err := http.Get(addr + "/locations")
+		Expect(err).To(BeNil())
+		defer r.Body.Close()
+		var l map[string]map[string]gogadgets.DeviceInfo
+		Expect(json.NewDecoder(r.Body).Decode(&l)).To(BeNil())
+		Expect(l["house"]).To(HaveLen(2))
+		Expect(l["house"]["thermostat"].On).To(Equal([]string{"heat house", "cool house"}))
+		Expect(l["house"]["temperature"].Direction).To(Equal("input"))
+		Expect(l["house"]["temperature"].Value.Value).To(Equal(68.5))
+	})
+
+	It("turns a device on", func() {
+		status, m := post("/locations/house/devices/thermostat/on", nil)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(m["body"]).To(Equal("heat house"))
+	})
+
+	It("turns a device on with arguments", func() {
+		v := 70.0
+		status, m := post("/locations/house/devices/thermostat/on", gogadgets.DeviceCommand{
+			Command:  "cool house",
+			Value:    &v,
+			Units:    "F",
+			Duration: "90m",
+		})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(m["body"]).To(Equal("cool house to 70 F for 90 minutes"))
+
+		status, m = post("/locations/house/devices/thermostat/on", gogadgets.DeviceCommand{
+			Condition: "house temperature >= 72 F",
+		})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(m["body"]).To(Equal("heat house until house temperature >= 72 F"))
+	})
+
+	It("turns a device off", func() {
+		status, m := post("/locations/house/devices/thermostat/off", nil)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(m["body"]).To(Equal("turn off thermostat"))
+	})
+
+	It("says what is wrong with a bad command", func() {
+		status, e := post("/locations/house/devices/thermostat/on", gogadgets.DeviceCommand{Condition: "it's warm"})
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(e["error"]).To(ContainSubstring("expected a comparison"))
+
+		for _, d := range []string{"0s", "-5m"} {
+			status, e = post("/locations/house/devices/thermostat/on", gogadgets.DeviceCommand{Duration: d})
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(e["error"]).To(ContainSubstring("longer than 0"))
+		}
+
+		status, e = post("/locations/house/devices/thermostat/on", gogadgets.DeviceCommand{Command: "dance"})
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(e["error"]).To(ContainSubstring("heat house, cool house"))
+
+		status, _ = post("/locations/house/devices/temperature/on", nil)
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		status, _ = post("/locations/garage/devices/door/on", nil)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	It("sends the types of devices", func() {
+		r, err := http.Get(addr + "/types")
+		Expect(err).To(BeNil())
+		defer r.Body.Close()
+		var t map[string]gogadgets.ConfigHelper
+		Expect(json.NewDecoder(r.Body).Decode(&t)).To(BeNil())
+		Expect(t).To(HaveKey("thermostat"))
+		Expect(t["heater"].Units).To(ContainElement("F"))
+	})
+
+	It("serves an openapi spec", func() {
+		r, err := http.Get(addr + "/openapi.json")
+		Expect(err).To(BeNil())
+		defer r.Body.Close()
+		var spec struct {
+			OpenAPI string                 `json:"openapi"`
+			Paths   map[string]interface{} `json:"paths"`
+		}
+		Expect(json.NewDecoder(r.Body).Decode(&spec)).To(BeNil())
+		Expect(spec.OpenAPI).To(HavePrefix("3."))
+		for _, p := range []string{
+			"/gadgets",
+			"/gadgets/locations",
+			"/gadgets/locations/{location}/devices/{device}/on",
+			"/gadgets/locations/{location}/devices/{device}/off",
+			"/gadgets/types",
+			"/gadgets/stream",
+		} {
+			Expect(spec.Paths).To(HaveKey(p))
+		}
+	})
+
+	It("serves a dashboard", func() {
+		base := strings.TrimSuffix(addr, "/gadgets")
+		for u, t := range map[string]string{
+			"/dashboard":           "text/html",
+			"/dashboard/app.js":    "application/javascript",
+			"/dashboard/style.css": "text/css",
+		} {
+			r, err := http.Get(base + u)
+			Expect(err).To(BeNil())
+			d, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			Expect(err).To(BeNil())
+			Expect(r.StatusCode).To(Equal(http.StatusOK))
+			Expect(r.Header.Get("Content-Type")).To(HavePrefix(t))
+			Expect(d).ToNot(BeEmpty())
+		}
+	})
+})
@@ -0,0 +1,331 @@
+package gogadgets
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cswank/gogadgets/rcl"
+)
+
+//RuleConfig is one of the rules of a rules gadget.  When is one or
+//more conditions joined with and and or (and goes first, there are
+//no parentheses) and Then are the commands (RCL) that are sent when
+//it holds:
+//
+//	{
+//	    "when": "greenhouse temperature >= 12 C and greenhouse humidity < 80 %RH or greenhouse fan on",
+//	    "then": ["turn on front pump", "turn on back pump"],
+//	    "trigger": "edge",
+//	    "debounce": "30s",
+//	    "cooldown": "10m"
+//	}
+//
+//An edge rule (the default) sends its commands when the conditions
+//start to hold, a level rule sends them on every update while they
+//do.  Debounce is how long the conditions have to hold before they
+//count and Cooldown is how long to wait before the commands are
+//sent again (an edge that comes during the cooldown is sent once it
+//is over, if the conditions still hold).
+type RuleConfig struct {
+	When     string   `json:"when"`
+	Then     []string `json:"then"`
+	Trigger  string   `json:"trigger,omitempty"`
+	Debounce string   `json:"debounce,omitempty"`
+	Cooldown string   `json:"cooldown,omitempty"`
+}
+
+//Rules sends commands when the values of other gadgets meet the
+//conditions of its rules, so most of what used to take a custom
+//Gadgeter (see examples/greenhouse) can be done in the config:
+//
+//	{
+//	    "type": "rules",
+//	    "args": {
+//	        "rules": [
+//	            "when greenhouse temperature >= 12 C then turn on front pump",
+//	            "when greenhouse temperature < 10 C then turn off front pump"
+//	        ]
+//	    }
+//	}
+//
+//A rule can be a string like these or a RuleConfig.
+type Rules struct {
+	watcher
+	rules  []*rule
+	values map[string]Value
+	out    chan<- Message
+}
+
+type ruleCondition struct {
+	cond  *rcl.Condition
+	match comparitor
+}
+
+type rule struct {
+	RuleConfig
+	//any of the groups of conditions that all hold
+	when     [][]ruleCondition
+	sensors  map[string]bool
+	level    bool
+	debounce time.Duration
+	cooldown time.Duration
+	since    *time.Time
+	fired    bool
+	last     time.Time
+}
+
+func NewRules(config *GadgetConfig, options ...func(*Rules) error) (*Rules, error) {
+	r := &Rules{
+		watcher: newWatcher("rules"),
+		values:  map[string]Value{},
+	}
+	for _, opt := range options {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+
+	var args map[string]interface{}
+	if config != nil {
+		args = config.Args
+	}
+	cfgs, err := getRules(args["rules"])
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range cfgs {
+		x, err := newRule(cfg)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, x)
+		for s := range x.sensors {
+			r.watch(s)
+		}
+	}
+	return r, nil
+}
+
+//RulesTick sets how often the rules are checked between updates
+//(for debounces and cooldowns).
+func RulesTick(d time.Duration) func(*Rules) error {
+	return func(r *Rules) error {
+		return r.setTick(d)
+	}
+}
+
+//getRules reads the rules, strings are 'when <conditions> then
+//<command>'.
+func getRules(r interface{}) ([]RuleConfig, error) {
+	if r == nil {
+		return nil, nil
+	}
+	d, _ := json.Marshal(r)
+	var raw []json.RawMessage
+	if err := json.Unmarshal(d, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse the rules: %s", err)
+	}
+	cfgs := make([]RuleConfig, len(raw))
+	for i, item := range raw {
+		var s string
+		if err := json.Unmarshal(item, &s); err != nil {
+			if err := json.Unmarshal(item, &cfgs[i]); err != nil {
+				return nil, fmt.Errorf("could not parse rule: %s: %s", item, err)
+			}
+			continue
+		}
+		j := strings.Index(s, " then ")
+		if !strings.HasPrefix(s, "when ") || j == -1 {
+			return nil, fmt.Errorf("could not parse rule: %s: expected 'when <conditions> then <command>'", s)
+		}
+		cfgs[i] = RuleConfig{When: s[len("when "):j], Then: []string{s[j+len(" then "):]}}
+	}
+	return cfgs, nil
+}
+
+func newRule(cfg RuleConfig) (*rule, error) {
+	r := &rule{RuleConfig: cfg, sensors: map[string]bool{}}
+	if err := r.parseWhen(cfg.When); err != nil {
+		return nil, fmt.Errorf("could not parse rule: %s: %s", cfg.When, err)
+	}
+	if len(cfg.Then) == 0 {
+		return nil, fmt.Errorf("the rule %s has nothing to do", cfg.When)
+	}
+	for _, cmd := range cfg.Then {
+		if _, err := rcl.Parse(cmd); err != nil {
+			return nil, fmt.Errorf("could not parse rule: %s: %s", cfg.When, err)
+		}
+	}
+	switch cfg.Trigger {
+	case "", "edge":
+	case "level":
+		r.level = true
+	default:
+		return nil, fmt.Errorf("unknown trigger for rule %s: %s", cfg.When, cfg.Trigger)
+	}
+	var err error
+	if cfg.Debounce != "" {
+		if r.debounce, err = time.ParseDuration(cfg.Debounce); err != nil {
+			return nil, fmt.Errorf("could not parse the debounce of rule %s: %s", cfg.When, err)
+		}
+	}
+	if cfg.Cooldown != "" {
+		if r.cooldown, err = time.ParseDuration(cfg.Cooldown); err != nil {
+			return nil, fmt.Errorf("could not parse the cooldown of rule %s: %s", cfg.When, err)
+		}
+	}
+	return r, nil
+}
+
+//parseWhen splits the conditions on or, then and.
+func (r *rule) parseWhen(s string) error {
+	for _, group := range splitWords(s, "or") {
+		var all []ruleCondition
+		for _, c := range splitWords(group, "and") {
+			cond, err := parseCondition(c)
+			if err != nil {
+				return err
+			}
+			cmp, err := getCompare(cond.Operator, cond.Value, cond.Units)
+			if err != nil {
+				return err
+			}
+			all = append(all, ruleCondition{cond: cond, match: cmp})
+			r.sensors[cond.Sensor] = true
+		}
+		r.when = append(r.when, all)
+	}
+	return nil
+}
+
+//splitWords splits s on a word (not on a word that has it in it).
+func splitWords(s, word string) []string {
+	var parts []string
+	var part []string
+	for _, w := range strings.Fields(s) {
+		if w == word {
+			parts = append(parts, strings.Join(part, " "))
+			part = nil
+			continue
+		}
+		part = append(part, w)
+	}
+	return append(parts, strings.Join(part, " "))
+}
+
+//holds is true if all of the conditions in any of the groups
+//match the latest values.  A sensor that hasn't sent an update
+//doesn't match anything.
+func (r *rule) holds(values map[string]Value) bool {
+	for _, all := range r.when {
+		ok := true
+		for _, c := range all {
+			v, seen := values[c.cond.Sensor]
+			if !seen || !c.match(&v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+//check returns true when the commands of the rule should be sent.
+//update is false when it is checked by the ticker (which only
+//finishes debounces and cooldowns).
+func (r *rule) check(values map[string]Value, now time.Time, update bool) bool {
+	if !r.holds(values) {
+		r.since = nil
+		r.fired = false
+		return false
+	}
+	if r.since == nil {
+		r.since = &now
+	}
+	if now.Sub(*r.since) < r.debounce {
+		return false
+	}
+	if !r.last.IsZero() && now.Sub(r.last) < r.cooldown {
+		return false
+	}
+	if r.fired && (!r.level || !update) {
+		return false
+	}
+	r.fired = true
+	r.last = now
+	return true
+}
+
+//checkRules returns the commands of the rules that none of the
+//gadgets of this node handle.  They may be for a gadget on another
+//node (or a custom Gadgeter), so they are only worth a warning
+//(turn on front pmup is probably a typo).
+func checkRules(gadgets []Gadgeter) []string {
+	var unknown []string
+	for _, x := range gadgets {
+		r, ok := x.(*Rules)
+		if !ok {
+			continue
+		}
+		for _, rl := range r.rules {
+			for _, cmd := range rl.Then {
+				if !handled(cmd, gadgets) {
+					unknown = append(unknown, cmd)
+				}
+			}
+		}
+	}
+	return unknown
+}
+
+func handled(cmd string, gadgets []Gadgeter) bool {
+	for _, x := range gadgets {
+		if g, ok := x.(*Gadget); ok && g.handles(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+//Start sends the commands of the rules to out until shutdown.
+func (r *Rules) Start(in <-chan Message, out chan<- Message) {
+	r.out = out
+	r.run(in, r.update, r.check)
+}
+
+//update keeps the latest value of every sensor, the rules are
+//checked against all of them.
+func (r *Rules) update(msg Message, now time.Time) {
+	r.values[msg.Sender] = msg.Value
+	for _, x := range r.rules {
+		if x.sensors[msg.Sender] && x.check(r.values, now, true) {
+			r.send(x)
+		}
+	}
+}
+
+//check sends the rules that have finished their debounce or
+//cooldown since the last update.
+func (r *Rules) check(now time.Time) {
+	for _, x := range r.rules {
+		if x.check(r.values, now, false) {
+			r.send(x)
+		}
+	}
+}
+
+func (r *Rules) send(x *rule) {
+	for _, cmd := range x.Then {
+		r.out <- Message{
+			Type:   COMMAND,
+			Sender: r.uid,
+			UUID:   GetUUID(),
+			Body:   cmd,
+		}
+	}
+}
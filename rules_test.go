@@ -0,0 +1,133 @@
+package gogadgets_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules", func() {
+	var (
+		in  chan gogadgets.Message
+		out chan gogadgets.Message
+	)
+
+	BeforeEach(func() {
+		in = make(chan gogadgets.Message)
+		out = make(chan gogadgets.Message, 10)
+	})
+
+	start := func(rules string) {
+		r, err := gogadgets.NewRules(gadgetConfig("rules", fmt.Sprintf(`{"rules": %s}`, rules)), gogadgets.RulesTick(10*time.Millisecond))
+		Expect(err).To(BeNil())
+		go r.Start(in, out)
+	}
+
+	command := func() string {
+		var msg gogadgets.Message
+		Eventually(out).Should(Receive(&msg))
+		Expect(msg.Type).To(Equal(gogadgets.COMMAND))
+		Expect(msg.Sender).To(Equal("rules"))
+		return msg.Body
+	}
+
+	It("sends a command when a condition starts to hold", func() {
+		start(`["when greenhouse temperature >= 12 C then turn on front pump"]`)
+		defer stop(in)
+
+		in <- sensorUpdate("greenhouse", "temperature", 10.0, "C")
+		Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+		in <- sensorUpdate("greenhouse", "temperature", 55.0, "F")
+		Expect(command()).To(Equal("turn on front pump"))
+		in <- sensorUpdate("greenhouse", "temperature", 14.0, "C")
+		Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+		in <- sensorUpdate("greenhouse", "temperature", 10.0, "C")
+		in <- sensorUpdate("greenhouse", "temperature", 13.0, "C")
+		Expect(command()).To(Equal("turn on front pump"))
+	})
+
+	It("combines conditions with and and or", func() {
+		start(`[{"when": "greenhouse temperature >= 12 C and greenhouse humidity < 80 %RH or greenhouse fan on", "then": ["turn on front pump", "turn on back pump"]}]`)
+		defer stop(in)
+
+		in <- sensorUpdate("greenhouse", "temperature", 13.0, "C")
+		Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+		in <- sensorUpdate("greenhouse", "humidity", 70.0, "%RH")
+		Expect(command()).To(Equal("turn on front pump"))
+		Expect(command()).To(Equal("turn on back pump"))
+
+		in <- sensorUpdate("greenhouse", "temperature", 11.0, "C")
+		in <- sensorUpdate("greenhouse", "fan", true, "")
+		Expect(command()).To(Equal("turn on front pump"))
+		Expect(command()).To(Equal("turn on back pump"))
+	})
+
+	It("waits for the debounce", func() {
+		start(`[{"when": "front door open == true", "then": ["turn on porch light"], "debounce": "100ms"}]`)
+		defer stop(in)
+
+		in <- sensorUpdate("front door", "open", true, "")
+		in <- sensorUpdate("front door", "open", false, "")
+		Consistently(out, 150*time.Millisecond).ShouldNot(Receive())
+		in <- sensorUpdate("front door", "open", true, "")
+		Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+		Expect(command()).To(Equal("turn on porch light"))
+	})
+
+	It("sends level commands on every update but not during the cooldown", func() {
+		start(`[{"when": "tank volume < 5 liters", "then": ["turn on tank valve for 1 minute"], "trigger": "level", "cooldown": "100ms"}]`)
+		defer stop(in)
+
+		in <- sensorUpdate("tank", "volume", 4.0, "liters")
+		Expect(command()).To(Equal("turn on tank valve for 1 minute"))
+		in <- sensorUpdate("tank", "volume", 3.0, "liters")
+		Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+		time.Sleep(60 * time.Millisecond)
+		in <- sensorUpdate("tank", "volume", 3.0, "liters")
+		Expect(command()).To(Equal("turn on tank valve for 1 minute"))
+	})
+
+	It("sends an edge that came during the cooldown once it is over", func() {
+		start(`[{"when": "greenhouse temperature >= 12 C", "then": ["turn on front pump"], "cooldown": "150ms"}]`)
+		defer stop(in)
+
+		in <- sensorUpdate("greenhouse", "temperature", 13.0, "C")
+		Expect(command()).To(Equal("turn on front pump"))
+		in <- sensorUpdate("greenhouse", "temperature", 10.0, "C")
+		in <- sensorUpdate("greenhouse", "temperature", 13.0, "C")
+		Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+		Expect(command()).To(Equal("turn on front pump"))
+	})
+
+	It("won't start with rules it can't parse", func() {
+		for _, rules := range []string{
+			`["greenhouse temperature >= 12 C then turn on front pump"]`,
+			`["when greenhouse temperature is warm then turn on front pump"]`,
+			`["when greenhouse temperature >= 12 C then turn on"]`,
+			`[{"when": "greenhouse temperature >= 12 C"}]`,
+			`[{"when": "greenhouse temperature >= 12 C", "then": ["turn on front pump"], "trigger": "sometimes"}]`,
+			`[{"when": "greenhouse temperature >= 12 C", "then": ["turn on front pump"], "cooldown": "a while"}]`,
+		} {
+			_, err := gogadgets.NewRules(gadgetConfig("rules", fmt.Sprintf(`{"rules": %s}`, rules)))
+			Expect(err).ToNot(BeNil(), rules)
+		}
+	})
+
+	It("is made from a gadget config", func() {
+		g, err := gogadgets.NewGadget(&gogadgets.GadgetConfig{
+			Type: "rules",
+			Args: map[string]interface{}{
+				"rules": []interface{}{"when greenhouse temperature >= 12 C then turn on front pump"},
+			},
+		})
+		Expect(err).To(BeNil())
+		Expect(g.GetUID()).To(Equal("rules"))
+		s, ok := g.(gogadgets.Subscriber)
+		Expect(ok).To(BeTrue())
+		Expect(s.Subscriptions().Match(&gogadgets.Message{Type: gogadgets.UPDATE, Sender: "greenhouse temperature"})).To(BeTrue())
+		Expect(s.Subscriptions().Match(&gogadgets.Message{Type: gogadgets.UPDATE, Sender: "greenhouse humidity"})).To(BeFalse())
+	})
+})
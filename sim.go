@@ -0,0 +1,298 @@
+package gogadgets
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+//Sim is the in-memory hardware used by every pin whose platform
+//is "sim".  Tests and demos drive it directly, for example:
+//
+//	gogadgets.Sim.SetTemperature("28-0000041cb544", 19.5)
+//	gogadgets.Sim.SetPin("8", "9", true)
+//	gogadgets.Sim.Pulse("8", "10")
+//	on := gogadgets.Sim.Pin("8", "11")
+//
+//Pins are addressed by the port and pin from the config (port is
+//empty for Raspberry Pi pins) and thermometers by their 1-wire id.
+var Sim = NewSimPlatform()
+
+//SimPlatform stands in for GPIO, PWM and 1-wire hardware so that a
+//whole gadgets system can run on a laptop or CI server.
+type SimPlatform struct {
+	lock  sync.Mutex
+	pins  map[string]*simPin
+	pwms  map[string]float64
+	temps map[string]float64
+}
+
+type simPin struct {
+	value bool
+	edge  string
+	edges chan bool
+}
+
+func NewSimPlatform() *SimPlatform {
+	return &SimPlatform{
+		pins:  map[string]*simPin{},
+		pwms:  map[string]float64{},
+		temps: map[string]float64{},
+	}
+}
+
+//Reset forgets all simulated devices.
+func (s *SimPlatform) Reset() {
+	s.lock.Lock()
+	s.pins = map[string]*simPin{}
+	s.pwms = map[string]float64{}
+	s.temps = map[string]float64{}
+	s.lock.Unlock()
+}
+
+//SetPin sets the level of a pin.  Any switch or flow meter
+//waiting on the pin sees the edge.
+func (s *SimPlatform) SetPin(port, pin string, v bool) {
+	s.set(simKey(port, pin), v)
+}
+
+//Pin returns the level of a pin (an output pin is true when it
+//has been turned on).
+func (s *SimPlatform) Pin(port, pin string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.pins[simKey(port, pin)]
+	return ok && p.value
+}
+
+//Pulse raises and then lowers a pin, which is what a flow meter
+//does for each unit of volume that passes through it.
+func (s *SimPlatform) Pulse(port, pin string) {
+	s.SetPin(port, pin, true)
+	s.SetPin(port, pin, false)
+}
+
+//SetTemperature sets the temperature (in C) that a 1-wire
+//thermometer will report.
+func (s *SimPlatform) SetTemperature(id string, c float64) {
+	s.lock.Lock()
+	s.temps[id] = c
+	s.lock.Unlock()
+}
+
+//PWM returns the duty cycle (in %) of a pwm pin, 0 if it is off.
+func (s *SimPlatform) PWM(port, pin string) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pwms[simKey(port, pin)]
+}
+
+//GPIO returns a simulated gpio for the pin.
+func (s *SimPlatform) GPIO(pin *Pin) *SimGPIO {
+	if pin.Direction == "" {
+		pin.Direction = "out"
+	}
+	key := simKey(pin.Port, pin.Pin)
+	s.lock.Lock()
+	p, ok := s.pins[key]
+	if !ok {
+		p = &simPin{edges: make(chan bool, 16)}
+		s.pins[key] = p
+	}
+	if pin.Direction == "in" {
+		p.edge = pin.Edge
+	} else {
+		p.value = false
+	}
+	s.lock.Unlock()
+	return &SimGPIO{sim: s, key: key, pin: p}
+}
+
+//PWMDevice returns a simulated pwm for the pin.
+func (s *SimPlatform) PWMDevice(pin *Pin) *SimPWM {
+	key := simKey(pin.Port, pin.Pin)
+	s.lock.Lock()
+	s.pwms[key] = 0.0
+	s.lock.Unlock()
+	return &SimPWM{sim: s, key: key}
+}
+
+func (s *SimPlatform) set(key string, v bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.pins[key]
+	if !ok {
+		p = &simPin{edges: make(chan bool, 16)}
+		s.pins[key] = p
+	}
+	if p.value == v {
+		return
+	}
+	p.value = v
+	if (v && p.edge == "falling") || (!v && p.edge == "rising") {
+		return
+	}
+	select {
+	case p.edges <- v:
+	default:
+	}
+}
+
+//oneWire returns the temperature in the same format as the
+//w1_slave file in sysfs.
+func (s *SimPlatform) oneWire(id string) ([]byte, error) {
+	s.lock.Lock()
+	c, ok := s.temps[id]
+	s.lock.Unlock()
+	if !ok {
+		return nil, errors.New("no such 1-wire device: " + id)
+	}
+	return []byte(fmt.Sprintf(
+		"00 00 00 00 00 00 00 00 00 : crc=00 YES\n00 00 00 00 00 00 00 00 00 t=%d\n",
+		int(math.Floor(c*1000.0+0.5)),
+	)), nil
+}
+
+func simKey(port, pin string) string {
+	return fmt.Sprintf("%s:%s", port, pin)
+}
+
+//SimGPIO is a DigitalPin backed by SimPlatform.
+type SimGPIO struct {
+	sim *SimPlatform
+	key string
+	pin *simPin
+}
+
+func (g *SimGPIO) Commands(location, name string) *Commands {
+	return nil
+}
+
+func (g *SimGPIO) Config() ConfigHelper {
+	return ConfigHelper{
+		PinType: "gpio",
+		Pins:    Pins["gpio"],
+	}
+}
+
+func (g *SimGPIO) Update(msg *Message) bool {
+	return false
+}
+
+func (g *SimGPIO) On(val *Value) error {
+	g.sim.set(g.key, true)
+	return nil
+}
+
+func (g *SimGPIO) Off() error {
+	g.sim.set(g.key, false)
+	return nil
+}
+
+func (g *SimGPIO) Status() map[string]bool {
+	g.sim.lock.Lock()
+	defer g.sim.lock.Unlock()
+	return map[string]bool{"gpio": g.pin.value}
+}
+
+func (g *SimGPIO) Wait() (bool, error) {
+	return <-g.pin.edges, nil
+}
+
+//SimPWM is a pwm OutputDevice backed by SimPlatform.
+type SimPWM struct {
+	sim    *SimPlatform
+	key    string
+	status bool
+}
+
+func (p *SimPWM) Commands(location, name string) *Commands {
+	return nil
+}
+
+func (p *SimPWM) Config() ConfigHelper {
+	pwm := &PWM{}
+	return pwm.Config()
+}
+
+func (p *SimPWM) Update(msg *Message) bool {
+	return false
+}
+
+func (p *SimPWM) On(val *Value) error {
+	duty := 100.0
+	if val != nil && val.Units == "%" {
+		if d, ok := val.Value.(float64); ok {
+			duty = math.Abs(d)
+		}
+	}
+	p.setDuty(duty)
+	p.status = true
+	return nil
+}
+
+func (p *SimPWM) Off() error {
+	p.setDuty(0.0)
+	p.status = false
+	return nil
+}
+
+func (p *SimPWM) Status() map[string]bool {
+	return map[string]bool{"pwm": p.status}
+}
+
+func (p *SimPWM) setDuty(d float64) {
+	p.sim.lock.Lock()
+	p.sim.pwms[p.key] = d
+	p.sim.lock.Unlock()
+}
+
+//SimThermalPlant is a model of something being heated (a kettle
+//of water for example): a first order lag plus dead time.  With
+//the output at 100% the temperature heads towards Ambient + Gain
+//and gets about 63% of the way there every TimeConstant.  Changes
+//to the output don't start to show up until DeadTime later.  Use
+//it to try out a pid (and its autotune) without any hardware:
+//
+//	plant := &gogadgets.SimThermalPlant{
+//		Ambient:      20.0,
+//		Gain:         100.0,
+//		TimeConstant: 10 * time.Minute,
+//		DeadTime:     30 * time.Second,
+//		Temperature:  20.0,
+//	}
+//	temperature := plant.Step(pid.Output(), time.Second)
+type SimThermalPlant struct {
+	Ambient      float64
+	Gain         float64
+	TimeConstant time.Duration
+	DeadTime     time.Duration
+	Temperature  float64
+	elapsed      time.Duration
+	inputs       []simInput
+}
+
+type simInput struct {
+	t time.Duration
+	v float64
+}
+
+//Step applies output (in %) for dt and returns the new
+//temperature.
+func (p *SimThermalPlant) Step(output float64, dt time.Duration) float64 {
+	p.inputs = append(p.inputs, simInput{t: p.elapsed, v: output})
+	p.elapsed += dt
+	cutoff := p.elapsed - p.DeadTime
+	for len(p.inputs) > 1 && p.inputs[1].t <= cutoff {
+		p.inputs = p.inputs[1:]
+	}
+	var u float64
+	if p.inputs[0].t <= cutoff {
+		u = p.inputs[0].v
+	}
+	target := p.Ambient + p.Gain*u/100.0
+	p.Temperature += (target - p.Temperature) * dt.Seconds() / p.TimeConstant.Seconds()
+	return p.Temperature
+}
@@ -0,0 +1,217 @@
+package gogadgets_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sim", func() {
+	BeforeEach(func() {
+		gogadgets.Sim.Reset()
+	})
+
+	Describe("devices", func() {
+		It("turns a gpio on and off", func() {
+			g, err := gogadgets.NewGPIO(&gogadgets.Pin{Platform: "sim", Port: "8", Pin: "11"})
+			Expect(err).To(BeNil())
+			Expect(g.On(nil)).To(BeNil())
+			Expect(gogadgets.Sim.Pin("8", "11")).To(BeTrue())
+			Expect(g.Status()["gpio"]).To(BeTrue())
+			Expect(g.Off()).To(BeNil())
+			Expect(gogadgets.Sim.Pin("8", "11")).To(BeFalse())
+		})
+
+		It("runs the sub-pins of a motor on the platform of its pin", func() {
+			m, err := gogadgets.NewMotor(&gogadgets.Pin{
+				Platform: "sim",
+				Pins: map[string]gogadgets.Pin{
+					"gpio_a": {Port: "8", Pin: "11"},
+					"gpio_b": {Port: "8", Pin: "12"},
+					"pwm":    {Port: "8", Pin: "13"},
+				},
+			})
+			Expect(err).To(BeNil())
+			Expect(m.On(&gogadgets.Value{Value: 50.0, Units: "%"})).To(BeNil())
+			Expect(gogadgets.Sim.Pin("8", "11")).To(BeTrue())
+			Expect(gogadgets.Sim.Pin("8", "12")).To(BeFalse())
+		})
+
+		It("drives a switch", func() {
+			s, err := gogadgets.NewSwitch(&gogadgets.Pin{
+				Platform: "sim",
+				Port:     "8",
+				Pin:      "9",
+				Edge:     "both",
+				Value:    true,
+			})
+			Expect(err).To(BeNil())
+			in := make(chan gogadgets.Message)
+			out := make(chan gogadgets.Value)
+			go s.Start(in, out)
+			val := <-out
+			Expect(val.Value).To(BeFalse())
+			gogadgets.Sim.SetPin("8", "9", true)
+			val = <-out
+			Expect(val.Value).To(BeTrue())
+			gogadgets.Sim.SetPin("8", "9", false)
+			val = <-out
+			Expect(val.Value).To(BeFalse())
+		})
+
+		It("only reports the configured edge", func() {
+			g, err := gogadgets.NewGPIO(&gogadgets.Pin{
+				Platform:  "sim",
+				Port:      "8",
+				Pin:       "9",
+				Direction: "in",
+				Edge:      "rising",
+			})
+			Expect(err).To(BeNil())
+			gogadgets.Sim.Pulse("8", "9")
+			gogadgets.Sim.Pulse("8", "9")
+			v, err := g.Wait()
+			Expect(err).To(BeNil())
+			Expect(v).To(BeTrue())
+			v, err = g.Wait()
+			Expect(err).To(BeNil())
+			Expect(v).To(BeTrue())
+		})
+
+		It("reads an injected temperature", func() {
+			gogadgets.Sim.SetTemperature("28-fake", 19.812)
+			t, err := gogadgets.NewThermometer(&gogadgets.Pin{
+				Platform:  "sim",
+				OneWireId: "28-fake",
+				Units:     "F",
+				Sleep:     10 * time.Millisecond,
+			})
+			Expect(err).To(BeNil())
+			in := make(chan gogadgets.Message)
+			out := make(chan gogadgets.Value)
+			go t.Start(in, out)
+			val := <-out
+			Expect(val.Value).To(BeNumerically("~", 67.66, 0.01))
+		})
+
+		It("measures flow from pulses", func() {
+			f, err := gogadgets.NewFlowMeter(&gogadgets.Pin{
+				Platform: "sim",
+				Port:     "8",
+				Pin:      "10",
+				Units:    "liters/second",
+				Args:     map[string]interface{}{"min_span": 0.001},
+			})
+			Expect(err).To(BeNil())
+			f.(*gogadgets.FlowMeter).Value = 1.0
+			in := make(chan gogadgets.Message)
+			out := make(chan gogadgets.Value)
+			go f.Start(in, out)
+			<-out
+			gogadgets.Sim.Pulse("8", "10")
+			time.Sleep(100 * time.Millisecond)
+			gogadgets.Sim.Pulse("8", "10")
+			val := <-out
+			Expect(val.Value).To(BeNumerically("~", 10.0, 2.0))
+		})
+
+		It("sets the pwm duty", func() {
+			p, err := gogadgets.NewPWM(&gogadgets.Pin{Platform: "sim", Port: "8", Pin: "13"})
+			Expect(err).To(BeNil())
+			Expect(p.On(&gogadgets.Value{Value: 40.0, Units: "%"})).To(BeNil())
+			Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(40.0))
+			Expect(p.Off()).To(BeNil())
+			Expect(gogadgets.Sim.PWM("8", "13")).To(Equal(0.0))
+		})
+	})
+
+	Describe("examples", func() {
+		var (
+			port  int
+			input chan gogadgets.Message
+		)
+
+		start := func(pth string) *gogadgets.Config {
+			cfg := gogadgets.GetConfig(pth)
+			cfg.Platform = "sim"
+			cfg.Master = ""
+			cfg.Host = ""
+			cfg.Port = port
+			cfg.Logger = &fakeLogger{}
+			for i := range cfg.Gadgets {
+				cfg.Gadgets[i].Pin.Sleep = 10 * time.Millisecond
+			}
+			a := gogadgets.NewApp(cfg)
+			go a.GoStart(input)
+			return cfg
+		}
+
+		send := func(cmd string) {
+			input <- gogadgets.Message{
+				UUID:   gogadgets.GetUUID(),
+				Sender: "the test",
+				Type:   gogadgets.COMMAND,
+				Body:   cmd,
+			}
+		}
+
+		BeforeEach(func() {
+			port = 1024 + rand.Intn(65535-1024)
+			input = make(chan gogadgets.Message)
+		})
+
+		It("runs the furnace", func() {
+			gogadgets.Sim.SetTemperature("28-0000041cb544", 15.0)
+			start("examples/furnace/config.json")
+			time.Sleep(100 * time.Millisecond)
+			send("heat home to 70 F")
+			Eventually(func() bool {
+				return gogadgets.Sim.Pin("", "11")
+			}).Should(BeTrue())
+			Expect(gogadgets.Sim.Pin("", "13")).To(BeFalse())
+			send("turn off furnace")
+			Eventually(func() bool {
+				return gogadgets.Sim.Pin("", "11")
+			}).Should(BeFalse())
+		})
+
+		It("runs the greenhouse", func() {
+			gogadgets.Sim.SetTemperature("28-00000479f8c6", 20.0)
+			start("examples/greenhouse/config.json")
+			send("turn on bed 1 pump")
+			Eventually(func() bool {
+				return gogadgets.Sim.Pin("8", "12")
+			}).Should(BeTrue())
+
+			gogadgets.Sim.SetPin("8", "9", true)
+			Eventually(func() interface{} {
+				r, err := http.Get(fmt.Sprintf("http://localhost:%d/gadgets/values", port))
+				if err != nil {
+					return nil
+				}
+				defer r.Body.Close()
+				var v map[string]map[string]gogadgets.Value
+				json.NewDecoder(r.Body).Decode(&v)
+				return []interface{}{v["greenhouse"]["temperature"].Value, v["bed 1"]["switch"].Value}
+			}).Should(Equal([]interface{}{20.0, 1.0}))
+		})
+
+		It("runs the sprinklers", func() {
+			start("examples/sprinklers/config.json")
+			send("turn on front yard sprinklers for 0.1 seconds")
+			Eventually(func() bool {
+				return gogadgets.Sim.Pin("8", "7")
+			}).Should(BeTrue())
+			Eventually(func() bool {
+				return gogadgets.Sim.Pin("8", "7")
+			}).Should(BeFalse())
+			Expect(gogadgets.Sim.Pin("8", "11")).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,362 @@
+package gogadgets
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Sink is somewhere the recorder saves the updates it gets.  The
+//value of msg has already been converted (and summarized) by the
+//recorder.
+type Sink interface {
+	Save(msg Message) error
+	Close() error
+}
+
+//BatchSink is a sink that can save many values at once (a spool
+//sends them this way when the sink is back up).
+type BatchSink interface {
+	Sink
+	SaveBatch(msgs []Message) error
+}
+
+//SinkConfig is one of the sinks in the "sinks" arg of a
+//recorder.  Type picks the sink and the other fields are used by
+//the sinks that need them:
+//
+//	{"type": "csv", "path": "/var/lib/gogadgets/values.csv"}
+//	{"type": "jsonl", "path": "/var/lib/gogadgets/values.jsonl"}
+//	{"type": "store", "path": "/var/lib/gogadgets/recorder", "retention": "720h"}
+//	{"type": "influx", "url": "http://localhost:8086/write?db=gadgets", "token": "...", "measurement": "gadgets"}
+//	{"type": "quimby", "url": "https://quimby/api/.../locations/%s/devices/%s/datapoints", "token": "..."}
+//
+//Spool (a directory) keeps the values that couldn't be saved on
+//disk until the sink takes them again (see spool).  Retry is how
+//long to wait before trying again the first time (1s by default,
+//it doubles up to 5 minutes).
+type SinkConfig struct {
+	Type        string `json:"type"`
+	Path        string `json:"path,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Token       string `json:"token,omitempty"`
+	Measurement string `json:"measurement,omitempty"`
+	Retention   string `json:"retention,omitempty"`
+	Raw         string `json:"raw,omitempty"`
+	Resolution  string `json:"resolution,omitempty"`
+	Segment     string `json:"segment,omitempty"`
+	Spool       string `json:"spool,omitempty"`
+	Retry       string `json:"retry,omitempty"`
+}
+
+var (
+	sinksLock sync.Mutex
+	sinks     = map[string]func(SinkConfig) (Sink, error){
+		"csv":    newCSVSink,
+		"jsonl":  newJSONLSink,
+		"store":  newStoreSink,
+		"influx": newInfluxSink,
+		"quimby": newQuimbySink,
+	}
+)
+
+//RegisterSink adds a type of sink that recorders can use.
+func RegisterSink(name string, f func(SinkConfig) (Sink, error)) {
+	sinksLock.Lock()
+	sinks[name] = f
+	sinksLock.Unlock()
+}
+
+//NewSink makes the sink that cfg describes.
+func NewSink(cfg SinkConfig) (Sink, error) {
+	sinksLock.Lock()
+	f, ok := sinks[cfg.Type]
+	sinksLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown recorder sink: %s", cfg.Type)
+	}
+	s, err := f(cfg)
+	if err != nil || cfg.Spool == "" {
+		return s, err
+	}
+	sp, err := newSpool(s, cfg.Spool, cfg.Retry)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return sp, nil
+}
+
+//sinkTime is when the value of msg was read.
+func sinkTime(msg Message) time.Time {
+	if msg.Timestamp.IsZero() {
+		return time.Now().UTC()
+	}
+	return msg.Timestamp
+}
+
+//fileSink appends a line for every value to a file.
+type fileSink struct {
+	lock sync.Mutex
+	f    *os.File
+	line func(Message) ([]byte, error)
+}
+
+func openFileSink(path string) (*os.File, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the recorder sink needs a path")
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+func (s *fileSink) Save(msg Message) error {
+	d, err := s.line(msg)
+	if err != nil || d == nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.f.Write(d)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.f.Close()
+}
+
+//newCSVSink writes time,location,name,value,units lines.  On and
+//off are written as 1 and 0.
+func newCSVSink(cfg SinkConfig) (Sink, error) {
+	f, err := openFileSink(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+		f.WriteString("time,location,name,value,units\n")
+	}
+	return &fileSink{f: f, line: csvLine}, nil
+}
+
+func csvLine(msg Message) ([]byte, error) {
+	v, ok := msg.Value.ToFloat()
+	if !ok {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		sinkTime(msg).Format(time.RFC3339Nano),
+		msg.Location,
+		msg.Name,
+		strconv.FormatFloat(v, 'f', -1, 64),
+		msg.Value.Units,
+	})
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+//jsonLine is what the jsonl sink writes for each value.
+type jsonLine struct {
+	Time     time.Time   `json:"time"`
+	Location string      `json:"location"`
+	Name     string      `json:"name"`
+	Value    interface{} `json:"value"`
+	Units    string      `json:"units,omitempty"`
+}
+
+//newJSONLSink writes a json object per line.  Values are written
+//as they are (so on and off are true and false).
+func newJSONLSink(cfg SinkConfig) (Sink, error) {
+	f, err := openFileSink(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f, line: jsonlLine}, nil
+}
+
+func jsonlLine(msg Message) ([]byte, error) {
+	if msg.Value.Value == nil {
+		return nil, nil
+	}
+	d, err := json.Marshal(jsonLine{
+		Time:     sinkTime(msg),
+		Location: msg.Location,
+		Name:     msg.Name,
+		Value:    msg.Value.Value,
+		Units:    msg.Value.Units,
+	})
+	return append(d, '\n'), err
+}
+
+//storeSink saves the values in a History (see HistoryConfig for
+//the retention, raw, resolution and segment settings).
+type storeSink struct {
+	h *History
+}
+
+func newStoreSink(cfg SinkConfig) (Sink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("the recorder sink needs a path")
+	}
+	h, err := NewHistory(HistoryConfig{
+		Path:       cfg.Path,
+		Retention:  cfg.Retention,
+		Raw:        cfg.Raw,
+		Resolution: cfg.Resolution,
+		Segment:    cfg.Segment,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &storeSink{h: h}, nil
+}
+
+func (s *storeSink) Save(msg Message) error {
+	v, ok := msg.Value.ToFloat()
+	if !ok {
+		return nil
+	}
+	return s.h.Add(fmt.Sprintf("%s %s", msg.Location, msg.Name), sinkTime(msg), v)
+}
+
+func (s *storeSink) Close() error {
+	return s.h.Close()
+}
+
+//postSink posts a body for every value, any status other than a
+//2xx is an error.
+type postSink struct {
+	client *http.Client
+	token  string
+}
+
+func (s *postSink) post(u, contentType string, body []byte) error {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+	r, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		d, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("%s: %s %s", u, r.Status, strings.TrimSpace(string(d)))
+	}
+	return nil
+}
+
+func (s *postSink) Close() error {
+	return nil
+}
+
+//influxSink writes the values to InfluxDB with the line protocol,
+//location, name and units are tags and the value is a field.
+type influxSink struct {
+	postSink
+	url         string
+	measurement string
+}
+
+func newInfluxSink(cfg SinkConfig) (Sink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("the influx sink needs a url")
+	}
+	s := &influxSink{
+		postSink:    postSink{client: &http.Client{Timeout: 10 * time.Second}},
+		url:         cfg.URL,
+		measurement: cfg.Measurement,
+	}
+	if cfg.Token != "" {
+		s.token = "Token " + cfg.Token
+	}
+	if s.measurement == "" {
+		s.measurement = "gadgets"
+	}
+	return s, nil
+}
+
+var (
+	influxMeasurement = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTag         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+func (s *influxSink) Save(msg Message) error {
+	return s.SaveBatch([]Message{msg})
+}
+
+//SaveBatch writes all the values with one request.
+func (s *influxSink) SaveBatch(msgs []Message) error {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		v, ok := msg.Value.ToFloat()
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s,location=%s,name=%s", influxMeasurement.Replace(s.measurement), influxTag.Replace(msg.Location), influxTag.Replace(msg.Name))
+		if msg.Value.Units != "" {
+			buf.WriteString(",units=" + influxTag.Replace(msg.Value.Units))
+		}
+		fmt.Fprintf(&buf, " value=%s %d\n", strconv.FormatFloat(v, 'f', -1, 64), sinkTime(msg).UnixNano())
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return s.post(s.url, "text/plain; charset=utf-8", buf.Bytes())
+}
+
+//quimbySink posts {"value": v} to a url made from a template with
+//the location and name of the gadget.
+type quimbySink struct {
+	postSink
+	url string
+}
+
+//datapoint is what gets posted to quimby.  Time is only sent
+//when the update has one (so spooled values keep theirs).
+type datapoint struct {
+	Value float64    `json:"value"`
+	Units string     `json:"units,omitempty"`
+	Time  *time.Time `json:"time,omitempty"`
+}
+
+func newQuimbySink(cfg SinkConfig) (Sink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("the quimby sink needs a url")
+	}
+	return &quimbySink{
+		postSink: postSink{client: &http.Client{Timeout: 10 * time.Second}, token: fmt.Sprintf("Bearer %s", cfg.Token)},
+		url:      cfg.URL,
+	}, nil
+}
+
+func (s *quimbySink) Save(msg Message) error {
+	v, ok := msg.Value.ToFloat()
+	if !ok {
+		return nil
+	}
+	dp := datapoint{Value: v, Units: msg.Value.Units}
+	if !msg.Timestamp.IsZero() {
+		dp.Time = &msg.Timestamp
+	}
+	d, err := json.Marshal(dp)
+	if err != nil {
+		return err
+	}
+	return s.post(fmt.Sprintf(s.url, msg.Location, msg.Name), "application/json", append(d, '\n'))
+}
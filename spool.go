@@ -0,0 +1,276 @@
+package gogadgets
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolRetry = time.Second
+	maxSpoolRetry     = 5 * time.Minute
+	spoolSegmentSize  = 500
+)
+
+//spool is a sink that saves to another sink and keeps what that
+//sink can't take (the server is down) in files until it can.  The
+//files are sent oldest first, a segment (up to 500 values) at a
+//time, retrying with a backoff that doubles each time it fails.
+//The values keep the time they were read.
+//
+//A value can be sent twice if the system stops while a segment is
+//being sent, but it won't be lost.
+type spool struct {
+	sink     Sink
+	dir      string
+	retry    time.Duration
+	lock     sync.Mutex
+	segments []string
+	f        *os.File
+	n        int
+	seq      int
+	depth    int
+	done     chan bool
+	wg       sync.WaitGroup
+}
+
+func newSpool(sink Sink, dir, retry string) (*spool, error) {
+	s := &spool{
+		sink:  sink,
+		dir:   dir,
+		retry: defaultSpoolRetry,
+		done:  make(chan bool),
+	}
+	if retry != "" {
+		d, err := time.ParseDuration(retry)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("the spool retry has to be positive: %s", retry)
+		}
+		s.retry = d
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.forward()
+	return s, nil
+}
+
+//load finds the segments that were left over from the last time
+//the system ran.
+func (s *spool) load() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.spool"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		msgs, err := readSpool(f)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, f)
+		s.depth += len(msgs)
+		fmt.Sscanf(strings.TrimSuffix(filepath.Base(f), ".spool"), "%d", &s.seq)
+	}
+	return nil
+}
+
+//Save sends msg on to the sink, unless values are already waiting
+//(or the sink fails) in which case it is spooled.
+func (s *spool) Save(msg Message) error {
+	msg.Timestamp = sinkTime(msg)
+	s.lock.Lock()
+	waiting := s.depth > 0
+	s.lock.Unlock()
+	if !waiting {
+		err := s.sink.Save(msg)
+		if err == nil {
+			return nil
+		}
+		log.Println("spooling, couldn't save data", err)
+	}
+	return s.append(msg)
+}
+
+//Depth is how many values are waiting to be sent.
+func (s *spool) Depth() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.depth
+}
+
+func (s *spool) append(msg Message) error {
+	d, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.f == nil || s.n >= spoolSegmentSize {
+		if s.f != nil {
+			s.f.Close()
+		}
+		s.seq++
+		p := filepath.Join(s.dir, fmt.Sprintf("%012d.spool", s.seq))
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		s.f = f
+		s.n = 0
+		s.segments = append(s.segments, p)
+	}
+	if _, err := s.f.Write(append(d, '\n')); err != nil {
+		return err
+	}
+	s.n++
+	s.depth++
+	return nil
+}
+
+func (s *spool) forward() {
+	defer s.wg.Done()
+	wait := s.retry
+	for {
+		select {
+		case <-time.After(wait):
+		case <-s.done:
+			return
+		}
+		if s.send() {
+			wait = s.retry
+			continue
+		}
+		wait *= 2
+		if wait > maxSpoolRetry {
+			wait = maxSpoolRetry
+		}
+	}
+}
+
+//send sends the spooled segments until they are all gone (it
+//returns true) or the sink fails.
+func (s *spool) send() bool {
+	for {
+		s.lock.Lock()
+		if len(s.segments) == 0 {
+			s.lock.Unlock()
+			return true
+		}
+		p := s.segments[0]
+		if s.f != nil && s.f.Name() == p {
+			//new values go to a new segment while this one is sent
+			s.f.Close()
+			s.f = nil
+		}
+		s.lock.Unlock()
+
+		msgs, err := readSpool(p)
+		if err != nil {
+			log.Println("couldn't read the spool", err)
+			return false
+		}
+		n := s.saveBatch(msgs)
+		if n == len(msgs) {
+			err = os.Remove(p)
+		} else if n > 0 {
+			err = writeSpool(p, msgs[n:])
+		}
+		s.lock.Lock()
+		s.depth -= n
+		if n == len(msgs) {
+			s.segments = s.segments[1:]
+		}
+		s.lock.Unlock()
+		if err != nil {
+			log.Println("couldn't update the spool", err)
+			return false
+		}
+		if n < len(msgs) {
+			return false
+		}
+	}
+}
+
+//saveBatch returns how many of msgs were saved.
+func (s *spool) saveBatch(msgs []Message) int {
+	if b, ok := s.sink.(BatchSink); ok {
+		if err := b.SaveBatch(msgs); err != nil {
+			log.Println("couldn't save spooled data", err)
+			return 0
+		}
+		return len(msgs)
+	}
+	for i, msg := range msgs {
+		if err := s.sink.Save(msg); err != nil {
+			log.Println("couldn't save spooled data", err)
+			return i
+		}
+	}
+	return len(msgs)
+}
+
+//Close stops sending, what is left stays in the spool for the
+//next time.
+func (s *spool) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	s.lock.Lock()
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	s.lock.Unlock()
+	return s.sink.Close()
+}
+
+func readSpool(p string) ([]Message, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var msgs []Message
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var msg Message
+		//a line that was cut off when the system went down is skipped
+		if err := json.Unmarshal(sc.Bytes(), &msg); err == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, sc.Err()
+}
+
+//writeSpool replaces the segment at p with msgs.
+func writeSpool(p string, msgs []Message) error {
+	var buf []byte
+	for _, msg := range msgs {
+		d, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, d...), '\n')
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
@@ -0,0 +1,119 @@
+package gogadgets_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("spool", func() {
+	var (
+		tmp   string
+		ts    *httptest.Server
+		lock  sync.Mutex
+		down  bool
+		posts []string
+		start time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "")
+		Expect(err).To(BeNil())
+		down = true
+		posts = nil
+		start = time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			if down {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			d, _ := ioutil.ReadAll(r.Body)
+			posts = append(posts, strings.TrimSpace(string(d)))
+		}))
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		os.RemoveAll(tmp)
+	})
+
+	setDown := func(d bool) {
+		lock.Lock()
+		down = d
+		lock.Unlock()
+	}
+
+	getPosts := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, posts...)
+	}
+
+	//the ith temperature, a minute after the one before
+	temperature := func(i int) *gogadgets.Message {
+		return stamped(sensorUpdate("greenhouse", "temperature", float64(i), ""), start.Add(time.Duration(i)*time.Minute))
+	}
+
+	It("keeps what it can't send and sends it when the sink is back", func() {
+		r := newRecorder(fmt.Sprintf(`{"sinks": [{"type": "quimby", "url": "%s/%%s/%%s", "spool": "%s", "retry": "10ms"}]}`, ts.URL, tmp))
+		defer r.Close()
+
+		Expect(r.Update(temperature(0))).To(BeTrue())
+		Expect(r.Report()).To(Equal(map[string]interface{}{"spooled": 1}))
+		r.Update(temperature(1))
+		r.Update(temperature(2))
+		Expect(r.Report()).To(Equal(map[string]interface{}{"spooled": 3}))
+		Expect(getPosts()).To(BeEmpty())
+
+		setDown(false)
+		//the retry has backed off a couple of times by now
+		Eventually(getPosts, 3*time.Second).Should(HaveLen(3))
+		for i, p := range getPosts() {
+			var dp struct {
+				Value float64   `json:"value"`
+				Time  time.Time `json:"time"`
+			}
+			Expect(json.Unmarshal([]byte(p), &dp)).To(BeNil())
+			Expect(dp.Value).To(Equal(float64(i)))
+			Expect(dp.Time.Equal(start.Add(time.Duration(i) * time.Minute))).To(BeTrue())
+		}
+		Eventually(r.Report).Should(Equal(map[string]interface{}{"spooled": 0}))
+		files, _ := filepath.Glob(filepath.Join(tmp, "*.spool"))
+		Expect(files).To(BeEmpty())
+
+		r.Update(temperature(3))
+		Expect(getPosts()).To(HaveLen(4))
+	})
+
+	It("keeps the spool when the system restarts and sends it in batches", func() {
+		sink := fmt.Sprintf(`{"sinks": [{"type": "influx", "url": "%s/write", "spool": "%s", "retry": "10ms"}]}`, ts.URL, tmp)
+		r := newRecorder(sink)
+		for i := 0; i < 3; i++ {
+			r.Update(temperature(i))
+		}
+		Expect(r.Close()).To(BeNil())
+
+		setDown(false)
+		r = newRecorder(sink)
+		defer r.Close()
+		Eventually(getPosts).Should(HaveLen(1))
+		lines := strings.Split(getPosts()[0], "\n")
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[2]).To(Equal(fmt.Sprintf("gadgets,location=greenhouse,name=temperature value=2 %d", start.Add(2*time.Minute).UnixNano())))
+		Eventually(r.Report).Should(Equal(map[string]interface{}{"spooled": 0}))
+	})
+})
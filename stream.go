@@ -0,0 +1,245 @@
+package gogadgets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cswank/gogadgets/ws"
+)
+
+const streamSize = 100
+
+var (
+	//streamTypes are the messages that are streamed when
+	//the request doesn't ask for any types.
+	streamTypes = []string{UPDATE, METHODUPDATE, ERROR}
+	//keepAlive is how often an idle stream sends something
+	//so proxies don't close it.
+	keepAlive = 30 * time.Second
+)
+
+//stream is a client of /gadgets/stream or /gadgets/ws.  The
+//messages for it are buffered in ch and dropped if it can't
+//keep up.
+type stream struct {
+	ch   chan Message
+	subs *Subscriptions
+	lock sync.Mutex
+	//sent are the uuids of commands that came in over the
+	//websocket, their replies are sent back no matter what
+	//the filters are.
+	sent map[string]bool
+}
+
+//newStream reads the filters from the query of r.  Each of
+//location, name and type can be given more than once, name
+//can be a pattern (see Subscription).
+func newStream(r *http.Request) *stream {
+	q := r.URL.Query()
+	types := q["type"]
+	if len(types) == 0 {
+		types = streamTypes
+	}
+	locations := q["location"]
+	if len(locations) == 0 {
+		locations = []string{""}
+	}
+	names := q["name"]
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	subs := NewSubscriptions()
+	for _, t := range types {
+		for _, l := range locations {
+			for _, n := range names {
+				subs.Add(Subscription{Type: t, Location: l, Name: n})
+			}
+		}
+	}
+	return &stream{
+		ch:   make(chan Message, streamSize),
+		subs: subs,
+		sent: map[string]bool{},
+	}
+}
+
+func (st *stream) wants(msg *Message) bool {
+	if msg.ReplyTo != "" {
+		st.lock.Lock()
+		ok := st.sent[msg.ReplyTo]
+		delete(st.sent, msg.ReplyTo)
+		st.lock.Unlock()
+		if ok {
+			return true
+		}
+	}
+	return st.subs.Match(msg)
+}
+
+func (st *stream) send(msg Message) {
+	select {
+	case st.ch <- msg:
+	default:
+	}
+}
+
+func (s *Server) addStream(st *stream) {
+	s.streamsLock.Lock()
+	s.streams[st] = true
+	s.streamsLock.Unlock()
+
+	//start off with the latest update of every gadget
+	s.statusLock.Lock()
+	for _, msg := range s.updates {
+		if st.subs.Match(&msg) {
+			st.send(msg)
+		}
+	}
+	s.statusLock.Unlock()
+}
+
+func (s *Server) removeStream(st *stream) {
+	s.streamsLock.Lock()
+	delete(s.streams, st)
+	s.streamsLock.Unlock()
+}
+
+//publish sends msg to every stream that wants it.
+func (s *Server) publish(msg Message) {
+	s.streamsLock.Lock()
+	for st := range s.streams {
+		if st.wants(&msg) {
+			st.send(msg)
+		}
+	}
+	s.streamsLock.Unlock()
+}
+
+//stream sends messages as Server-Sent Events, the event is the
+//type of the message and the data is the message:
+//
+//	event: update
+//	data: {"uuid": ...}
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	st := newStream(r)
+	s.addStream(st)
+	defer s.removeStream(st)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		select {
+		case msg := <-st.ch:
+			d, err := json.Marshal(msg)
+			if err != nil {
+				s.lg.Println(err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, d); err != nil {
+				return
+			}
+		case <-time.After(keepAlive):
+			if _, err := fmt.Fprint(w, ": keep alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+		f.Flush()
+	}
+}
+
+//websocket streams messages as json, just like stream, and
+//passes the messages it gets from the client (RCL commands)
+//on to the gadgets.  The DONE or ERROR that answers a command
+//is sent back even if it doesn't match the filters.
+func (s *Server) websocket(w http.ResponseWriter, r *http.Request) {
+	c, err := ws.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	st := newStream(r)
+	s.addStream(st)
+	defer s.removeStream(st)
+
+	done := make(chan bool)
+	canCommand := !s.auth.enabled() || s.scope(r) == CommandScope
+	go s.readWebsocket(c, st, canCommand, done)
+
+	for {
+		select {
+		case msg := <-st.ch:
+			d, err := json.Marshal(msg)
+			if err != nil {
+				s.lg.Println(err)
+				continue
+			}
+			if err := c.WriteMessage(d); err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+//readWebsocket reads commands from a websocket until it is
+//closed.  A message can be a json Message or just the body
+//of a command.  If the token of the websocket is read only the
+//commands are answered with an error.
+func (s *Server) readWebsocket(c *ws.Conn, st *stream, canCommand bool, done chan<- bool) {
+	defer close(done)
+	for {
+		d, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg Message
+		if err := json.Unmarshal(d, &msg); err != nil {
+			msg = Message{Body: string(d)}
+		}
+		if msg.Type == "" {
+			msg.Type = COMMAND
+		}
+		if msg.Sender == "" {
+			msg.Sender = "client"
+		}
+		if msg.UUID == "" {
+			msg.UUID = GetUUID()
+		}
+		if !canCommand {
+			st.send(Message{
+				UUID:    GetUUID(),
+				Type:    ERROR,
+				Sender:  s.id,
+				ReplyTo: msg.UUID,
+				Body:    "this token can't send commands",
+			})
+			continue
+		}
+		st.lock.Lock()
+		st.sent[msg.UUID] = true
+		st.lock.Unlock()
+		if !s.receive(msg) {
+			return
+		}
+	}
+}
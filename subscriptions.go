@@ -0,0 +1,84 @@
+package gogadgets
+
+import (
+	"path"
+	"sync"
+)
+
+//Subscription picks out the messages a gadget wants the broker
+//to send it.  Empty fields match anything and Name can be a
+//pattern (see path.Match), so
+//
+//	Subscription{Type: UPDATE, Location: "hlt", Name: "temp*"}
+//
+//gets the updates of every temperature sensor in the hlt.
+type Subscription struct {
+	Type     string
+	Sender   string
+	Location string
+	Name     string
+}
+
+//Match returns true if msg is one that s subscribes to.
+func (s Subscription) Match(msg *Message) bool {
+	if s.Type != "" && s.Type != msg.Type {
+		return false
+	}
+	if s.Sender != "" && s.Sender != msg.Sender {
+		return false
+	}
+	if s.Location != "" && s.Location != msg.Location {
+		return false
+	}
+	if s.Name != "" {
+		ok, err := path.Match(s.Name, msg.Name)
+		return err == nil && ok
+	}
+	return true
+}
+
+//Subscriptions are all the subscriptions of a gadget.  The broker
+//checks them for every message it sends, so a running gadget can
+//change them (Gadget adds the sensor of an until condition for
+//example).
+type Subscriptions struct {
+	lock sync.Mutex
+	subs []Subscription
+}
+
+func NewSubscriptions(subs ...Subscription) *Subscriptions {
+	return &Subscriptions{subs: subs}
+}
+
+//Add adds subscriptions.
+func (s *Subscriptions) Add(subs ...Subscription) {
+	s.lock.Lock()
+	s.subs = append(s.subs, subs...)
+	s.lock.Unlock()
+}
+
+//Set replaces all the subscriptions.
+func (s *Subscriptions) Set(subs ...Subscription) {
+	s.lock.Lock()
+	s.subs = subs
+	s.lock.Unlock()
+}
+
+//Match returns true if any of the subscriptions match msg.
+func (s *Subscriptions) Match(msg *Message) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sub := range s.subs {
+		if sub.Match(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+//Subscriber is a Gadgeter that only wants some of the messages
+//that go through the system.  Gadgeters that aren't Subscribers
+//get every message, like they always have.
+type Subscriber interface {
+	Subscriptions() *Subscriptions
+}
@@ -0,0 +1,85 @@
+package gogadgets_test
+
+import (
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("subscriptions", func() {
+	var msg *gogadgets.Message
+
+	BeforeEach(func() {
+		msg = &gogadgets.Message{
+			Type:     gogadgets.UPDATE,
+			Sender:   "hlt temperature",
+			Location: "hlt",
+			Name:     "temperature",
+		}
+	})
+
+	It("matches everything when it's empty", func() {
+		Expect(gogadgets.Subscription{}.Match(msg)).To(BeTrue())
+	})
+
+	It("matches all the fields", func() {
+		s := gogadgets.Subscription{Type: gogadgets.UPDATE, Location: "hlt"}
+		Expect(s.Match(msg)).To(BeTrue())
+		s.Sender = "mash tun temperature"
+		Expect(s.Match(msg)).To(BeFalse())
+	})
+
+	It("matches a name pattern", func() {
+		Expect(gogadgets.Subscription{Name: "temp*"}.Match(msg)).To(BeTrue())
+		Expect(gogadgets.Subscription{Name: "volume"}.Match(msg)).To(BeFalse())
+	})
+
+	It("can be changed", func() {
+		subs := gogadgets.NewSubscriptions(gogadgets.Subscription{Type: gogadgets.COMMAND})
+		Expect(subs.Match(msg)).To(BeFalse())
+		subs.Add(gogadgets.Subscription{Sender: "hlt temperature"})
+		Expect(subs.Match(msg)).To(BeTrue())
+		subs.Set(gogadgets.Subscription{Type: gogadgets.COMMAND})
+		Expect(subs.Match(msg)).To(BeFalse())
+	})
+
+	It("subscribes a gadget to the sensor of an until condition", func() {
+		gogadgets.Sim.Reset()
+		gg, err := gogadgets.NewGadget(&gogadgets.GadgetConfig{
+			Location: "hlt",
+			Name:     "valve",
+			Pin: gogadgets.Pin{
+				Type:     "gpio",
+				Platform: "sim",
+				Port:     "8",
+				Pin:      "9",
+			},
+		})
+		Expect(err).To(BeNil())
+		g := gg.(*gogadgets.Gadget)
+		input := make(chan gogadgets.Message)
+		output := make(chan gogadgets.Message)
+		subs := g.Subscriptions()
+		go g.Start(input, output)
+		<-output
+
+		vol := &gogadgets.Message{
+			Type:     gogadgets.UPDATE,
+			Sender:   "mash tun volume",
+			Location: "mash tun",
+			Name:     "volume",
+			Value:    gogadgets.Value{Value: 20.0, Units: "liters"},
+		}
+		input <- gogadgets.Message{
+			Type: gogadgets.COMMAND,
+			Body: "turn on hlt valve until mash tun volume >= 20 liters",
+		}
+		<-output
+		Expect(subs.Match(vol)).To(BeTrue())
+
+		input <- *vol
+		update := <-output
+		Expect(update.Value.Value).To(BeFalse())
+		Expect(subs.Match(vol)).To(BeFalse())
+	})
+})
@@ -0,0 +1,270 @@
+package gogadgets
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+//The aggregations a recorder can summarize a gadget's values with.
+//AggTWMean weighs each value by how long it lasted and AggDuty is
+//the fraction of the time an output was on (the time weighted mean
+//of on and off).
+const (
+	AggMean   = "mean"
+	AggMin    = "min"
+	AggMax    = "max"
+	AggLast   = "last"
+	AggCount  = "count"
+	AggTWMean = "twmean"
+	AggDuty   = "duty"
+)
+
+var aggregations = map[string]bool{AggMean: true, AggMin: true, AggMax: true, AggLast: true, AggCount: true, AggTWMean: true, AggDuty: true}
+
+//SummaryConfig is how the recorder summarizes the values of one
+//gadget instead of saving every one of them:
+//
+//	"summarize": {
+//	    "lab thermometer": 5,
+//	    "hlt temperature": {"window": "10m", "aggregations": ["mean", "min", "max"]},
+//	    "tank pump": {"window": "1h"}
+//	}
+//
+//A number is a window in minutes.  Windows line up with the clock
+//(a 10m window closes at :00, :10, ...) and are closed by a timer,
+//so a gadget that hasn't sent anything still gets its last value
+//saved.  Without aggregations outputs get their duty and everything
+//else its mean.  Each aggregation is saved with its name after the
+//gadget's name ("hlt temperature min"), except for mean.
+type SummaryConfig struct {
+	Window       string   `json:"window"`
+	Aggregations []string `json:"aggregations,omitempty"`
+}
+
+type summaryConfig struct {
+	window time.Duration
+	aggs   []string
+}
+
+type sample struct {
+	t time.Time
+	v float64
+}
+
+//series is the state of the summary of one gadget.
+type series struct {
+	cfg     summaryConfig
+	msg     Message
+	isBool  bool
+	windows map[time.Time][]sample
+	last    *sample
+	flushed time.Time
+}
+
+func getSummaries(s interface{}) (map[string]summaryConfig, error) {
+	out := map[string]summaryConfig{}
+	if s == nil {
+		return out, nil
+	}
+	d, _ := json.Marshal(s)
+	vals := map[string]json.RawMessage{}
+	if err := json.Unmarshal(d, &vals); err != nil {
+		log.Println("WARNING, could not parse recorder summaires", s)
+		return out, nil
+	}
+	for key, val := range vals {
+		var minutes float64
+		var cfg SummaryConfig
+		if err := json.Unmarshal(val, &minutes); err == nil {
+			out[key] = summaryConfig{window: time.Duration(minutes * float64(time.Minute))}
+			continue
+		}
+		if err := json.Unmarshal(val, &cfg); err != nil {
+			return nil, fmt.Errorf("could not parse the summary of %s: %s", key, err)
+		}
+		w, err := time.ParseDuration(cfg.Window)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the summary of %s: %s", key, err)
+		}
+		for _, a := range cfg.Aggregations {
+			if !aggregations[a] {
+				return nil, fmt.Errorf("unknown aggregation for %s: %s", key, a)
+			}
+		}
+		out[key] = summaryConfig{window: w, aggs: cfg.Aggregations}
+	}
+	for key, cfg := range out {
+		if cfg.window <= 0 {
+			return nil, fmt.Errorf("the summary window of %s has to be positive", key)
+		}
+	}
+	return out, nil
+}
+
+//summarize adds the value of msg to the window it belongs in.
+func (r *Recorder) summarize(msg *Message, cfg summaryConfig) {
+	f, ok := msg.Value.ToFloat()
+	if !ok {
+		return
+	}
+	t := msg.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s, ok := r.series[msg.Sender]
+	if !ok {
+		s = &series{cfg: cfg, windows: map[time.Time][]sample{}}
+		r.series[msg.Sender] = s
+	}
+	s.msg = *msg
+	_, s.isBool = msg.Value.Value.(bool)
+	start := t.Truncate(cfg.window)
+	if start.Before(s.flushed) {
+		//too late for its own window
+		start = s.flushed
+	}
+	s.windows[start] = append(s.windows[start], sample{t: t, v: f})
+}
+
+//closeWindows runs until the recorder is closed, closing the
+//windows as they end.
+func (r *Recorder) closeWindows() {
+	defer r.wg.Done()
+	tick := time.Second
+	for _, cfg := range r.summaries {
+		if t := cfg.window / 10; t < tick {
+			tick = t
+		}
+	}
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	tk := time.NewTicker(tick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-r.stop:
+			return
+		}
+		r.lock.Lock()
+		var msgs []Message
+		for _, s := range r.series {
+			msgs = append(msgs, s.flush(time.Now())...)
+		}
+		r.lock.Unlock()
+		for i := range msgs {
+			r.doSave(&msgs[i])
+		}
+	}
+}
+
+//flush returns the summaries of the windows that have ended.  If
+//the window that just ended had no values the last value is used
+//for it (so a sensor that only sends changes is still saved).
+func (s *series) flush(now time.Time) []Message {
+	var starts []time.Time
+	for start := range s.windows {
+		if !start.Add(s.cfg.window).After(now) {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	var msgs []Message
+	for _, start := range starts {
+		msgs = append(msgs, s.aggregate(start, s.windows[start])...)
+		delete(s.windows, start)
+	}
+
+	prev := now.Truncate(s.cfg.window).Add(-s.cfg.window)
+	if s.last != nil && !s.flushed.After(prev) {
+		msgs = append(msgs, s.aggregate(prev, nil)...)
+	}
+	return msgs
+}
+
+//aggregate summarizes the samples of the window that starts at
+//start.  The value from before the window counts (for the time
+//weighted aggregations) until the first sample.
+func (s *series) aggregate(start time.Time, samples []sample) []Message {
+	end := start.Add(s.cfg.window)
+	carry := s.last
+	defer func() { s.flushed = end }()
+	if len(samples) == 0 && carry == nil {
+		return nil
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].t.Before(samples[j].t) })
+
+	vals := map[string]float64{AggCount: float64(len(samples))}
+	if len(samples) == 0 {
+		for _, a := range []string{AggMean, AggMin, AggMax, AggLast, AggTWMean, AggDuty} {
+			vals[a] = carry.v
+		}
+	} else {
+		var sum float64
+		vals[AggMin], vals[AggMax] = samples[0].v, samples[0].v
+		for _, smp := range samples {
+			sum += smp.v
+			if smp.v < vals[AggMin] {
+				vals[AggMin] = smp.v
+			}
+			if smp.v > vals[AggMax] {
+				vals[AggMax] = smp.v
+			}
+		}
+		vals[AggMean] = sum / float64(len(samples))
+		vals[AggLast] = samples[len(samples)-1].v
+
+		begin, v := samples[0].t, samples[0].v
+		if carry != nil {
+			begin, v = start, carry.v
+		}
+		var area float64
+		t := begin
+		for _, smp := range samples {
+			if smp.t.After(t) {
+				area += v * smp.t.Sub(t).Seconds()
+				t = smp.t
+			}
+			v = smp.v
+		}
+		if end.After(t) {
+			area += v * end.Sub(t).Seconds()
+		}
+		if d := end.Sub(begin).Seconds(); d > 0 {
+			vals[AggTWMean] = area / d
+		} else {
+			vals[AggTWMean] = v
+		}
+		vals[AggDuty] = vals[AggTWMean]
+		s.last = &sample{t: samples[len(samples)-1].t, v: vals[AggLast]}
+	}
+
+	aggs := s.cfg.aggs
+	if len(aggs) == 0 {
+		aggs = []string{AggMean}
+		if s.isBool {
+			aggs = []string{AggDuty}
+		}
+	}
+	msgs := make([]Message, len(aggs))
+	for i, a := range aggs {
+		m := s.msg
+		m.UUID = GetUUID()
+		m.Timestamp = start
+		m.Value.Value = vals[a]
+		if a != AggMean {
+			m.Name = fmt.Sprintf("%s %s", m.Name, a)
+		}
+		if a == AggCount || a == AggDuty {
+			m.Value.Units = ""
+		}
+		msgs[i] = m
+	}
+	return msgs
+}
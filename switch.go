@@ -54,24 +54,33 @@ func (s *Switch) Config() ConfigHelper {
 
 //The GPIO does the real waiting here.  This wraps it and adds
 //a delay so that the inevitable bounce in the signal from the
-//physical device is ignored.
-func (s *Switch) wait(out chan<- interface{}, err chan<- error) {
+//physical device is ignored.  Once stop is closed it returns
+//after the next edge (there is no way to interrupt GPIO.Wait).
+func (s *Switch) wait(out chan<- interface{}, err chan<- error, stop <-chan bool) {
 	for {
 		val, e := s.GPIO.Wait()
 		if e != nil {
-			err <- e
+			select {
+			case err <- e:
+			case <-stop:
+			}
 			return
 		}
-		switch v := s.TrueValue.(type) {
+		var v interface{}
+		switch t := s.TrueValue.(type) {
 		case bool:
-			out <- val
+			v = val
 		default:
+			v = 0.0
 			if val {
-				out <- v
-			} else {
-				out <- 0.0
+				v = t
 			}
 		}
+		select {
+		case out <- v:
+		case <-stop:
+			return
+		}
 		time.Sleep(100 * time.Millisecond)
 	}
 }
@@ -105,12 +114,15 @@ func (s *Switch) Start(in <-chan Message, out chan<- Value) {
 	err := make(chan error)
 	s.readValue()
 	s.SendValue()
-	keepGoing := true
-	go s.wait(value, err)
-	for keepGoing {
+	stop := make(chan bool)
+	defer close(stop)
+	go s.wait(value, err, stop)
+	for {
 		select {
-		case <-in:
-			//do nothing
+		case msg := <-in:
+			if msg.isShutdown() {
+				return
+			}
 		case val := <-value:
 			s.Value = val
 			s.SendValue()
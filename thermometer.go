@@ -66,6 +66,7 @@ fragment@1 {
 
 type Thermometer struct {
 	devicePath string
+	read       func() ([]byte, error)
 	units      string
 	value      float64
 	sleep      time.Duration
@@ -88,6 +89,12 @@ func NewThermometer(pin *Pin) (InputDevice, error) {
 		sleep:      pin.Sleep,
 		lock:       pin.Lock,
 	}
+	if pin.Platform == "sim" {
+		id := pin.OneWireId
+		therm.read = func() ([]byte, error) { return Sim.oneWire(id) }
+	} else {
+		therm.read = func() ([]byte, error) { return ioutil.ReadFile(path) }
+	}
 	return therm, err
 }
 
@@ -108,16 +115,24 @@ func (t *Thermometer) GetValue() *Value {
 	}
 }
 
-func (t *Thermometer) getTemperature(out chan Value, err chan error) {
+func (t *Thermometer) getTemperature(out chan Value, err chan error, stop <-chan bool) {
 	var previousTemperature *Value
 	for {
 		val, e := t.readFile()
 		if e == nil && t.isValid(val, previousTemperature) {
 			previousTemperature = val
 			t.value = val.Value.(float64)
-			out <- *val
+			select {
+			case out <- *val:
+			case <-stop:
+				return
+			}
+		}
+		select {
+		case <-time.After(t.sleep):
+		case <-stop:
+			return
 		}
-		time.Sleep(t.sleep)
 	}
 }
 
@@ -134,7 +149,7 @@ func (t *Thermometer) isValid(value, previous *Value) bool {
 func (t *Thermometer) readFile() (v *Value, err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	b, err := ioutil.ReadFile(t.devicePath)
+	b, err := t.read()
 	if err != nil {
 		return v, err
 	}
@@ -170,11 +185,15 @@ func (t *Thermometer) Start(in <-chan Message, out chan<- Value) {
 	temperature := make(chan Value)
 
 	e := make(chan error)
-	go t.getTemperature(temperature, e)
+	stop := make(chan bool)
+	defer close(stop)
+	go t.getTemperature(temperature, e, stop)
 	for {
 		select {
-		case <-in:
-			// do nothing
+		case msg := <-in:
+			if msg.isShutdown() {
+				return
+			}
 		case val := <-temperature:
 			out <- val
 		case err := <-e:
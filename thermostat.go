@@ -66,6 +66,10 @@ below 120.
 type Thermostat struct {
 	target float64
 
+	//the units of target, temperatures from the sensor are
+	//converted to them
+	units string
+
 	//minimum time between state changes
 	timeout time.Duration
 
@@ -85,7 +89,7 @@ func NewThermostat(pin *Pin) (OutputDevice, error) {
 	if !ok {
 		return nil, fmt.Errorf("invalid heat pin: %v", pin)
 	}
-	h, err := NewGPIO(&p)
+	h, err := NewGPIO(pin.subPin(&p))
 	if err != nil {
 		lg.Fatal(err)
 	}
@@ -95,27 +99,28 @@ func NewThermostat(pin *Pin) (OutputDevice, error) {
 		return nil, fmt.Errorf("invalid cool pin: %v", pin)
 	}
 
-	c, err := NewGPIO(&p)
+	c, err := NewGPIO(pin.subPin(&p))
 	if err != nil {
 		lg.Fatal(err)
 	}
 
-	p, ok = pin.Pins["fan"]
-	if !ok {
-		return nil, fmt.Errorf("invalid fan pin: %v", pin)
+	gpios := map[string]OutputDevice{
+		"heat": h,
+		"cool": c,
 	}
 
-	f, err := NewGPIO(&p)
-	if err != nil {
-		lg.Fatal(err)
+	//not every furnace has a separately controlled fan
+	p, ok = pin.Pins["fan"]
+	if ok {
+		f, err := NewGPIO(pin.subPin(&p))
+		if err != nil {
+			lg.Fatal(err)
+		}
+		gpios["fan"] = f
 	}
 
 	return &Thermostat{
-		gpios: map[string]OutputDevice{
-			"heat": h,
-			"cool": c,
-			"fan":  f,
-		},
+		gpios: gpios,
 		cmp: map[string]cmp{
 			"heat": func(x, y float64) bool { return x >= y },
 			"cool": func(x, y float64) bool { return x < y },
@@ -175,7 +180,7 @@ func (t *Thermostat) Update(msg *Message) bool {
 	}
 
 	var changed bool
-	temperature, ok := msg.Value.Value.(float64)
+	temperature, ok := msg.Value.In(t.units)
 	if t.status && ok && (t.lastCmd == "heat" || t.lastCmd == "cool") {
 		t.lastTemperature = &temperature
 		t.lastChange = &now
@@ -212,6 +217,7 @@ func (t *Thermostat) On(val *Value) error {
 	t.lastCmd = parts[0]
 	t.lastChange = nil
 	t.target = tar
+	t.units = val.Units
 	t.status = true
 	t.checkTemperature()
 	return nil
@@ -220,9 +226,9 @@ func (t *Thermostat) On(val *Value) error {
 func (t *Thermostat) Off() error {
 	if t.status {
 		t.status = false
-		t.gpios["heat"].Off()
-		t.gpios["cool"].Off()
-		t.gpios["fan"].Off()
+		for _, gpio := range t.gpios {
+			gpio.Off()
+		}
 	}
 	return nil
 }
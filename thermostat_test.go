@@ -141,6 +141,27 @@ var _ = Describe("thermostat", func() {
 				Expect(string(b)).To(Equal(c.output))
 			}
 		})
+		It("converts the temperature to the units of the command", func() {
+			val.Units = "F"
+			Expect(therm.On(val)).To(BeNil())
+			cases := []thermCase{
+				{20.0, "1"},
+				{21.5, "0"},
+				{20.5, "1"},
+			}
+			for _, c := range cases {
+				therm.Update(&gogadgets.Message{
+					Sender: "my thermometer",
+					Value: gogadgets.Value{
+						Value: c.temperature,
+						Units: "C",
+					},
+				})
+				b, err := ioutil.ReadFile(sys["heat-value"])
+				Expect(err).To(BeNil())
+				Expect(string(b)).To(Equal(c.output))
+			}
+		})
 	})
 
 	Describe("cooler", func() {
@@ -0,0 +1,112 @@
+package gogadgets
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+//TLSConfig turns on https.  Cert and Key are the paths of a
+//certificate and its key.  With SelfSigned (and no Cert) a
+//certificate is made up when the server starts.  Insecure makes
+//this node trust any certificate when it talks to the master or
+//its clients, which is needed when they use self signed ones (the
+//messages are still signed if there is an AuthConfig.Secret).
+type TLSConfig struct {
+	Cert       string `json:"cert,omitempty"`
+	Key        string `json:"key,omitempty"`
+	SelfSigned bool   `json:"self_signed,omitempty"`
+	Insecure   bool   `json:"insecure,omitempty"`
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.Cert != "" || t.SelfSigned
+}
+
+//ServerTLS sets the certificate of the api and how it trusts
+//other nodes.
+func ServerTLS(cfg TLSConfig) func(*Server) {
+	return func(s *Server) {
+		s.tls = cfg
+		if cfg.Insecure {
+			s.client = &http.Client{
+				Timeout: 10 * time.Second,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				},
+			}
+		}
+	}
+}
+
+//listen starts serving http (or https).
+func (s *Server) listen() error {
+	if !s.tls.enabled() {
+		return s.srv.ListenAndServe()
+	}
+	if s.tls.Cert != "" {
+		return s.srv.ListenAndServeTLS(s.tls.Cert, s.tls.Key)
+	}
+	cert, err := selfSigned(s.host)
+	if err != nil {
+		return err
+	}
+	s.srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return s.srv.ListenAndServeTLS("", "")
+}
+
+//selfSigned makes a certificate for localhost, this machine
+//and host (the address the node is reached at).
+func selfSigned(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	names := []string{"localhost"}
+	if h, err := os.Hostname(); err == nil {
+		names = append(names, h)
+	}
+	if u, err := url.Parse(host); err == nil && u.Hostname() != "" {
+		names = append(names, u.Hostname())
+	} else if host != "" {
+		names = append(names, host)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gogadgets"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,48 @@
+package gogadgets_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("tls", func() {
+	It("serves https with a self signed certificate", func() {
+		port := 1024 + rand.Intn(65535-1024)
+		s := gogadgets.NewServer("", "", port, &fakeLogger{}, gogadgets.ServerTLS(gogadgets.TLSConfig{SelfSigned: true}))
+		out := make(chan gogadgets.Message)
+		go s.Start(out, make(chan gogadgets.Message))
+		defer func() {
+			out <- gogadgets.Message{Type: gogadgets.COMMAND, Body: "shutdown"}
+		}()
+
+		cli := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+		addr := fmt.Sprintf("localhost:%d/gadgets", port)
+		Eventually(func() int {
+			r, err := cli.Get("https://" + addr)
+			if err != nil {
+				return 0
+			}
+			r.Body.Close()
+			return r.StatusCode
+		}).Should(Equal(http.StatusOK))
+
+		//the certificate isn't trusted by default
+		_, err := http.Get("https://" + addr)
+		Expect(err).ToNot(BeNil())
+
+		r, err := http.Get("http://" + addr)
+		Expect(err).To(BeNil())
+		r.Body.Close()
+		Expect(r.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,189 @@
+package gogadgets
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cswank/gogadgets/rcl"
+)
+
+//Unit is one of the units a Value can be in.  Every unit belongs
+//to a kind of quantity (temperature, volume, ...) and knows how
+//to convert itself to the base unit of that kind (C, liters,
+//liters/second, seconds, %, kPa and %RH).
+type Unit struct {
+	Name     string
+	Quantity string
+	scale    float64
+	offset   float64
+}
+
+func (u *Unit) toBase(v float64) float64 {
+	return v*u.scale + u.offset
+}
+
+func (u *Unit) fromBase(v float64) float64 {
+	return (v - u.offset) / u.scale
+}
+
+//scales converts each of the units RCL knows (see rcl.LookupUnit)
+//to the base unit of its kind.
+var (
+	scales = map[string][2]float64{
+		"C": {1.0, 0.0},
+		"F": {5.0 / 9.0, -32.0 * 5.0 / 9.0},
+		"K": {1.0, -273.15},
+
+		"liters":      {1.0, 0.0},
+		"milliliters": {0.001, 0.0},
+		"gallons":     {3.785411784, 0.0},
+		"quarts":      {0.946352946, 0.0},
+
+		"liters/second":  {1.0, 0.0},
+		"liters/minute":  {1.0 / 60.0, 0.0},
+		"gallons/minute": {3.785411784 / 60.0, 0.0},
+
+		"seconds": {1.0, 0.0},
+		"minutes": {60.0, 0.0},
+		"hours":   {3600.0, 0.0},
+
+		"%": {1.0, 0.0},
+
+		"kPa": {1.0, 0.0},
+		"Pa":  {0.001, 0.0},
+		"hPa": {0.1, 0.0},
+		"bar": {100.0, 0.0},
+		"psi": {6.894757293, 0.0},
+
+		"%RH": {1.0, 0.0},
+	}
+
+	units = map[string]*Unit{}
+)
+
+func init() {
+	for name, s := range scales {
+		_, kind, ok := rcl.LookupUnit(name)
+		if !ok {
+			panic(fmt.Sprintf("rcl doesn't know the units %s", name))
+		}
+		units[name] = &Unit{Name: name, Quantity: kind, scale: s[0], offset: s[1]}
+	}
+}
+
+//GetUnit looks up a unit by any of its names (it isn't case
+//sensitive, so F, f and fahrenheit are all the same).
+func GetUnit(name string) (*Unit, bool) {
+	n, _, ok := rcl.LookupUnit(name)
+	if !ok {
+		return nil, false
+	}
+	u, ok := units[n]
+	return u, ok
+}
+
+//Convert converts v from one unit to another.  If either unit is
+//empty or unknown v is returned as is, so values from gadgets that
+//don't set (or make up) their units compare the way they always
+//have.  Converting between different kinds of quantities (F to
+//liters) is an error.
+func Convert(v float64, from, to string) (float64, error) {
+	f, ok := GetUnit(from)
+	if !ok {
+		return v, nil
+	}
+	t, ok := GetUnit(to)
+	if !ok {
+		return v, nil
+	}
+	if f.Quantity != t.Quantity {
+		return v, fmt.Errorf("can't convert %s to %s", from, to)
+	}
+	if f == t {
+		return v, nil
+	}
+	return t.fromBase(f.toBase(v)), nil
+}
+
+//Quantity is a number with units, for example 200 F or 20 liters.
+type Quantity struct {
+	Value float64
+	Units string
+}
+
+//ParseQuantity parses things like "200 F" and "20 liters".
+func ParseQuantity(s string) (Quantity, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return Quantity{}, fmt.Errorf("invalid quantity: %s", s)
+	}
+	v, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return Quantity{}, fmt.Errorf("invalid quantity: %s", s)
+	}
+	return Quantity{Value: v, Units: parts[1]}, nil
+}
+
+//Kind returns what kind of quantity q is (temperature, volume...),
+//or "" if its units are unknown.
+func (q Quantity) Kind() string {
+	u, ok := GetUnit(q.Units)
+	if !ok {
+		return ""
+	}
+	return u.Quantity
+}
+
+//In returns q converted to units.
+func (q Quantity) In(units string) (float64, error) {
+	return Convert(q.Value, q.Units, units)
+}
+
+//Compare returns -1, 0 or 1 if q is less than, equal to or greater
+//than o (after converting o to the units of q).
+func (q Quantity) Compare(o Quantity) (int, error) {
+	v, err := o.In(q.Units)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case q.Value < v:
+		return -1, nil
+	case q.Value > v:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+//Duration returns q as a time.Duration.  q must be a time.
+func (q Quantity) Duration() (time.Duration, error) {
+	if q.Kind() != "time" {
+		return 0, fmt.Errorf("%v %s is not a time", q.Value, q.Units)
+	}
+	s, err := q.In("seconds")
+	return time.Duration(s * float64(time.Second)), err
+}
+
+func (q Quantity) String() string {
+	return fmt.Sprintf("%s %s", strconv.FormatFloat(q.Value, 'f', -1, 64), q.Units)
+}
+
+//Quantity returns the value as a Quantity, ok is false if the
+//value isn't a number.
+func (v *Value) Quantity() (Quantity, bool) {
+	f, ok := v.Value.(float64)
+	return Quantity{Value: f, Units: v.Units}, ok
+}
+
+//In returns the value converted to units.  ok is false if the
+//value isn't a number or it is a different kind of quantity.
+func (v *Value) In(units string) (float64, bool) {
+	q, ok := v.Quantity()
+	if !ok {
+		return 0, false
+	}
+	f, err := q.In(units)
+	return f, err == nil
+}
@@ -0,0 +1,82 @@
+package gogadgets_test
+
+import (
+	"time"
+
+	"github.com/cswank/gogadgets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("units", func() {
+	It("converts temperatures", func() {
+		v, err := gogadgets.Convert(100.0, "C", "F")
+		Expect(err).To(BeNil())
+		Expect(v).To(BeNumerically("~", 212.0, 0.0001))
+		v, err = gogadgets.Convert(32.0, "fahrenheit", "kelvin")
+		Expect(err).To(BeNil())
+		Expect(v).To(BeNumerically("~", 273.15, 0.0001))
+	})
+
+	It("converts volumes, flows and pressures", func() {
+		v, err := gogadgets.Convert(5.0, "gallons", "liters")
+		Expect(err).To(BeNil())
+		Expect(v).To(BeNumerically("~", 18.927, 0.001))
+		v, err = gogadgets.Convert(1.0, "gpm", "liters/second")
+		Expect(err).To(BeNil())
+		Expect(v).To(BeNumerically("~", 0.06309, 0.00001))
+		v, err = gogadgets.Convert(1.0, "bar", "psi")
+		Expect(err).To(BeNil())
+		Expect(v).To(BeNumerically("~", 14.5038, 0.0001))
+	})
+
+	It("won't convert between different quantities", func() {
+		_, err := gogadgets.Convert(1.0, "F", "liters")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("leaves unknown units alone", func() {
+		v, err := gogadgets.Convert(3.0, "", "F")
+		Expect(err).To(BeNil())
+		Expect(v).To(Equal(3.0))
+		v, err = gogadgets.Convert(3.0, "widgets", "F")
+		Expect(err).To(BeNil())
+		Expect(v).To(Equal(3.0))
+	})
+
+	It("parses and compares quantities", func() {
+		q, err := gogadgets.ParseQuantity("200 F")
+		Expect(err).To(BeNil())
+		Expect(q.Kind()).To(Equal("temperature"))
+		Expect(q.String()).To(Equal("200 F"))
+		c, err := q.Compare(gogadgets.Quantity{Value: 90.0, Units: "C"})
+		Expect(err).To(BeNil())
+		Expect(c).To(Equal(1))
+		c, err = q.Compare(gogadgets.Quantity{Value: 100.0, Units: "C"})
+		Expect(err).To(BeNil())
+		Expect(c).To(Equal(-1))
+
+		_, err = gogadgets.ParseQuantity("hot F")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("turns times into durations", func() {
+		d, err := gogadgets.Quantity{Value: 1.5, Units: "minutes"}.Duration()
+		Expect(err).To(BeNil())
+		Expect(d).To(Equal(90 * time.Second))
+		_, err = gogadgets.Quantity{Value: 1.5, Units: "liters"}.Duration()
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("converts values", func() {
+		v := gogadgets.Value{Value: 20.0, Units: "C"}
+		f, ok := v.In("F")
+		Expect(ok).To(BeTrue())
+		Expect(f).To(BeNumerically("~", 68.0, 0.0001))
+		_, ok = v.In("liters")
+		Expect(ok).To(BeFalse())
+		v = gogadgets.Value{Value: true}
+		_, ok = v.In("F")
+		Expect(ok).To(BeFalse())
+	})
+})
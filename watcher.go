@@ -0,0 +1,81 @@
+package gogadgets
+
+import (
+	"fmt"
+	"time"
+)
+
+//watcher is the part of the alerts and rules gadgets that watches
+//other gadgets.  It subscribes to the updates of their sensors and
+//ticks between updates, for the conditions that have to last for a
+//while.
+type watcher struct {
+	uid     string
+	tick    time.Duration
+	sensors map[string]bool
+	subs    *Subscriptions
+}
+
+func newWatcher(uid string) watcher {
+	return watcher{
+		uid:     uid,
+		tick:    time.Second,
+		sensors: map[string]bool{},
+		subs:    NewSubscriptions(Subscription{Type: COMMAND}),
+	}
+}
+
+//watch subscribes to the updates of a sensor.
+func (w *watcher) watch(sensor string) {
+	if w.sensors[sensor] {
+		return
+	}
+	w.sensors[sensor] = true
+	subs := []Subscription{{Type: COMMAND}}
+	for s := range w.sensors {
+		subs = append(subs, Subscription{Type: UPDATE, Sender: s})
+	}
+	w.subs = NewSubscriptions(subs...)
+}
+
+func (w *watcher) setTick(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("the tick of %s has to be longer than 0, got %s", w.uid, d)
+	}
+	w.tick = d
+	return nil
+}
+
+func (w *watcher) GetUID() string {
+	return w.uid
+}
+
+func (w *watcher) GetDirection() string {
+	return "na"
+}
+
+//Subscriptions are the updates of the watched sensors, and the
+//commands (for shutdown).
+func (w *watcher) Subscriptions() *Subscriptions {
+	return w.subs
+}
+
+//run passes the updates of the watched sensors to update and calls
+//check on every tick until it gets the shutdown command.
+func (w *watcher) run(in <-chan Message, update func(Message, time.Time), check func(time.Time)) {
+	tk := time.NewTicker(w.tick)
+	defer tk.Stop()
+	for {
+		select {
+		case msg := <-in:
+			if msg.isShutdown() {
+				return
+			}
+			if msg.Type == UPDATE && w.sensors[msg.Sender] {
+				update(msg, time.Now())
+			}
+		case now := <-tk.C:
+			check(now)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package gogadgets
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -13,24 +14,43 @@ import (
 )
 
 type Server struct {
-	host        string
-	master      string
-	isMaster    bool
-	port        int
-	prefix      string
-	lg          Logger
-	external    chan Message
-	internal    chan Message
-	id          string
-	updates     map[string]Message
-	seen        map[string]time.Time
-	statusLock  sync.Mutex
-	seenLock    sync.Mutex
-	clientsLock sync.Mutex
-	clients     map[string]string
+	host         string
+	master       string
+	isMaster     bool
+	port         int
+	prefix       string
+	lg           Logger
+	external     chan Message
+	internal     chan Message
+	id           string
+	updates      map[string]Message
+	seen         map[string]time.Time
+	statusLock   sync.Mutex
+	seenLock     sync.Mutex
+	nonces       map[string]time.Time
+	noncesLock   sync.Mutex
+	clientsLock  sync.Mutex
+	clients      map[string]string
+	repliesLock  sync.Mutex
+	replies      map[string]chan Message
+	streamsLock  sync.Mutex
+	streams      map[*stream]bool
+	srv          *http.Server
+	done         chan bool
+	broker       *Broker
+	auth         AuthConfig
+	tls          TLSConfig
+	client       *http.Client
+	metricsLock  sync.Mutex
+	messages     map[string]int
+	pushFailures map[string]int
+	started      time.Time
+	history      *History
+	historyIn    chan Message
+	historyDone  chan bool
 }
 
-func NewServer(host, master string, port int, lg Logger) *Server {
+func NewServer(host, master string, port int, lg Logger, options ...func(*Server)) *Server {
 	var isMaster bool
 	clients := map[string]string{}
 	if master == "" {
@@ -40,40 +60,77 @@ func NewServer(host, master string, port int, lg Logger) *Server {
 			master: "",
 		}
 	}
-	return &Server{
-		master:   master,
-		host:     host,
-		isMaster: isMaster,
-		port:     port,
-		lg:       lg,
-		updates:  map[string]Message{},
-		id:       "server",
-		external: make(chan Message),
-		seen:     map[string]time.Time{},
-		clients:  clients,
+	s := &Server{
+		master:       master,
+		host:         host,
+		isMaster:     isMaster,
+		port:         port,
+		lg:           lg,
+		updates:      map[string]Message{},
+		id:           "server",
+		external:     make(chan Message),
+		seen:         map[string]time.Time{},
+		nonces:       map[string]time.Time{},
+		clients:      clients,
+		replies:      map[string]chan Message{},
+		streams:      map[*stream]bool{},
+		done:         make(chan bool),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		messages:     map[string]int{},
+		pushFailures: map[string]int{},
+		started:      time.Now(),
 	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Server) Start(i <-chan Message, o chan<- Message) {
+	s.srv = &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.port),
+		Handler: s.router(),
+	}
 	if !s.isMaster {
 		go s.register()
 	}
 	go s.startServer()
 	go s.cleanup()
+	if s.history != nil {
+		s.historyIn = make(chan Message, historyBuffer)
+		s.historyDone = make(chan bool)
+		go s.recordHistory()
+	}
 
 	for {
 		select {
 		case msg := <-i:
+			if msg.isShutdown() {
+				s.stop()
+				return
+			}
+			s.countMessage(msg)
+			if msg.Type == UPDATE && s.history != nil {
+				s.queueHistory(msg)
+			}
 			if (msg.Type == UPDATE || msg.Type == METHODUPDATE) && s.isMaster {
 				s.statusLock.Lock()
 				s.updates[msg.Sender] = msg
 				s.statusLock.Unlock()
 			}
+			if msg.ReplyTo != "" {
+				s.reply(msg)
+			}
+			s.publish(msg)
 			if !s.isSeen(msg) {
 				s.send(msg)
 			}
 		case msg := <-s.external:
 			s.setSeen(msg)
+			s.countMessage(msg)
+			if msg.ReplyTo != "" {
+				s.reply(msg)
+			}
 			o <- msg
 			if s.isMaster && msg.Sender == "client" {
 				s.send(msg)
@@ -95,27 +152,36 @@ func (s *Server) doSend(host string, msg Message, token string) {
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
 	enc.Encode(msg)
-	req, err := http.NewRequest("POST", host, &buf)
-	s.clientsLock.Lock()
-	defer s.clientsLock.Unlock()
+	body := buf.Bytes()
+	req, err := http.NewRequest("POST", host, bytes.NewReader(body))
 	if err != nil {
-		delete(s.clients, host)
+		s.dropClient(host)
 		log.Printf("error posting to quimby host: %s, err: %v\n", host, err)
 		return
 	}
 	if len(token) > 0 {
 		req.Header.Add("Authorization", token)
 	}
-	r, err := http.DefaultClient.Do(req)
+	s.sign(req, body)
+	r, err := s.client.Do(req)
 	if r != nil {
 		r.Body.Close()
 	}
 
 	if err != nil || r.StatusCode != http.StatusOK {
-		delete(s.clients, host)
+		s.dropClient(host)
 	}
 }
 
+//dropClient stops sending to a client that failed, it has to
+//register again.
+func (s *Server) dropClient(host string) {
+	s.clientsLock.Lock()
+	delete(s.clients, host)
+	s.clientsLock.Unlock()
+	s.pushFailed(host)
+}
+
 func (s *Server) setSeen(msg Message) {
 	s.seenLock.Lock()
 	s.seen[msg.UUID] = time.Now()
@@ -129,9 +195,28 @@ func (s *Server) isSeen(msg Message) bool {
 	return ok
 }
 
+//stop closes the listener and waits (for a little while) for
+//the requests that are being handled to finish.
+func (s *Server) stop() {
+	close(s.done)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		s.lg.Println(err)
+	}
+	if s.historyIn != nil {
+		close(s.historyIn)
+		<-s.historyDone
+	}
+}
+
 func (s *Server) cleanup() {
 	for {
-		time.Sleep(60 * time.Second)
+		select {
+		case <-time.After(60 * time.Second):
+		case <-s.done:
+			return
+		}
 		now := time.Now()
 		s.seenLock.Lock()
 		for k, v := range s.seen {
@@ -140,6 +225,7 @@ func (s *Server) cleanup() {
 			}
 		}
 		s.seenLock.Unlock()
+		s.forgetNonces(now)
 	}
 }
 
@@ -151,26 +237,53 @@ func (s *Server) GetDirection() string {
 	return "na"
 }
 
-func (s *Server) startServer() {
+func (s *Server) router() http.Handler {
 	r := rex.New("main")
-	r.Get("/gadgets", http.HandlerFunc(s.status))
-	r.Get("/gadgets/values", http.HandlerFunc(s.values))
-	r.Get("/gadgets/locations/{location}/devices/{device}/status", http.HandlerFunc(s.deviceValue))
-	r.Put("/gadgets", http.HandlerFunc(s.update))
-	r.Post("/gadgets", http.HandlerFunc(s.update))
+	r.Get("/gadgets", s.authorize(ReadScope, s.status))
+	r.Get("/gadgets/values", s.authorize(ReadScope, s.values))
+	r.Get("/gadgets/broker", s.authorize(ReadScope, s.brokerStats))
+	r.Get("/gadgets/stream", s.authorize(ReadScope, s.stream))
+	r.Get("/gadgets/ws", s.authorize(ReadScope, s.websocket))
+	r.Get("/gadgets/locations", s.authorize(ReadScope, s.locations))
+	r.Get("/gadgets/locations/{location}/devices/{device}/status", s.authorize(ReadScope, s.deviceValue))
+	r.Get("/gadgets/locations/{location}/devices/{device}/history", s.authorize(ReadScope, s.deviceHistory))
+	r.Post("/gadgets/locations/{location}/devices/{device}/on", s.authorize(CommandScope, s.deviceOn))
+	r.Post("/gadgets/locations/{location}/devices/{device}/off", s.authorize(CommandScope, s.deviceOff))
+	r.Get("/gadgets/types", s.authorize(ReadScope, s.types))
+	r.Get("/gadgets/openapi.json", http.HandlerFunc(s.openAPI))
+	r.Get("/metrics", s.authorize(ReadScope, s.metrics))
+	r.Get("/dashboard", http.HandlerFunc(s.dashboard))
+	r.Get("/dashboard/app.js", http.HandlerFunc(s.dashboardJS))
+	r.Get("/dashboard/style.css", http.HandlerFunc(s.dashboardCSS))
+	r.Put("/gadgets", s.authorize(CommandScope, s.update))
+	r.Post("/gadgets", s.authorize(CommandScope, s.update))
 	if s.isMaster {
-		r.Post("/clients", http.HandlerFunc(s.setClient))
-		r.Get("/clients", http.HandlerFunc(s.getClients))
-		r.Delete("/clients", http.HandlerFunc(s.removeClient))
+		r.Post("/clients", s.authorize(CommandScope, s.setClient))
+		r.Get("/clients", s.authorize(ReadScope, s.getClients))
+		r.Delete("/clients", s.authorize(CommandScope, s.removeClient))
 	}
+	return r
+}
 
+func (s *Server) startServer() {
 	s.lg.Printf("listening on 0.0.0.0:%d\n", s.port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", s.port), r)
-	if err != nil {
+	err := s.listen()
+	if err != nil && err != http.ErrServerClosed {
 		s.lg.Fatal(err)
 	}
 }
 
+//receive passes a message from the http api on to the rest of
+//the system, ok is false if the server has been stopped.
+func (s *Server) receive(msg Message) bool {
+	select {
+	case s.external <- msg:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
 func (s *Server) getClients(w http.ResponseWriter, r *http.Request) {
 	enc := json.NewEncoder(w)
 	s.clientsLock.Lock()
@@ -225,6 +338,19 @@ func (s *Server) deviceValue(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//brokerStats sends the queue depth, drops and delivery latency of
+//every gadget.
+func (s *Server) brokerStats(w http.ResponseWriter, r *http.Request) {
+	if s.broker == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(s.broker.Stats()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) values(w http.ResponseWriter, r *http.Request) {
 	enc := json.NewEncoder(w)
 	s.statusLock.Lock()
@@ -255,25 +381,121 @@ func (s *Server) update(w http.ResponseWriter, r *http.Request) {
 	if msg.UUID == "" {
 		msg.UUID = GetUUID()
 	}
-	s.external <- msg
+	s.command(w, r, msg)
+}
+
+//command passes msg on to the gadgets.  With ?wait=<duration> it
+//waits for the DONE or ERROR that answers it and sends that back,
+//otherwise it sends back msg (so the caller knows its uuid).
+func (s *Server) command(w http.ResponseWriter, r *http.Request, msg Message) {
+	wait := r.URL.Query().Get("wait")
+	if wait == "" {
+		if !s.receive(msg) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.writeJSON(w, msg)
+		return
+	}
+
+	d, err := time.ParseDuration(wait)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ch := s.waitFor(msg.UUID)
+	defer s.stopWaiting(msg.UUID)
+	if !s.receive(msg) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case reply := <-ch:
+		if reply.Type == ERROR {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(reply); err != nil {
+			s.lg.Println(err)
+		}
+	case <-time.After(d):
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case <-s.done:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+//waitFor returns a chan that gets the DONE or ERROR message
+//that answers the message with the uuid.
+func (s *Server) waitFor(uuid string) chan Message {
+	ch := make(chan Message, 1)
+	s.repliesLock.Lock()
+	s.replies[uuid] = ch
+	s.repliesLock.Unlock()
+	return ch
+}
+
+func (s *Server) stopWaiting(uuid string) {
+	s.repliesLock.Lock()
+	delete(s.replies, uuid)
+	s.repliesLock.Unlock()
+}
+
+//reply passes msg on to the request (if there is one) that is
+//waiting for it.  Only the first reply is kept.
+func (s *Server) reply(msg Message) {
+	s.repliesLock.Lock()
+	ch, ok := s.replies[msg.ReplyTo]
+	s.repliesLock.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- msg:
+	default:
+	}
 }
 
 //
 func (s *Server) register() {
 	var tries int
 	addr := fmt.Sprintf("%s/clients", s.master)
-	a := map[string]string{"address": fmt.Sprintf("%s/gadgets", s.host), "token": "n/a"}
+	a := map[string]string{"address": fmt.Sprintf("%s/gadgets", s.host), "token": s.auth.commandToken()}
 	for {
 		buf := &bytes.Buffer{}
 		enc := json.NewEncoder(buf)
 		enc.Encode(&a)
-		r, err := http.Post(addr, "application/json", buf)
-		if err == nil && r.StatusCode == http.StatusOK {
+		if s.postSigned(addr, buf.Bytes()) {
 			return
 		}
 		tries = increment(tries)
-		time.Sleep(time.Duration(tries) * 100 * time.Millisecond)
+		select {
+		case <-time.After(time.Duration(tries) * 100 * time.Millisecond):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+//postSigned posts body to another node and returns true if it
+//was accepted.  It sends the command token of this node too, for a
+//master that has tokens but no secret.
+func (s *Server) postSigned(addr string, body []byte) bool {
+	req, err := http.NewRequest("POST", addr, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := s.auth.commandToken(); token != "n/a" {
+		req.Header.Set("Authorization", token)
+	}
+	s.sign(req, body)
+	r, err := s.client.Do(req)
+	if err != nil {
+		return false
 	}
+	r.Body.Close()
+	return r.StatusCode == http.StatusOK
 }
 
 func increment(i int) int {
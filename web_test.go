@@ -1,15 +1,18 @@
 package gogadgets_test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"time"
 
 	"github.com/cswank/gogadgets"
+	"github.com/cswank/gogadgets/ws"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -117,6 +120,162 @@ var _ = Describe("server", func() {
 			m := <-in
 			Expect(m.Body).To(Equal("turn on lab led"))
 		})
+		It("waits for the reply to a command", func() {
+			Eventually(func() error {
+				r, err := http.Get(addr)
+				if err == nil {
+					r.Body.Close()
+				}
+				return err
+			}).Should(BeNil())
+
+			buf := &bytes.Buffer{}
+			enc := json.NewEncoder(buf)
+			Expect(enc.Encode(&gogadgets.Message{
+				Type:   gogadgets.COMMAND,
+				Sender: "me",
+				Body:   "turn on lab led",
+			})).To(BeNil())
+
+			responses := make(chan *http.Response)
+			go func() {
+				defer GinkgoRecover()
+				r, err := http.Post(addr+"?wait=1s", "application/json", buf)
+				Expect(err).To(BeNil())
+				responses <- r
+			}()
+
+			m := <-in
+			Expect(m.UUID).ToNot(Equal(""))
+			out <- gogadgets.Message{
+				Type:    gogadgets.ERROR,
+				Sender:  "lab led",
+				Target:  "me",
+				ReplyTo: m.UUID,
+				Body:    "gpio is gone",
+			}
+
+			r := <-responses
+			defer r.Body.Close()
+			Expect(r.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+			var reply gogadgets.Message
+			Expect(json.NewDecoder(r.Body).Decode(&reply)).To(BeNil())
+			Expect(reply.ReplyTo).To(Equal(m.UUID))
+			Expect(reply.Body).To(Equal("gpio is gone"))
+		})
+		It("times out waiting for the reply to a command", func() {
+			Eventually(func() error {
+				r, err := http.Get(addr)
+				if err == nil {
+					r.Body.Close()
+				}
+				return err
+			}).Should(BeNil())
+
+			buf := &bytes.Buffer{}
+			enc := json.NewEncoder(buf)
+			Expect(enc.Encode(&gogadgets.Message{
+				Type:   gogadgets.COMMAND,
+				Sender: "me",
+				Body:   "turn on lab led",
+			})).To(BeNil())
+
+			go func() {
+				<-in
+			}()
+			r, err := http.Post(addr+"?wait=10ms", "application/json", buf)
+			Expect(err).To(BeNil())
+			r.Body.Close()
+			Expect(r.StatusCode).To(Equal(http.StatusGatewayTimeout))
+		})
+		It("streams updates as server-sent events", func() {
+			var r *http.Response
+			Eventually(func() error {
+				var err error
+				r, err = http.Get(addr + "/stream?location=lab")
+				return err
+			}).Should(BeNil())
+			defer r.Body.Close()
+			Expect(r.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+			events := bufio.NewReader(r.Body)
+			next := func() (string, gogadgets.Message) {
+				var event string
+				var msg gogadgets.Message
+				for {
+					line, err := events.ReadString('\n')
+					Expect(err).To(BeNil())
+					line = strings.TrimSpace(line)
+					switch {
+					case line == "":
+						return event, msg
+					case strings.HasPrefix(line, "event: "):
+						event = strings.TrimPrefix(line, "event: ")
+					case strings.HasPrefix(line, "data: "):
+						Expect(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg)).To(BeNil())
+					}
+				}
+			}
+
+			//the current value comes first
+			event, msg := next()
+			Expect(event).To(Equal(gogadgets.UPDATE))
+			Expect(msg.Sender).To(Equal("lab led"))
+			Expect(msg.Value.Value).To(BeTrue())
+
+			out <- gogadgets.Message{
+				Type:     gogadgets.UPDATE,
+				Sender:   "hall led",
+				Location: "hall",
+				Name:     "led",
+				Value:    gogadgets.Value{Value: true},
+			}
+			out <- gogadgets.Message{
+				Type:     gogadgets.UPDATE,
+				Sender:   "lab led",
+				Location: "lab",
+				Name:     "led",
+				Value:    gogadgets.Value{Value: false},
+			}
+			event, msg = next()
+			Expect(event).To(Equal(gogadgets.UPDATE))
+			Expect(msg.Sender).To(Equal("lab led"))
+			Expect(msg.Value.Value).To(BeFalse())
+		})
+		It("streams over a websocket and takes commands from it", func() {
+			var c *ws.Conn
+			Eventually(func() error {
+				var err error
+				c, err = ws.Dial(fmt.Sprintf("ws://localhost:%d/gadgets/ws?name=led", port), nil)
+				return err
+			}).Should(BeNil())
+			defer c.Close()
+
+			read := func() gogadgets.Message {
+				d, err := c.ReadMessage()
+				Expect(err).To(BeNil())
+				var msg gogadgets.Message
+				Expect(json.Unmarshal(d, &msg)).To(BeNil())
+				return msg
+			}
+			senders := []string{read().Sender, read().Sender}
+			Expect(senders).To(ConsistOf("lab led", "hall led"))
+
+			Expect(c.WriteMessage([]byte("turn on lab led"))).To(BeNil())
+			m := <-in
+			Expect(m.Type).To(Equal(gogadgets.COMMAND))
+			Expect(m.Body).To(Equal("turn on lab led"))
+			out <- gogadgets.Message{
+				Type:    gogadgets.DONE,
+				Sender:  "lab led",
+				Target:  m.Sender,
+				ReplyTo: m.UUID,
+				Body:    m.Body,
+			}
+			reply := read()
+			Expect(reply.Type).To(Equal(gogadgets.DONE))
+			Expect(reply.ReplyTo).To(Equal(m.UUID))
+		})
 		It("registers a new client", func() {
 			msgs := []gogadgets.Message{}
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -223,5 +382,56 @@ var _ = Describe("server", func() {
 				return len(c2)
 			}).Should(Equal(0))
 		})
+
+		It("serves prometheus metrics", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer ts.Close()
+
+			out <- gogadgets.Message{
+				Type:     gogadgets.UPDATE,
+				Sender:   "hall temperature",
+				Location: "hall",
+				Name:     "temperature",
+				Value:    gogadgets.Value{Value: 21.5, Units: "C"},
+				Info:     gogadgets.Info{Direction: "input"},
+			}
+			out <- gogadgets.Message{
+				Type:   gogadgets.METHODUPDATE,
+				Sender: "method runner",
+				Method: gogadgets.Method{Step: 1, Steps: []string{"turn on lab led", "wait for 1 minute"}, Time: 42},
+			}
+
+			Eventually(func() int {
+				r, err := http.Post(cliAddr, "application/json", strings.NewReader(fmt.Sprintf(`{"address": "%s", "token": "x"}`, ts.URL)))
+				if err != nil {
+					return 500
+				}
+				r.Body.Close()
+				return r.StatusCode
+			}).Should(Equal(http.StatusOK))
+			out <- gogadgets.Message{Type: gogadgets.COMMAND, Sender: "me", Body: "turn on lab led"}
+
+			metrics := func() string {
+				r, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", port))
+				Expect(err).To(BeNil())
+				defer r.Body.Close()
+				Expect(r.Header.Get("Content-Type")).To(HavePrefix("text/plain"))
+				buf := &bytes.Buffer{}
+				buf.ReadFrom(r.Body)
+				return buf.String()
+			}
+			Eventually(metrics).Should(ContainSubstring(fmt.Sprintf(`gogadgets_push_failures_total{host="%s"} 1`, ts.URL)))
+			m := metrics()
+			Expect(m).To(ContainSubstring(`gogadgets_value{location="hall",name="temperature",units="C"} 21.5`))
+			Expect(m).To(ContainSubstring(`gogadgets_output_status{location="lab",name="led",output="gpio"} 1`))
+			Expect(m).To(ContainSubstring("# TYPE gogadgets_method_step gauge\n" + `gogadgets_method_step{sender="method runner"} 1` + "\n# HELP gogadgets_method_steps"))
+			Expect(m).To(ContainSubstring(`gogadgets_method_steps{sender="method runner"} 2`))
+			Expect(m).To(ContainSubstring(`gogadgets_method_wait_seconds{sender="method runner"} 42`))
+			Expect(m).To(ContainSubstring(`gogadgets_messages_total{type="update"} 3`))
+			Expect(m).To(ContainSubstring(`gogadgets_messages_total{type="command"} 1`))
+			Expect(m).To(ContainSubstring("# TYPE gogadgets_uptime_seconds gauge"))
+		})
 	})
 })
@@ -0,0 +1,314 @@
+/*
+Package ws is a small WebSocket (RFC 6455) implementation that is
+just big enough for streaming gadget messages: a server side
+Upgrade, a client side Dial, and text messages in both directions.
+Pings are answered, fragmented messages are put back together and
+extensions and subprotocols aren't supported.
+*/
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+var (
+	//MaxMessageSize is the biggest message ReadMessage will read.
+	MaxMessageSize = 1 << 20
+
+	ErrNotWebSocket = errors.New("not a websocket handshake")
+	ErrTooBig       = errors.New("websocket message is too big")
+)
+
+//Conn is a WebSocket connection.  ReadMessage must only be
+//called from one goroutine at a time, WriteMessage and Close can
+//be called from any.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+	lock   sync.Mutex
+	closed bool
+}
+
+//Upgrade turns an http request into a WebSocket connection.  If
+//the request isn't a WebSocket handshake it responds with 400 and
+//returns ErrNotWebSocket.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, errors.New("websocket: the response can't be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", accept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+//Dial opens a WebSocket connection to a ws:// or wss:// url.  The
+//header is added to the handshake request (for an Authorization
+//header for example).
+func Dial(u string, header http.Header) (*Conn, error) {
+	return DialTLS(u, header, nil)
+}
+
+//DialTLS is Dial with the tls config to use for a wss:// url.
+func DialTLS(u string, header http.Header, cfg *tls.Config) (*Conn, error) {
+	addr, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	host := addr.Host
+	var conn net.Conn
+	switch addr.Scheme {
+	case "ws", "http":
+		if addr.Port() == "" {
+			host += ":80"
+		}
+		conn, err = net.Dial("tcp", host)
+	case "wss", "https":
+		if addr.Port() == "" {
+			host += ":443"
+		}
+		conn, err = tls.Dial("tcp", host, cfg)
+	default:
+		return nil, fmt.Errorf("websocket: unknown scheme %s", addr.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := make([]byte, 16)
+	rand.Read(k)
+	key := base64.StdEncoding.EncodeToString(k)
+
+	addr.Scheme = "http"
+	req, err := http.NewRequest("GET", addr.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: handshake failed: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != accept(key) {
+		conn.Close()
+		return nil, errors.New("websocket: bad Sec-WebSocket-Accept")
+	}
+	return &Conn{conn: conn, br: br, client: true}, nil
+}
+
+//ReadMessage returns the next text or binary message.  It returns
+//io.EOF once the other side has closed the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, data, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.write(opPong, data); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.write(opClose, nil)
+			c.Close()
+			return nil, io.EOF
+		}
+		msg = append(msg, data...)
+		if len(msg) > MaxMessageSize {
+			return nil, ErrTooBig
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+//WriteMessage sends data as a text message.
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.write(opText, data)
+}
+
+//Close sends a close frame (if it hasn't been sent yet) and closes
+//the connection.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.writeFrame(opClose, nil)
+	c.closed = true
+	c.lock.Unlock()
+	return c.conn.Close()
+}
+
+func (c *Conn) write(op byte, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	return c.writeFrame(op, data)
+}
+
+//writeFrame sends data in a single frame.  Frames sent by a client
+//have to be masked.
+func (c *Conn) writeFrame(op byte, data []byte) error {
+	hdr := []byte{0x80 | op, 0}
+	switch n := len(data); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	if c.client {
+		hdr[1] |= 0x80
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		hdr = append(hdr, mask...)
+		masked := make([]byte, len(data))
+		for i, b := range data {
+			masked[i] = b ^ mask[i%4]
+		}
+		data = masked
+	}
+	_, err := c.conn.Write(append(hdr, data...))
+	return err
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, data []byte, err error) {
+	hdr := make([]byte, 2)
+	if _, err = io.ReadFull(c.br, hdr); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		b := make([]byte, 2)
+		if _, err = io.ReadFull(c.br, b); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err = io.ReadFull(c.br, b); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b)
+	}
+	if n > uint64(MaxMessageSize) {
+		err = ErrTooBig
+		return
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(c.br, mask); err != nil {
+			return
+		}
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(c.br, data); err != nil {
+		return
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+func accept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+guid)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
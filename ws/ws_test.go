@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func echo(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(msg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}))
+}
+
+func TestEcho(t *testing.T) {
+	ts := echo(t)
+	defer ts.Close()
+
+	c, err := Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, s := range []string{"hello", strings.Repeat("x", 200), strings.Repeat("y", 70000)} {
+		if err := c.WriteMessage([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != s {
+			t.Errorf("got %d bytes, want %d", len(msg), len(s))
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	ts := echo(t)
+	defer ts.Close()
+
+	c, err := Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.write(opClose, nil)
+	if _, err := c.ReadMessage(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+	if err := c.WriteMessage([]byte("hi")); err == nil {
+		t.Error("expected an error writing to a closed connection")
+	}
+}
+
+func TestPing(t *testing.T) {
+	ts := echo(t)
+	defer ts.Close()
+
+	c, err := Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.write(opPing, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_, op, data, err := c.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != opPong || string(data) != "ping" {
+		t.Errorf("got %x %q, want a pong", op, data)
+	}
+}
+
+func TestNotWebSocket(t *testing.T) {
+	ts := echo(t)
+	defer ts.Close()
+
+	r, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", r.StatusCode)
+	}
+}